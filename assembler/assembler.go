@@ -7,6 +7,8 @@ import (
 	"io"
 	"strconv"
 	"strings"
+
+	"github.com/mingpepe/Nand2teris/diag"
 )
 
 type Assembler struct {
@@ -17,6 +19,19 @@ type Assembler struct {
 	labelTable    map[string]uint16
 	symbolTable   map[string]uint16
 	symbolAddress uint16
+	file          string
+	// Source line of every instruction
+	lines []int
+}
+
+// fieldError is reported at an offset of the instruction, e.g. at its comp field.
+type fieldError struct {
+	offset int
+	msg    string
+}
+
+func (e *fieldError) Error() string {
+	return e.msg
 }
 
 func New() *Assembler {
@@ -64,8 +79,10 @@ func New() *Assembler {
 		"-1":  0b0_111010_000000,
 		"D":   0b0_001100_000000,
 		"A":   0b0_110000_000000,
-		"-D":  0b0_001101_000000,
-		"-A":  0b0_110001_000000,
+		"!D":  0b0_001101_000000,
+		"!A":  0b0_110001_000000,
+		"-D":  0b0_001111_000000,
+		"-A":  0b0_110011_000000,
 		"D+1": 0b0_011111_000000,
 		"A+1": 0b0_110111_000000,
 		"D-1": 0b0_001110_000000,
@@ -86,6 +103,13 @@ func New() *Assembler {
 		"M-D": 0b1_000111_000000,
 		"D&M": 0b1_000000_000000,
 		"D|M": 0b1_010101_000000,
+		// Commutative forms
+		"A+D": 0b0_000010_000000,
+		"A&D": 0b0_000000_000000,
+		"A|D": 0b0_010101_000000,
+		"M+D": 0b1_000010_000000,
+		"M&D": 0b1_000000_000000,
+		"M|D": 0b1_010101_000000,
 	}
 	a.jumpTable = map[string]uint16{
 		"":    0b000,
@@ -110,28 +134,48 @@ func (a *Assembler) getNewSymboAddress(variable string) uint16 {
 	return address
 }
 
+// SetFile names the source in diagnostics.
+func (a *Assembler) SetFile(name string) {
+	a.file = name
+}
+
+// Compile returns the big-endian machine code, errors are diagnostics
+// pointing at the offending instruction.
 func (a *Assembler) Compile(reader io.Reader) ([]byte, error) {
 	buf := make([]byte, 0)
 	scanner := bufio.NewScanner(reader)
 	lines := make([]string, 0)
+	lineNums := make([]int, 0)
 	var lineCount uint16 = 0
+	lineNum := 0
 	for scanner.Scan() {
+		lineNum++
 		line := scanner.Text()
 		if !skip(line) {
-			if strings.HasPrefix(line, "(") && strings.HasSuffix(line, ")") {
-				label := line[1 : len(line)-1]
-				a.labelTable[label] = lineCount
+			label := strings.TrimSpace(line)
+			if strings.HasPrefix(label, "(") && strings.HasSuffix(label, ")") {
+				// Labels do not occupy ROM
+				a.labelTable[label[1:len(label)-1]] = lineCount
 			} else {
 				lines = append(lines, line)
+				lineNums = append(lineNums, lineNum)
+				lineCount += 1
 			}
-			lineCount += 1
 		}
 	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
 
-	for _, line := range lines {
+	a.lines = lineNums
+	for i, line := range lines {
 		binary, err := a.compileLine(line)
 		if err != nil {
-			return nil, err
+			col := len(line) - len(strings.TrimLeft(line, " \t")) + 1
+			if fe, ok := err.(*fieldError); ok {
+				col += fe.offset
+			}
+			return nil, diag.Errorf(a.file, lineNums[i], col, "%v", err)
 		}
 		buf = append(buf, binary...)
 	}
@@ -153,6 +197,9 @@ func skip(line string) bool {
 
 func (a *Assembler) compile_a_instr(line string) ([]byte, error) {
 	variable := line[1:]
+	if variable == "" {
+		return nil, &fieldError{1, "missing address after @"}
+	}
 	val, exist := a.builtInReg[variable]
 	if !exist {
 		val, exist = a.labelTable[variable]
@@ -183,11 +230,13 @@ func (a *Assembler) compile_c_instr(line string) ([]byte, error) {
 	dest := ""
 	comp := ""
 	jump := ""
+	compAt := 0
 	if index0 > -1 {
 		dest = line[:index0]
+		compAt = index0 + 1
 		if index1 > -1 {
-			jump = line[index1:]
-			comp = line[:index1]
+			jump = line[index1+1:]
+			comp = line[index0+1 : index1]
 		} else {
 			comp = line[index0+1:]
 		}
@@ -204,7 +253,7 @@ func (a *Assembler) compile_c_instr(line string) ([]byte, error) {
 	if exist {
 		ret |= value
 	} else {
-		return nil, fmt.Errorf("unknown dest(dest = %s, line = %s)", dest, line)
+		return nil, &fieldError{0, fmt.Sprintf("unknown dest %q", dest)}
 	}
 
 	if jump != "" {
@@ -212,7 +261,7 @@ func (a *Assembler) compile_c_instr(line string) ([]byte, error) {
 		if exist {
 			ret |= value
 		} else {
-			return nil, fmt.Errorf("unknown jump(jump = %s, line = %s)", jump, line)
+			return nil, &fieldError{index1 + 1, fmt.Sprintf("unknown jump %q", jump)}
 		}
 	}
 
@@ -220,7 +269,7 @@ func (a *Assembler) compile_c_instr(line string) ([]byte, error) {
 	if exist {
 		ret |= value
 	} else {
-		return nil, fmt.Errorf("unknown comp(comp = %s, line = %s)", comp, line)
+		return nil, &fieldError{compAt, fmt.Sprintf("unknown comp %q", comp)}
 	}
 
 	buf := make([]byte, 2)
@@ -231,7 +280,7 @@ func (a *Assembler) compile_c_instr(line string) ([]byte, error) {
 func (a *Assembler) compileLine(line string) ([]byte, error) {
 	index := strings.Index(line, "//")
 	if index != -1 {
-		line = line[:index-1]
+		line = line[:index]
 	}
 	line = strings.TrimSpace(line)
 	if line[0] == '@' {
@@ -0,0 +1,150 @@
+package assembler
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ReadHack reads the textual .hack format, one 16 digit binary word per
+// line, into the big-endian words Compile returns.
+func ReadHack(reader io.Reader) ([]byte, error) {
+	buf := make([]byte, 0)
+	scanner := bufio.NewScanner(reader)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if len(line) != 16 {
+			return nil, fmt.Errorf("line %d: expected 16 binary digits, got %q", lineNo, line)
+		}
+		word, err := strconv.ParseUint(line, 2, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		buf = append(buf, byte(word>>8), byte(word))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// registerNames are the built-in symbols shown for RAM addresses, R0 to R4
+// go by their VM names.
+var registerNames = map[uint16]string{
+	0:     "SP",
+	1:     "LCL",
+	2:     "ARG",
+	3:     "THIS",
+	4:     "THAT",
+	16384: "SCREEN",
+	24576: "KBD",
+}
+
+// Disassemble turns big-endian machine code back into assembly. An address
+// loaded into A is named when the next instruction uses it: a register or
+// a variable of symbols for a memory access, a label of symbols for a jump.
+// Labels of symbols are also written where they point. Symbols may be nil.
+// A word that is no instruction, such as data, is written as a comment, so
+// the addresses after it shift when the listing is assembled again.
+func Disassemble(program []byte, symbols *SymbolMap) (string, error) {
+	if len(program)%2 != 0 {
+		return "", fmt.Errorf("program has odd length: %d", len(program))
+	}
+	words := make([]uint16, len(program)/2)
+	for i := range words {
+		words[i] = binary.BigEndian.Uint16(program[2*i:])
+	}
+	var sb strings.Builder
+	for i, word := range words {
+		if symbols != nil {
+			for _, label := range symbols.Labels[uint16(i)] {
+				fmt.Fprintf(&sb, "(%s)\n", label)
+			}
+		}
+		var next uint16
+		if i+1 < len(words) {
+			next = words[i+1]
+		}
+		c, err := DisassembleInstruction(word, next, symbols)
+		if err != nil {
+			c = fmt.Sprintf("// ROM[%d]: %v", i, err)
+		}
+		sb.WriteString(c + "\n")
+	}
+	return sb.String(), nil
+}
+
+var (
+	destNames = reverse(New().destTable)
+	compNames = reverse(New().compTable)
+	jumpNames = reverse(New().jumpTable)
+)
+
+// DisassembleInstruction turns one instruction back into assembly, next is
+// the instruction after it, which tells how an address is used. Symbols
+// may be nil.
+func DisassembleInstruction(word, next uint16, symbols *SymbolMap) (string, error) {
+	if symbols == nil {
+		symbols = NewSymbolMap()
+	}
+	if word&0x8000 == 0 {
+		name := strconv.Itoa(int(word))
+		if next&0x8000 != 0 {
+			usesM := next&0x1000 != 0 || next&0b001_000 != 0
+			jumps := next&0b111 != 0
+			if labels := symbols.Labels[word]; jumps && len(labels) > 0 {
+				name = labels[0]
+			} else if v, ok := symbols.Variables[word]; usesM && ok {
+				name = v
+			} else if r, ok := registerNames[word]; usesM && ok {
+				name = r
+			} else if usesM && 5 <= word && word <= 15 {
+				name = fmt.Sprintf("R%d", word)
+			}
+		}
+		return "@" + name, nil
+	}
+
+	c, ok := compNames[word&0x1fc0]
+	if !ok {
+		return "", fmt.Errorf("unknown comp in %016b", word)
+	}
+	if d := destNames[word&0b111_000]; d != "" {
+		c = d + "=" + c
+	}
+	if j := jumpNames[word&0b111]; j != "" {
+		c += ";" + j
+	}
+	return c, nil
+}
+
+// aliases are the mnemonics the assembler accepts besides the ones of the
+// book.
+var aliases = map[string]bool{
+	"DM":  true,
+	"A+D": true,
+	"A&D": true,
+	"A|D": true,
+	"M+D": true,
+	"M&D": true,
+	"M|D": true,
+}
+
+// reverse maps codes back to mnemonics, skipping aliases.
+func reverse(table map[string]uint16) map[uint16]string {
+	r := make(map[uint16]string)
+	for name, code := range table {
+		if !aliases[name] {
+			r[code] = name
+		}
+	}
+	return r
+}
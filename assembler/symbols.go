@@ -0,0 +1,152 @@
+package assembler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mingpepe/Nand2teris/srcmap"
+)
+
+// SymbolMap names ROM addresses after labels and RAM addresses after
+// variables, several labels can share an address. Lines holds the source
+// line of every ROM address in File.
+type SymbolMap struct {
+	File      string
+	Lines     []int
+	Labels    map[uint16][]string
+	Variables map[uint16]string
+}
+
+func NewSymbolMap() *SymbolMap {
+	return &SymbolMap{Labels: make(map[uint16][]string), Variables: make(map[uint16]string)}
+}
+
+// Symbols returns the labels, variables and source lines of the last Compile.
+func (a *Assembler) Symbols() *SymbolMap {
+	m := NewSymbolMap()
+	m.File = a.file
+	m.Lines = append(m.Lines, a.lines...)
+	for name, address := range a.labelTable {
+		m.Labels[address] = append(m.Labels[address], name)
+	}
+	for _, names := range m.Labels {
+		sort.Strings(names)
+	}
+	for name, address := range a.symbolTable {
+		m.Variables[address] = name
+	}
+	return m
+}
+
+// Line returns the source line of a ROM address, 0 when unknown.
+func (m *SymbolMap) Line(address uint16) int {
+	if int(address) < len(m.Lines) {
+		return m.Lines[address]
+	}
+	return 0
+}
+
+// SourceMap returns the source lines as the map of the machine code, the
+// one keyed by ROM address, to compose with the maps of the VM code.
+func (m *SymbolMap) SourceMap() *srcmap.Map {
+	sm := srcmap.New(srcmap.ROM)
+	for address, line := range m.Lines {
+		sm.Set(address, srcmap.Location{File: m.File, Line: line})
+	}
+	return sm
+}
+
+// Write saves the map in the side file format, one entry per line:
+//
+//	file Max.asm
+//	line <ROM address> <source line>
+//	label <ROM address> <name>
+//	variable <RAM address> <name>
+func (m *SymbolMap) Write(writer io.Writer) error {
+	w := bufio.NewWriter(writer)
+	if m.File != "" {
+		fmt.Fprintf(w, "file %s\n", m.File)
+	}
+	for address, line := range m.Lines {
+		fmt.Fprintf(w, "line %d %d\n", address, line)
+	}
+	for _, address := range sortedKeys(m.Labels) {
+		for _, name := range m.Labels[address] {
+			fmt.Fprintf(w, "label %d %s\n", address, name)
+		}
+	}
+	addresses := make([]int, 0, len(m.Variables))
+	for address := range m.Variables {
+		addresses = append(addresses, int(address))
+	}
+	sort.Ints(addresses)
+	for _, address := range addresses {
+		fmt.Fprintf(w, "variable %d %s\n", address, m.Variables[uint16(address)])
+	}
+	return w.Flush()
+}
+
+func sortedKeys(labels map[uint16][]string) []uint16 {
+	keys := make([]int, 0, len(labels))
+	for address := range labels {
+		keys = append(keys, int(address))
+	}
+	sort.Ints(keys)
+	addresses := make([]uint16, len(keys))
+	for i, k := range keys {
+		addresses[i] = uint16(k)
+	}
+	return addresses
+}
+
+// ReadSymbolMap reads a side file written by Write.
+func ReadSymbolMap(reader io.Reader) (*SymbolMap, error) {
+	m := NewSymbolMap()
+	scanner := bufio.NewScanner(reader)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		// names are the rest of the line, they may hold spaces
+		if strings.HasPrefix(text, "file ") {
+			m.File = text[len("file "):]
+			continue
+		}
+		fields := strings.SplitN(text, " ", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: unexpected entry %q", lineNo, scanner.Text())
+		}
+		address, err := strconv.ParseUint(fields[1], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		switch fields[0] {
+		case "line":
+			line, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNo, err)
+			}
+			for len(m.Lines) <= int(address) {
+				m.Lines = append(m.Lines, 0)
+			}
+			m.Lines[address] = line
+		case "label":
+			m.Labels[uint16(address)] = append(m.Labels[uint16(address)], fields[2])
+		case "variable":
+			m.Variables[uint16(address)] = fields[2]
+		default:
+			return nil, fmt.Errorf("line %d: unknown entry %s", lineNo, fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
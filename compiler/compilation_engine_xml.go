@@ -3,8 +3,8 @@ package compiler
 import (
 	"fmt"
 	"io"
-	"log"
-	"runtime/debug"
+
+	"github.com/mingpepe/Nand2teris/jack/ast"
 )
 
 type CompilationEngineXml struct {
@@ -19,35 +19,6 @@ func NewCompilationEngineXml(tokenizer *Tokenizer, writer io.Writer) *Compilatio
 	return c
 }
 
-func (e *CompilationEngineXml) mustHaveTokeType(tokenType string) {
-	tok := e.tokenizer.TokenType()
-	if tok != tokenType {
-		debug.PrintStack()
-		msg := fmt.Sprintf("unexpected token type(expected : %s, actual : %s)", tokenType, tok)
-		log.Fatal(msg)
-	}
-}
-
-func (e *CompilationEngineXml) mustHaveKeyword(keyword string) {
-	e.mustHaveTokeType(KEYWORD)
-	key := e.tokenizer.Keyword()
-	if key != keyword {
-		debug.PrintStack()
-		msg := fmt.Sprintf("unexpected keyowrd(expected : %s, actaul : %s)", keyword, key)
-		log.Fatal(msg)
-	}
-}
-
-func (e *CompilationEngineXml) mustHaveSymol(symbol byte) {
-	e.mustHaveTokeType(SYMBOL)
-	sym := e.tokenizer.Symbol()
-	if sym != symbol {
-		debug.PrintStack()
-		msg := fmt.Sprintf("unexpected symbol(expected : %s, actaul %s)", string(symbol), string(sym))
-		log.Fatal(msg)
-	}
-}
-
 func (e *CompilationEngineXml) writeOutput(content string) {
 	e.writer.Write([]byte(content))
 }
@@ -57,412 +28,268 @@ func (e *CompilationEngineXml) writeOutputLine(content string) {
 }
 
 func (e *CompilationEngineXml) writeKeyword(keyword string) {
-	e.mustHaveKeyword(keyword)
 	tmp := fmt.Sprintf("<keyword>%s</keyword>", keyword)
 	e.writeOutputLine(tmp)
-	e.tokenizer.Advance()
 }
 
-func (e *CompilationEngineXml) writeIdentifier() {
-	e.mustHaveTokeType(IDENTIFIER)
-	id := e.tokenizer.Identifier()
-	tmp := fmt.Sprintf("<identifier>%s</identifier>", id)
+func (e *CompilationEngineXml) writeIdentifier(id ast.Ident) {
+	tmp := fmt.Sprintf("<identifier>%s</identifier>", id.Name)
 	e.writeOutputLine(tmp)
-	e.tokenizer.Advance()
 }
 
 func (e *CompilationEngineXml) writeSymbol(symbol byte) {
-	e.mustHaveSymol(symbol)
-	tmp := fmt.Sprintf("<symbol>%s</symbol>", string(symbol))
+	tmp := fmt.Sprintf("<symbol>%s</symbol>", op2xmlString(symbol))
 	e.writeOutputLine(tmp)
-	e.tokenizer.Advance()
 }
 
-func (e *CompilationEngineXml) CompileClass() {
+// writeType writes the keyword int, char, boolean or void, or a class name.
+func (e *CompilationEngineXml) writeType(id ast.Ident) {
+	switch id.Name {
+	case INT, CHAR, BOOLEAN, VOID:
+		e.writeKeyword(id.Name)
+	default:
+		e.writeIdentifier(id)
+	}
+}
+
+func (e *CompilationEngineXml) writeNames(names []ast.Ident) {
+	for i, name := range names {
+		if i > 0 {
+			e.writeSymbol(',')
+		}
+		e.writeIdentifier(name)
+	}
+}
+
+// CompileClass parses the class from the tokenizer and writes its parse
+// tree, or returns the syntax errors.
+func (e *CompilationEngineXml) CompileClass() error {
+	class, err := NewParser(e.tokenizer).ParseClass()
+	if err != nil {
+		return err
+	}
+	e.Compile(class)
+	return nil
+}
+
+// Compile writes the parse tree of a class.
+func (e *CompilationEngineXml) Compile(class *ast.Class) {
 	e.writeOutputLine("<class>")
 	e.writeKeyword(CLASS)
-	e.writeIdentifier()
+	e.writeIdentifier(class.Name)
 	e.writeSymbol('{')
-
-	for {
-		if e.tokenizer.TokenType() == KEYWORD {
-			keyword := e.tokenizer.Keyword()
-			if keyword == STATIC || keyword == FIELD {
-				e.CompileClassVarDec()
-				continue
-			}
-		}
-		break
+	for _, d := range class.Vars {
+		e.CompileClassVarDec(d)
 	}
-	for {
-		if e.tokenizer.TokenType() == KEYWORD {
-			keyword := e.tokenizer.Keyword()
-			if keyword == FUNCTION || keyword == CONSTRUCTOR || keyword == METHOD {
-				e.CompileSubroutineDec()
-				continue
-			}
-		}
-		break
+	for _, s := range class.Subroutines {
+		e.CompileSubroutineDec(s)
 	}
 	e.writeSymbol('}')
 	e.writeOutputLine("</class>")
 }
 
-func (e *CompilationEngineXml) CompileClassVarDec() {
+func (e *CompilationEngineXml) CompileClassVarDec(d *ast.ClassVarDec) {
 	e.writeOutputLine("<classVarDec>")
-	keyword := e.tokenizer.Keyword()
-	e.writeKeyword(keyword)
-	tokenType := e.tokenizer.TokenType()
-	if tokenType == KEYWORD {
-		// FIXME : not all keywords are allowed
-		keyword = e.tokenizer.Keyword()
-		e.writeKeyword(keyword)
-	} else if tokenType == IDENTIFIER {
-		e.writeIdentifier()
-	} else {
-		tmp := fmt.Sprintf("unexpected token type : %s", tokenType)
-		log.Fatal(tmp)
-	}
-
-	e.writeIdentifier()
-	if e.tokenizer.TokenType() == SYMBOL {
-		symbol := e.tokenizer.Symbol()
-		if symbol == ',' {
-			e.writeSymbol(',')
-			for {
-				e.writeIdentifier()
-				if e.tokenizer.TokenType() == SYMBOL && e.tokenizer.Symbol() == ';' {
-					e.writeSymbol(';')
-					break
-				}
-				e.writeSymbol(',')
-			}
-		} else if symbol == ';' {
-			e.writeSymbol(';')
-		} else {
-			tmp := fmt.Sprintf("unexpected symbol : %s", string(symbol))
-			log.Fatal(tmp)
-		}
-	} else {
-		tokenType := e.tokenizer.TokenType()
-		tmp := fmt.Sprintf("unexpected token type : %s", tokenType)
-		log.Fatal(tmp)
-	}
+	e.writeKeyword(d.Kind)
+	e.writeType(d.Type)
+	e.writeNames(d.Names)
+	e.writeSymbol(';')
 	e.writeOutputLine("</classVarDec>")
 }
 
-func (e *CompilationEngineXml) CompileSubroutineDec() {
+func (e *CompilationEngineXml) CompileSubroutineDec(s *ast.SubroutineDec) {
 	e.writeOutputLine("<subroutineDec>")
-	e.mustHaveTokeType(KEYWORD)
-	key := e.tokenizer.Keyword()
-
-	switch key {
-	case CONSTRUCTOR:
-		e.writeKeyword(CONSTRUCTOR)
-	case FUNCTION:
-		e.writeKeyword(FUNCTION)
-	case METHOD:
-		e.writeKeyword(METHOD)
-	default:
-		tmp := fmt.Sprintf("unexpected keyword : %s", key)
-		log.Fatal(tmp)
-	}
-
-	tokenType := e.tokenizer.TokenType()
-	if tokenType == KEYWORD {
-		key := e.tokenizer.CurrentToken()
-		e.writeKeyword(key)
-	} else if tokenType == IDENTIFIER {
-		e.writeIdentifier()
-	} else {
-		tmp := fmt.Sprintf("unexpected token type : %s", tokenType)
-		log.Fatal(tmp)
-	}
-
-	e.writeIdentifier()
+	e.writeKeyword(s.Kind)
+	e.writeType(s.ReturnType)
+	e.writeIdentifier(s.Name)
 	e.writeSymbol('(')
-	e.CompileParameterList()
+	e.CompileParameterList(s.Params)
 	e.writeSymbol(')')
-	e.CompileSubroutineBody()
+	e.CompileSubroutineBody(s)
 	e.writeOutputLine("</subroutineDec>")
 }
 
-func (e *CompilationEngineXml) CompileParameterList() {
+func (e *CompilationEngineXml) CompileParameterList(params []*ast.Param) {
 	e.writeOutputLine("<parameterList>")
-	if e.tokenizer.TokenType() == SYMBOL {
-		// No parameter
-		e.mustHaveSymol(')')
-	} else {
-		for {
-			if e.tokenizer.TokenType() == KEYWORD {
-				// FIXME : not all keywords are allowed
-				keyowrd := e.tokenizer.Keyword()
-				e.writeKeyword(keyowrd)
-			} else if e.tokenizer.TokenType() == IDENTIFIER {
-				e.writeIdentifier()
-			}
-			e.writeIdentifier()
-			if e.tokenizer.TokenType() == SYMBOL && e.tokenizer.Symbol() == ',' {
-				e.writeSymbol(',')
-			} else {
-				break
-			}
+	for i, param := range params {
+		if i > 0 {
+			e.writeSymbol(',')
 		}
-
+		e.writeType(param.Type)
+		e.writeIdentifier(param.Name)
 	}
 	e.writeOutputLine("</parameterList>")
 }
 
-func (e *CompilationEngineXml) CompileSubroutineBody() {
+func (e *CompilationEngineXml) CompileSubroutineBody(s *ast.SubroutineDec) {
 	e.writeOutputLine("<subroutineBody>")
 	e.writeSymbol('{')
-	for {
-		if e.tokenizer.TokenType() == KEYWORD && e.tokenizer.Keyword() == VAR {
-			e.CompileVarDec()
-		} else {
-			break
-		}
+	for _, d := range s.Locals {
+		e.CompileVarDec(d)
 	}
-	e.CompileStatements()
+	e.CompileStatements(s.Statements)
 	e.writeSymbol('}')
 	e.writeOutputLine("</subroutineBody>")
 }
 
-func (e *CompilationEngineXml) CompileVarDec() {
+func (e *CompilationEngineXml) CompileVarDec(d *ast.VarDec) {
 	e.writeOutputLine("<varDec>")
 	e.writeKeyword(VAR)
-
-	if e.tokenizer.TokenType() == IDENTIFIER {
-		e.writeIdentifier()
-	} else {
-		// Fixme : not all keyword are allowed here
-		keyword := e.tokenizer.Keyword()
-		e.writeKeyword(keyword)
-	}
-
-	for {
-		e.writeIdentifier()
-
-		e.mustHaveTokeType(SYMBOL)
-		symbol := e.tokenizer.Symbol()
-		if symbol == ',' {
-			e.writeSymbol(symbol)
-			continue
-		} else if symbol == ';' {
-			e.writeSymbol(symbol)
-			break
-		} else {
-			debug.PrintStack()
-			log.Fatal(fmt.Sprintf("unexpected symbol : %s", string(symbol)))
-		}
-	}
+	e.writeType(d.Type)
+	e.writeNames(d.Names)
+	e.writeSymbol(';')
 	e.writeOutputLine("</varDec>")
 }
 
-func (e *CompilationEngineXml) CompileStatements() {
+func (e *CompilationEngineXml) CompileStatements(statements []ast.Statement) {
 	e.writeOutputLine("<statements>")
-	if e.tokenizer.TokenType() == KEYWORD {
-		keep_going := true
-		for keep_going {
-			switch e.tokenizer.Keyword() {
-			case LET:
-				e.CompileLet()
-			case IF:
-				e.CompileIf()
-			case WHILE:
-				e.CompileWhile()
-			case DO:
-				e.CompileDo()
-			case RETURN:
-				e.CompileReturn()
-				keep_going = false
-			default:
-				keep_going = false
-			}
+	for _, s := range statements {
+		switch s := s.(type) {
+		case *ast.LetStatement:
+			e.CompileLet(s)
+		case *ast.IfStatement:
+			e.CompileIf(s)
+		case *ast.WhileStatement:
+			e.CompileWhile(s)
+		case *ast.DoStatement:
+			e.CompileDo(s)
+		case *ast.ReturnStatement:
+			e.CompileReturn(s)
 		}
 	}
 	e.writeOutputLine("</statements>")
 }
 
-func (e *CompilationEngineXml) CompileLet() {
+func (e *CompilationEngineXml) CompileLet(s *ast.LetStatement) {
 	e.writeOutputLine("<letStatement>")
 	e.writeKeyword(LET)
-	e.writeIdentifier()
-	if e.tokenizer.TokenType() == SYMBOL && e.tokenizer.Symbol() == '[' {
+	e.writeIdentifier(s.Name)
+	if s.Index != nil {
 		e.writeSymbol('[')
-		e.CompileExpression()
+		e.CompileExpression(s.Index)
 		e.writeSymbol(']')
 	}
 	e.writeSymbol('=')
-	e.CompileExpression()
+	e.CompileExpression(s.Value)
 	e.writeSymbol(';')
 	e.writeOutputLine("</letStatement>")
 }
 
-func (e *CompilationEngineXml) CompileIf() {
+func (e *CompilationEngineXml) CompileIf(s *ast.IfStatement) {
 	e.writeOutputLine("<ifStatement>")
 	e.writeKeyword(IF)
 	e.writeSymbol('(')
-	e.CompileExpression()
+	e.CompileExpression(s.Cond)
 	e.writeSymbol(')')
 	e.writeSymbol('{')
-	e.CompileStatements()
+	e.CompileStatements(s.Then.Statements)
 	e.writeSymbol('}')
-	if e.tokenizer.TokenType() == KEYWORD && e.tokenizer.Keyword() == ELSE {
+	if s.Else != nil {
 		e.writeKeyword(ELSE)
 		e.writeSymbol('{')
-		e.CompileStatements()
+		e.CompileStatements(s.Else.Statements)
 		e.writeSymbol('}')
 	}
 	e.writeOutputLine("</ifStatement>")
 }
 
-func (e *CompilationEngineXml) CompileWhile() {
+func (e *CompilationEngineXml) CompileWhile(s *ast.WhileStatement) {
 	e.writeOutputLine("<whileStatement>")
 	e.writeKeyword(WHILE)
 
 	e.writeSymbol('(')
-	e.CompileExpression()
+	e.CompileExpression(s.Cond)
 	e.writeSymbol(')')
 	e.writeSymbol('{')
-	e.CompileStatements()
+	e.CompileStatements(s.Body.Statements)
 	e.writeSymbol('}')
 	e.writeOutputLine("</whileStatement>")
 }
 
-func (e *CompilationEngineXml) CompileDo() {
+func (e *CompilationEngineXml) CompileDo(s *ast.DoStatement) {
 	e.writeOutputLine("<doStatement>")
 	e.writeKeyword(DO)
+	e.writeCall(s.Call)
+	e.writeSymbol(';')
+	e.writeOutputLine("</doStatement>")
+}
 
-	for {
-		e.writeIdentifier()
-		e.mustHaveTokeType(SYMBOL)
-		if e.tokenizer.Symbol() == '.' {
-			e.writeSymbol('.')
-		} else {
-			break
-		}
+func (e *CompilationEngineXml) writeCall(call *ast.CallExpr) {
+	if call.Receiver != nil {
+		e.writeIdentifier(*call.Receiver)
+		e.writeSymbol('.')
 	}
-
+	e.writeIdentifier(call.Name)
 	e.writeSymbol('(')
-	e.CompileExpressionList()
+	e.CompileExpressionList(call.Args)
 	e.writeSymbol(')')
-	e.writeSymbol(';')
-	e.writeOutputLine("</doStatement>")
 }
 
-func (e *CompilationEngineXml) CompileReturn() {
+func (e *CompilationEngineXml) CompileReturn(s *ast.ReturnStatement) {
 	e.writeOutputLine("<returnStatement>")
 	e.writeKeyword(RETURN)
-	if e.tokenizer.TokenType() == SYMBOL && e.tokenizer.Symbol() == ';' {
-		// Empty
-	} else {
-		e.CompileExpression()
+	if s.Value != nil {
+		e.CompileExpression(s.Value)
 	}
 	e.writeSymbol(';')
 	e.writeOutputLine("</returnStatement>")
 }
 
-func (e *CompilationEngineXml) CompileExpression() {
+func (e *CompilationEngineXml) CompileExpression(x ast.Expression) {
 	e.writeOutputLine("<expression>")
-	for {
-		e.CompileTerm()
-		if e.tokenizer.TokenType() == SYMBOL {
-			symbol := e.tokenizer.Symbol()
-			match := false
-			ops := []byte{'+', '-', '*', '/', '&', '|', '<', '>', '='}
-			for _, op := range ops {
-				if symbol == op {
-					match = true
-					tmp := op2xmlString(op)
-					e.writeOutputLine(fmt.Sprintf("<symbol>%s</symbol>", tmp))
-					e.tokenizer.Advance()
-					break
-				}
-			}
-			if match {
-				continue
-			}
-		}
-		break
-	}
+	e.writeTerms(x)
 	e.writeOutputLine("</expression>")
 }
 
-func (e *CompilationEngineXml) CompileTerm() {
-	e.writeOutputLine("<term>")
-	if e.tokenizer.TokenType() == STRING_CONST {
-		tmp := e.tokenizer.StringVal()
-		e.writeOutputLine(fmt.Sprintf("<stringConstant>%s</stringConstant>", tmp))
-		e.tokenizer.Advance()
-	} else if e.tokenizer.TokenType() == INT_CONST {
-		tmp := e.tokenizer.IntVal()
-		e.writeOutputLine(fmt.Sprintf("<integerConstant>%d</integerConstant>", tmp))
-		e.tokenizer.Advance()
-	} else if e.tokenizer.TokenType() == IDENTIFIER {
-		e.writeIdentifier()
-		if e.tokenizer.TokenType() == SYMBOL {
-			if e.tokenizer.Symbol() == '[' {
-				e.writeSymbol('[')
-				e.CompileExpression()
-				e.writeSymbol(']')
-			} else if e.tokenizer.Symbol() == '.' {
-				e.writeSymbol('.')
-				for {
-					e.writeIdentifier()
-					if e.tokenizer.TokenType() == SYMBOL && e.tokenizer.Symbol() == '.' {
-						e.writeSymbol('.')
-						continue
-					}
-					break
-				}
-				e.writeSymbol('(')
-				e.CompileExpressionList()
-				e.writeSymbol(')')
-			}
-		}
-	} else if e.tokenizer.TokenType() == KEYWORD {
-		// FIXME : not all keywords are allowed
-		keyword := e.tokenizer.Keyword()
-		e.writeKeyword(keyword)
-	} else if e.tokenizer.TokenType() == SYMBOL {
-		symbol := e.tokenizer.Symbol()
-		if symbol == '(' {
-			e.writeSymbol('(')
-			e.CompileExpression()
-			e.writeSymbol(')')
-		} else if symbol == '-' || symbol == '~' {
-			e.writeSymbol(symbol)
-			e.CompileTerm()
-		} else {
-			tmp := fmt.Sprintf("unexpected symbol : %s", string(symbol))
-			log.Fatal(tmp)
-		}
+// writeTerms flattens the chain of binary operators back to term (op term)*.
+func (e *CompilationEngineXml) writeTerms(x ast.Expression) {
+	b, ok := x.(*ast.BinaryExpr)
+	if !ok {
+		e.CompileTerm(x)
+		return
+	}
+	e.writeTerms(b.X)
+	e.writeSymbol(b.Op)
+	e.CompileTerm(b.Y)
+}
 
-	} else {
-		tokenType := e.tokenizer.TokenType()
-		tmp := fmt.Sprintf("unexpected token type : %s", tokenType)
-		log.Fatal(tmp)
+func (e *CompilationEngineXml) CompileTerm(x ast.Expression) {
+	e.writeOutputLine("<term>")
+	switch x := x.(type) {
+	case *ast.StringLiteral:
+		e.writeOutputLine(fmt.Sprintf("<stringConstant>%s</stringConstant>", x.Value))
+	case *ast.IntLiteral:
+		e.writeOutputLine(fmt.Sprintf("<integerConstant>%d</integerConstant>", x.Value))
+	case *ast.KeywordConst:
+		e.writeKeyword(x.Value)
+	case *ast.VarExpr:
+		e.writeIdentifier(x.Name)
+	case *ast.IndexExpr:
+		e.writeIdentifier(x.Name)
+		e.writeSymbol('[')
+		e.CompileExpression(x.Index)
+		e.writeSymbol(']')
+	case *ast.CallExpr:
+		e.writeCall(x)
+	case *ast.ParenExpr:
+		e.writeSymbol('(')
+		e.CompileExpression(x.X)
+		e.writeSymbol(')')
+	case *ast.UnaryExpr:
+		e.writeSymbol(x.Op)
+		e.CompileTerm(x.X)
 	}
 	e.writeOutputLine("</term>")
 }
 
-func (e *CompilationEngineXml) CompileExpressionList() {
+func (e *CompilationEngineXml) CompileExpressionList(args []ast.Expression) {
 	e.writeOutputLine("<expressionList>")
-	if e.tokenizer.TokenType() == SYMBOL && e.tokenizer.Symbol() == ')' {
-		goto END
-	}
-	for {
-		e.CompileExpression()
-		if e.tokenizer.TokenType() == SYMBOL {
-			if e.tokenizer.Symbol() == ',' {
-				e.writeSymbol(',')
-				continue
-			}
+	for i, arg := range args {
+		if i > 0 {
+			e.writeSymbol(',')
 		}
-		break
+		e.CompileExpression(arg)
 	}
-END:
 	e.writeOutputLine("</expressionList>")
 }
 
@@ -0,0 +1,189 @@
+package compiler
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mingpepe/Nand2teris/diag"
+)
+
+// Token is a lexeme of Jack source. Text is as written, string constants
+// keep their quotes. Line and Column start at 1, Offset is in bytes. Doc is
+// the text of the /** */ comment right before the token, if any.
+type Token struct {
+	Kind   string
+	Text   string
+	Line   int
+	Column int
+	Offset int
+	Doc    string
+}
+
+const maxIntConst = 32767
+
+// lexer scans Jack source byte by byte. Comments and whitespace may appear
+// between any two tokens, strings end at the next quote and have no escapes.
+type lexer struct {
+	src       []byte
+	file      string
+	pos       int
+	line      int
+	lineStart int
+	doc       string
+	tokens    []Token
+	errors    diag.List
+}
+
+// Lex splits the source into tokens. Lexical errors are reported and
+// skipped, so the tokens are usable even when there are errors.
+func Lex(src []byte, file string) ([]Token, diag.List) {
+	l := &lexer{src: src, file: file, line: 1}
+	for l.skipSpaceAndComments() {
+		l.next()
+	}
+	return l.tokens, l.errors
+}
+
+func (l *lexer) errorf(offset int, format string, args ...interface{}) {
+	l.errors = append(l.errors, diag.Errorf(l.file, l.line, offset-l.lineStart+1, format, args...))
+}
+
+func (l *lexer) newline() {
+	l.line++
+	l.lineStart = l.pos + 1
+}
+
+// skipSpaceAndComments returns false at the end of the source.
+func (l *lexer) skipSpaceAndComments() bool {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '\n':
+			l.newline()
+			l.pos++
+		case strings.IndexByte(sep, c) >= 0:
+			l.pos++
+		case l.hasPrefix("//"):
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		case l.hasPrefix("/*"):
+			start, line, lineStart := l.pos, l.line, l.lineStart
+			isDoc := l.hasPrefix("/**") && !l.hasPrefix("/**/")
+			l.pos += 2
+			for l.pos < len(l.src) && !l.hasPrefix("*/") {
+				if l.src[l.pos] == '\n' {
+					l.newline()
+				}
+				l.pos++
+			}
+			if l.pos >= len(l.src) {
+				l.line, l.lineStart = line, lineStart
+				l.errorf(start, "unterminated comment")
+				return false
+			}
+			l.pos += 2
+			if isDoc {
+				l.doc = docText(string(l.src[start+3 : l.pos-2]))
+			}
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+func (l *lexer) hasPrefix(s string) bool {
+	return bytes.HasPrefix(l.src[l.pos:], []byte(s))
+}
+
+func (l *lexer) add(kind string, start int) {
+	l.tokens = append(l.tokens, Token{
+		Kind:   kind,
+		Text:   string(l.src[start:l.pos]),
+		Line:   l.line,
+		Column: start - l.lineStart + 1,
+		Offset: start,
+		Doc:    l.doc,
+	})
+	l.doc = ""
+}
+
+// docText removes the leading stars and the indentation of the lines of a
+// doc comment.
+func docText(comment string) string {
+	lines := strings.Split(comment, "\n")
+	text := make([]string, 0, len(lines))
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		line = strings.TrimSpace(strings.TrimPrefix(line, "*"))
+		if line == "" && len(text) == 0 {
+			continue
+		}
+		text = append(text, line)
+	}
+	return strings.TrimSpace(strings.Join(text, "\n"))
+}
+
+// next scans the token at l.pos.
+func (l *lexer) next() {
+	start := l.pos
+	c := l.src[l.pos]
+	switch {
+	case strings.IndexByte(symbols, c) >= 0:
+		l.pos++
+		l.add(SYMBOL, start)
+	case c == '"':
+		l.pos++
+		for l.pos < len(l.src) && l.src[l.pos] != '"' && l.src[l.pos] != '\n' {
+			l.pos++
+		}
+		if l.pos < len(l.src) && l.src[l.pos] == '"' {
+			l.pos++
+		} else {
+			l.errorf(start, "unterminated string")
+		}
+		l.add(STRING_CONST, start)
+	case isDigit(c):
+		for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			l.pos++
+		}
+		text := string(l.src[start:l.pos])
+		if n, err := strconv.Atoi(text); err != nil || n > maxIntConst {
+			l.errorf(start, "integer constant %s is too large, the maximum is %d", text, maxIntConst)
+		}
+		l.add(INT_CONST, start)
+	case isLetter(c):
+		for l.pos < len(l.src) && (isLetter(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		kind := IDENTIFIER
+		if isKeyword(string(l.src[start:l.pos])) {
+			kind = KEYWORD
+		}
+		l.add(kind, start)
+	default:
+		r, size := utf8.DecodeRune(l.src[l.pos:])
+		l.errorf(start, "unexpected character %q", r)
+		l.pos += size
+	}
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_'
+}
+
+func isKeyword(s string) bool {
+	for _, keyword := range keywords {
+		if s == keyword {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,494 @@
+package compiler
+
+import (
+	"io"
+	"sort"
+
+	"github.com/mingpepe/Nand2teris/diag"
+	"github.com/mingpepe/Nand2teris/jack/ast"
+)
+
+// Parser builds the syntax tree of a class. Syntax errors do not stop it,
+// it synchronises at the next statement or class member and reports all
+// of them.
+type Parser struct {
+	tokenizer    *Tokenizer
+	errors       diag.List
+	skippedToEnd bool
+}
+
+// NewParser reads from a tokenizer positioned at the first token.
+func NewParser(tokenizer *Tokenizer) *Parser {
+	p := &Parser{}
+	p.tokenizer = tokenizer
+	return p
+}
+
+// Parse reads the class of a .jack file, file names the source in diagnostics.
+func Parse(reader io.Reader, file string) (*ast.Class, error) {
+	tokenizer := NewTokenizer(reader)
+	tokenizer.SetFile(file)
+	tokenizer.Parse()
+	tokenizer.Advance()
+	return NewParser(tokenizer).ParseClass()
+}
+
+// ParseClass returns the class and a diag.List of the lexical and syntax
+// errors. With errors the class only holds the declarations that parsed.
+func (p *Parser) ParseClass() (*ast.Class, error) {
+	c := &ast.Class{File: p.tokenizer.File()}
+	if p.try(func() {
+		c.Pos = p.pos()
+		c.Doc = p.tokenizer.Doc()
+		p.expectKeyword(CLASS)
+		c.Name = p.ident()
+		p.expectSymbol('{')
+	}) {
+		return c, p.result()
+	}
+
+	for !p.tokenizer.AtEnd() && !p.isSymbol('}') {
+		if p.try(func() { p.parseClassMember(c) }) {
+			p.skipClassMember()
+		}
+	}
+	// Recovery may have skipped the closing brace already
+	if !p.skippedToEnd {
+		p.try(func() {
+			c.End = p.pos()
+			p.expectSymbol('}')
+		})
+	}
+	return c, p.result()
+}
+
+// result merges the errors of the tokenizer with the syntax errors, in the
+// order of the source.
+func (p *Parser) result() error {
+	errors := append(diag.List{}, p.tokenizer.Errors()...)
+	errors = append(errors, p.errors...)
+	sort.SliceStable(errors, func(i, j int) bool {
+		if errors[i].Line != errors[j].Line {
+			return errors[i].Line < errors[j].Line
+		}
+		return errors[i].Column < errors[j].Column
+	})
+	return errors.Err()
+}
+
+func (p *Parser) parseClassMember(c *ast.Class) {
+	if p.tokenizer.TokenType() == KEYWORD {
+		switch p.tokenizer.Keyword() {
+		case STATIC, FIELD:
+			c.Vars = append(c.Vars, p.parseClassVarDec())
+			return
+		case FUNCTION, CONSTRUCTOR, METHOD:
+			c.Subroutines = append(c.Subroutines, p.parseSubroutineDec())
+			return
+		}
+	}
+	p.fail("expected class variable or subroutine, got '%s'", p.tokenizer.Text())
+}
+
+func (p *Parser) parseClassVarDec() *ast.ClassVarDec {
+	d := &ast.ClassVarDec{Pos: p.pos(), Doc: p.tokenizer.Doc(), Kind: p.tokenizer.Keyword()}
+	p.tokenizer.Advance()
+	d.Type = p.typeName(false)
+	d.Names = append(d.Names, p.ident())
+	for p.isSymbol(',') {
+		p.tokenizer.Advance()
+		d.Names = append(d.Names, p.ident())
+	}
+	if !p.isSymbol(';') {
+		p.fail("expected ',' or ';', got '%s'", p.tokenizer.Text())
+	}
+	p.tokenizer.Advance()
+	return d
+}
+
+func (p *Parser) parseSubroutineDec() *ast.SubroutineDec {
+	s := &ast.SubroutineDec{Pos: p.pos(), Doc: p.tokenizer.Doc(), Kind: p.tokenizer.Keyword()}
+	p.tokenizer.Advance()
+	s.ReturnType = p.typeName(true)
+	s.Name = p.ident()
+
+	p.expectSymbol('(')
+	if !p.isSymbol(')') {
+		for {
+			param := &ast.Param{}
+			param.Type = p.typeName(false)
+			param.Name = p.ident()
+			s.Params = append(s.Params, param)
+			if !p.isSymbol(',') {
+				break
+			}
+			p.tokenizer.Advance()
+		}
+	}
+	p.expectSymbol(')')
+
+	p.expectSymbol('{')
+	for p.isKeyword(VAR) {
+		s.Locals = append(s.Locals, p.parseVarDec())
+	}
+	s.Statements = p.parseStatements()
+	s.End = p.pos()
+	p.expectSymbol('}')
+	return s
+}
+
+func (p *Parser) parseVarDec() *ast.VarDec {
+	d := &ast.VarDec{Pos: p.pos()}
+	p.expectKeyword(VAR)
+	d.Type = p.typeName(false)
+	d.Names = append(d.Names, p.ident())
+	for p.isSymbol(',') {
+		p.tokenizer.Advance()
+		d.Names = append(d.Names, p.ident())
+	}
+	if !p.isSymbol(';') {
+		p.fail("expected ',' or ';', got '%s'", p.tokenizer.Text())
+	}
+	p.tokenizer.Advance()
+	return d
+}
+
+// parseStatements reads up to the closing '}', recovering from errors in a
+// statement at the next one.
+func (p *Parser) parseStatements() []ast.Statement {
+	statements := make([]ast.Statement, 0)
+	for !p.tokenizer.AtEnd() && !p.isSymbol('}') {
+		if p.isKeyword(FUNCTION) || p.isKeyword(CONSTRUCTOR) || p.isKeyword(METHOD) {
+			// Missing '}', leave it to the subroutine
+			break
+		}
+		var s ast.Statement
+		if p.try(func() { s = p.parseStatement() }) {
+			p.skipStatement()
+			continue
+		}
+		statements = append(statements, s)
+	}
+	return statements
+}
+
+func (p *Parser) parseStatement() ast.Statement {
+	if p.tokenizer.TokenType() == KEYWORD {
+		switch p.tokenizer.Keyword() {
+		case LET:
+			return p.parseLet()
+		case IF:
+			return p.parseIf()
+		case WHILE:
+			return p.parseWhile()
+		case DO:
+			return p.parseDo()
+		case RETURN:
+			return p.parseReturn()
+		}
+	}
+	p.fail("expected statement, got '%s'", p.tokenizer.Text())
+	return nil
+}
+
+func (p *Parser) parseLet() *ast.LetStatement {
+	s := &ast.LetStatement{Pos: p.pos()}
+	p.expectKeyword(LET)
+	s.Name = p.ident()
+	if p.isSymbol('[') {
+		p.tokenizer.Advance()
+		s.Index = p.parseExpression()
+		p.expectSymbol(']')
+	}
+	p.expectSymbol('=')
+	s.Value = p.parseExpression()
+	p.expectSymbol(';')
+	return s
+}
+
+func (p *Parser) parseIf() *ast.IfStatement {
+	s := &ast.IfStatement{Pos: p.pos()}
+	p.expectKeyword(IF)
+	p.expectSymbol('(')
+	s.Cond = p.parseExpression()
+	p.expectSymbol(')')
+	s.Then = p.parseBlock()
+	if p.isKeyword(ELSE) {
+		p.tokenizer.Advance()
+		s.Else = p.parseBlock()
+	}
+	return s
+}
+
+func (p *Parser) parseWhile() *ast.WhileStatement {
+	s := &ast.WhileStatement{Pos: p.pos()}
+	p.expectKeyword(WHILE)
+	p.expectSymbol('(')
+	s.Cond = p.parseExpression()
+	p.expectSymbol(')')
+	s.Body = p.parseBlock()
+	return s
+}
+
+func (p *Parser) parseBlock() *ast.Block {
+	b := &ast.Block{Pos: p.pos()}
+	p.expectSymbol('{')
+	b.Statements = p.parseStatements()
+	b.End = p.pos()
+	p.expectSymbol('}')
+	return b
+}
+
+func (p *Parser) parseDo() *ast.DoStatement {
+	s := &ast.DoStatement{Pos: p.pos()}
+	p.expectKeyword(DO)
+	s.Call = p.parseCall(p.ident())
+	p.expectSymbol(';')
+	return s
+}
+
+func (p *Parser) parseReturn() *ast.ReturnStatement {
+	s := &ast.ReturnStatement{Pos: p.pos()}
+	p.expectKeyword(RETURN)
+	if !p.isSymbol(';') {
+		s.Value = p.parseExpression()
+	}
+	p.expectSymbol(';')
+	return s
+}
+
+func (p *Parser) parseExpression() ast.Expression {
+	x := p.parseTerm()
+	for p.tokenizer.TokenType() == SYMBOL && isOp(p.tokenizer.Symbol()) {
+		b := &ast.BinaryExpr{OpPos: p.pos(), Op: p.tokenizer.Symbol(), X: x}
+		p.tokenizer.Advance()
+		b.Y = p.parseTerm()
+		x = b
+	}
+	return x
+}
+
+func isOp(symbol byte) bool {
+	switch symbol {
+	case '+', '-', '*', '/', '&', '|', '<', '>', '=':
+		return true
+	}
+	return false
+}
+
+func (p *Parser) parseTerm() ast.Expression {
+	pos := p.pos()
+	switch p.tokenizer.TokenType() {
+	case STRING_CONST:
+		x := &ast.StringLiteral{Pos: pos, Value: p.tokenizer.StringVal()}
+		p.tokenizer.Advance()
+		return x
+	case INT_CONST:
+		x := &ast.IntLiteral{Pos: pos, Value: p.tokenizer.IntVal()}
+		p.tokenizer.Advance()
+		return x
+	case KEYWORD:
+		switch keyword := p.tokenizer.Keyword(); keyword {
+		case TRUE, FALSE, NULL, THIS:
+			p.tokenizer.Advance()
+			return &ast.KeywordConst{Pos: pos, Value: keyword}
+		}
+	case IDENTIFIER:
+		name := p.ident()
+		if p.isSymbol('[') {
+			p.tokenizer.Advance()
+			x := &ast.IndexExpr{Name: name, Index: p.parseExpression()}
+			p.expectSymbol(']')
+			return x
+		}
+		if p.isSymbol('.') || p.isSymbol('(') {
+			return p.parseCall(name)
+		}
+		return &ast.VarExpr{Name: name}
+	case SYMBOL:
+		switch symbol := p.tokenizer.Symbol(); symbol {
+		case '(':
+			p.tokenizer.Advance()
+			x := &ast.ParenExpr{Pos: pos, X: p.parseExpression()}
+			p.expectSymbol(')')
+			return x
+		case '-', '~':
+			p.tokenizer.Advance()
+			return &ast.UnaryExpr{Pos: pos, Op: symbol, X: p.parseTerm()}
+		}
+	}
+	p.fail("expected expression, got '%s'", p.tokenizer.Text())
+	return nil
+}
+
+// parseCall reads the rest of "name(args)" or "name.sub(args)".
+func (p *Parser) parseCall(name ast.Ident) *ast.CallExpr {
+	call := &ast.CallExpr{Name: name}
+	if p.isSymbol('.') {
+		p.tokenizer.Advance()
+		receiver := name
+		call.Receiver = &receiver
+		call.Name = p.ident()
+	}
+	p.expectSymbol('(')
+	call.Args = make([]ast.Expression, 0)
+	if !p.isSymbol(')') {
+		for {
+			call.Args = append(call.Args, p.parseExpression())
+			if !p.isSymbol(',') {
+				break
+			}
+			p.tokenizer.Advance()
+		}
+	}
+	p.expectSymbol(')')
+	return call
+}
+
+func (p *Parser) pos() ast.Pos {
+	return ast.Pos{Line: p.tokenizer.Line(), Column: p.tokenizer.Column()}
+}
+
+func (p *Parser) ident() ast.Ident {
+	if p.tokenizer.TokenType() != IDENTIFIER {
+		p.fail("expected identifier, got '%s'", p.tokenizer.Text())
+	}
+	id := ast.Ident{Pos: p.pos(), Name: p.tokenizer.Identifier()}
+	p.tokenizer.Advance()
+	return id
+}
+
+// typeName reads int, char, boolean, a class name, or void for return types.
+func (p *Parser) typeName(void bool) ast.Ident {
+	if p.tokenizer.TokenType() == KEYWORD {
+		switch keyword := p.tokenizer.Keyword(); keyword {
+		case INT, CHAR, BOOLEAN, VOID:
+			if keyword != VOID || void {
+				id := ast.Ident{Pos: p.pos(), Name: keyword}
+				p.tokenizer.Advance()
+				return id
+			}
+		}
+	}
+	if p.tokenizer.TokenType() != IDENTIFIER {
+		p.fail("expected type, got '%s'", p.tokenizer.Text())
+	}
+	return p.ident()
+}
+
+func (p *Parser) isSymbol(symbol byte) bool {
+	return p.tokenizer.TokenType() == SYMBOL && p.tokenizer.Symbol() == symbol
+}
+
+func (p *Parser) isKeyword(keyword string) bool {
+	return p.tokenizer.TokenType() == KEYWORD && p.tokenizer.Keyword() == keyword
+}
+
+func (p *Parser) expectKeyword(keyword string) {
+	if !p.isKeyword(keyword) {
+		p.fail("expected '%s', got '%s'", keyword, p.tokenizer.Text())
+	}
+	p.tokenizer.Advance()
+}
+
+func (p *Parser) expectSymbol(symbol byte) {
+	if !p.isSymbol(symbol) {
+		p.fail("expected '%s', got '%s'", string(symbol), p.tokenizer.Text())
+	}
+	p.tokenizer.Advance()
+}
+
+// fail raises a syntax error at the current token, recovered by try.
+func (p *Parser) fail(format string, args ...interface{}) {
+	panic(p.tokenizer.Errorf(format, args...))
+}
+
+// try runs parse and records the syntax error it raises, if any. The
+// failing token is consumed when nothing else was, so recovery always
+// makes progress.
+func (p *Parser) try(parse func()) (failed bool) {
+	start := p.tokenizer.ptr
+	defer func() {
+		if r := recover(); r != nil {
+			d, ok := r.(*diag.Diagnostic)
+			if !ok {
+				panic(r)
+			}
+			p.errors = append(p.errors, d)
+			if p.tokenizer.ptr == start {
+				p.tokenizer.Advance()
+			}
+			failed = true
+		}
+	}()
+	parse()
+	return false
+}
+
+// skipStatement synchronises after an error in a statement: past the next
+// ';' or block, or before a '}' or a keyword starting a statement or
+// subroutine.
+func (p *Parser) skipStatement() {
+	for !p.tokenizer.AtEnd() {
+		switch p.tokenizer.TokenType() {
+		case SYMBOL:
+			switch p.tokenizer.Symbol() {
+			case ';':
+				p.tokenizer.Advance()
+				return
+			case '}':
+				return
+			case '{':
+				// The body of a broken if or while, with its else part
+				p.skipBlock()
+				if p.isKeyword(ELSE) {
+					p.tokenizer.Advance()
+					continue
+				}
+				return
+			}
+		case KEYWORD:
+			switch p.tokenizer.Keyword() {
+			case LET, DO, IF, WHILE, RETURN, FUNCTION, CONSTRUCTOR, METHOD:
+				return
+			}
+		}
+		p.tokenizer.Advance()
+	}
+	p.skippedToEnd = true
+}
+
+// skipBlock skips from '{' past the matching '}'.
+func (p *Parser) skipBlock() {
+	depth := 0
+	for !p.tokenizer.AtEnd() {
+		if p.tokenizer.TokenType() == SYMBOL {
+			switch p.tokenizer.Symbol() {
+			case '{':
+				depth++
+			case '}':
+				depth--
+			}
+		}
+		p.tokenizer.Advance()
+		if depth == 0 {
+			return
+		}
+	}
+	p.skippedToEnd = true
+}
+
+// skipClassMember synchronises after an error outside statements, before the
+// next class variable or subroutine declaration.
+func (p *Parser) skipClassMember() {
+	for !p.tokenizer.AtEnd() {
+		if p.tokenizer.TokenType() == KEYWORD {
+			switch p.tokenizer.Keyword() {
+			case STATIC, FIELD, FUNCTION, CONSTRUCTOR, METHOD:
+				return
+			}
+		}
+		p.tokenizer.Advance()
+	}
+	p.skippedToEnd = true
+}
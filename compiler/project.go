@@ -0,0 +1,106 @@
+package compiler
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/mingpepe/Nand2teris/diag"
+	"github.com/mingpepe/Nand2teris/jack/ast"
+	"github.com/mingpepe/Nand2teris/jack/check"
+	"github.com/mingpepe/Nand2teris/jack/index"
+	"github.com/mingpepe/Nand2teris/srcmap"
+)
+
+// Project is a whole Jack program: its parsed classes and the index of every
+// class it can use, the OS included.
+type Project struct {
+	Classes []*ast.Class
+	Index   *index.Index
+}
+
+// LoadProject parses the .jack files of a program. The OS API is read from
+// the .jack files of osDir, such as projects/12, or taken from the built-in
+// manifest when osDir is empty. Syntax errors of all files are returned
+// together.
+func LoadProject(filenames []string, osDir string) (*Project, error) {
+	p := &Project{}
+	p.Index = index.New()
+	var errors diag.List
+
+	if osDir != "" {
+		osFiles, err := filepath.Glob(filepath.Join(osDir, "*.jack"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(osFiles)
+		for _, filename := range osFiles {
+			class, err := parseFile(filename)
+			if err != nil {
+				errors = appendError(errors, err)
+				continue
+			}
+			p.Index.Add(class, true)
+		}
+	}
+
+	for _, filename := range filenames {
+		class, err := parseFile(filename)
+		if err != nil {
+			errors = appendError(errors, err)
+			continue
+		}
+		if err := p.Index.Add(class, false); err != nil {
+			errors = appendError(errors, err)
+			continue
+		}
+		p.Classes = append(p.Classes, class)
+	}
+	if osDir == "" {
+		p.Index.AddOS()
+	}
+	if len(errors) > 0 {
+		return nil, errors
+	}
+	return p, nil
+}
+
+func parseFile(filename string) (*ast.Class, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f, filename)
+}
+
+func appendError(errors diag.List, err error) diag.List {
+	switch err := err.(type) {
+	case diag.List:
+		return append(errors, err...)
+	case *diag.Diagnostic:
+		return append(errors, err)
+	}
+	return append(errors, &diag.Diagnostic{Severity: diag.Error, Message: err.Error()})
+}
+
+// Check runs the semantic checks on the classes of the program.
+func (p *Project) Check() diag.List {
+	return check.Check(p.Classes, p.Index)
+}
+
+// Compile writes the VM code of one class of the program.
+func (p *Project) Compile(class *ast.Class, writer io.Writer) error {
+	return p.CompileWithSourceMap(class, writer, nil)
+}
+
+// CompileWithSourceMap is Compile also recording in m where every line of
+// the VM code comes from, m may be nil.
+func (p *Project) CompileWithSourceMap(class *ast.Class, writer io.Writer, m *srcmap.Map) error {
+	e := newCompilationEngineVM(writer)
+	e.SetIndex(p.Index)
+	e.SetSourceMap(m)
+	e.Compile(class)
+	return e.errors.Err()
+}
@@ -1,9 +1,5 @@
 package compiler
 
-import (
-	"log"
-)
-
 type KIND int
 type SymbolTable struct {
 	table           map[string]Variable
@@ -26,6 +22,20 @@ const (
 	SYMBOL_NONE
 )
 
+func (kind KIND) String() string {
+	switch kind {
+	case SYMBOL_STATIC:
+		return "static"
+	case SYMBOL_FIELD:
+		return "field"
+	case SYMBOL_ARG:
+		return "argument"
+	case SYMBOL_VAR:
+		return "var"
+	}
+	return "none"
+}
+
 func KindToSegment(kind KIND) string {
 	switch kind {
 	case SYMBOL_STATIC:
@@ -36,10 +46,8 @@ func KindToSegment(kind KIND) string {
 		return "argument"
 	case SYMBOL_VAR:
 		return "local"
-	default:
-		log.Fatalf("unknown kind: %d", kind)
-		return ""
 	}
+	return ""
 }
 
 func NewSymbolTable() *SymbolTable {
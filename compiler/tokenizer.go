@@ -1,10 +1,11 @@
 package compiler
 
 import (
-	"bufio"
 	"io"
 	"strconv"
 	"strings"
+
+	"github.com/mingpepe/Nand2teris/diag"
 )
 
 // Token type
@@ -54,98 +55,98 @@ const sep = " \t\r\n"
 
 type Tokenizer struct {
 	reader io.Reader
-	tokens []string
+	tokens []Token
+	errors diag.List
 	ptr    int
+	file   string
 }
 
 func NewTokenizer(reader io.Reader) *Tokenizer {
 	t := &Tokenizer{}
 	t.reader = reader
-	t.tokens = make([]string, 0)
+	t.tokens = make([]Token, 0)
 	t.ptr = -1
 	return t
 }
 
+// Parse reads the whole source and splits it into tokens, see Lex.
 func (t *Tokenizer) Parse() {
-	buf := make([]rune, 0)
-	ptr := 0
-	scanner := bufio.NewScanner(t.reader)
-	multi_line_comments := false
-	for scanner.Scan() {
-		line := scanner.Text()
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, "//") {
-			continue
-		}
-
-		if strings.HasPrefix(line, "/*") {
-			if strings.HasSuffix(line, "*/") {
-				continue
-			} else {
-				multi_line_comments = true
-			}
-		}
-
-		if multi_line_comments {
-			// Assume that it's the end of line
-			idx := strings.Index(line, "*/")
-			if idx != -1 {
-				multi_line_comments = false
-			}
-			continue
-		}
-
-		idx := strings.Index(line, "//")
-		if idx != -1 {
-			line = line[:idx]
-		}
-
-		// Does not handle cross line string
-		string_start_flag := false
-		for _, b := range line {
-			if string_start_flag {
-				buf = append(buf, b)
-				ptr++
-				if b == '"' {
-					string_start_flag = false
-					s := string(buf[:ptr])
-					t.tokens = append(t.tokens, s)
-					ptr = 0
-					buf = make([]rune, 0)
-				}
-			} else {
-				if strings.Contains(sep, string(b)) {
-					if ptr != 0 {
-						s := string(buf[:ptr])
-						t.tokens = append(t.tokens, s)
-						ptr = 0
-						buf = make([]rune, 0)
-					}
-				} else if strings.Contains(symbols, string(b)) {
-					if ptr != 0 {
-						s := string(buf[:ptr])
-						t.tokens = append(t.tokens, s)
-						ptr = 0
-						buf = make([]rune, 0)
-					}
-					t.tokens = append(t.tokens, string(b))
-				} else {
-					buf = append(buf, b)
-					ptr++
-					if b == '"' {
-						string_start_flag = true
-					}
-				}
-			}
-
-		}
-		if ptr != 0 {
-			s := string(buf[:ptr])
-			t.tokens = append(t.tokens, s)
-			ptr = 0
-			buf = make([]rune, 0)
-		}
+	src, err := io.ReadAll(t.reader)
+	if err != nil {
+		t.errors = append(t.errors, diag.Errorf(t.file, 0, 0, "%v", err))
+	}
+	tokens, errors := Lex(src, t.file)
+	t.tokens = tokens
+	t.errors = append(t.errors, errors...)
+}
+
+// Errors returns the lexical errors found by Parse.
+func (t *Tokenizer) Errors() diag.List {
+	return t.errors
+}
+
+// SetFile names the source for diagnostics.
+func (t *Tokenizer) SetFile(name string) {
+	t.file = name
+}
+
+func (t *Tokenizer) File() string {
+	return t.file
+}
+
+// Line returns the line of the current token, or of the last token at the end of input.
+func (t *Tokenizer) Line() int {
+	if len(t.tokens) == 0 {
+		return 0
+	}
+	return t.tokens[t.index()].Line
+}
+
+// Column returns the 1-based byte column of the current token.
+func (t *Tokenizer) Column() int {
+	if len(t.tokens) == 0 {
+		return 0
+	}
+	return t.tokens[t.index()].Column
+}
+
+// Doc returns the doc comment before the current token.
+func (t *Tokenizer) Doc() string {
+	if t.AtEnd() {
+		return ""
+	}
+	return t.tokens[t.ptr].Doc
+}
+
+// Token returns the current token, the last one at the end of input.
+func (t *Tokenizer) Token() Token {
+	if len(t.tokens) == 0 {
+		return Token{}
+	}
+	return t.tokens[t.index()]
+}
+
+func (t *Tokenizer) index() int {
+	if t.ptr < 0 {
+		return 0
+	}
+	if t.ptr >= len(t.tokens) {
+		return len(t.tokens) - 1
 	}
+	return t.ptr
+}
+
+// Errorf builds a diagnostic at the current token.
+func (t *Tokenizer) Errorf(format string, args ...interface{}) *diag.Diagnostic {
+	if t.AtEnd() {
+		return diag.Errorf(t.file, t.Line(), t.Column(), "unexpected end of file")
+	}
+	return diag.Errorf(t.file, t.Line(), t.Column(), format, args...)
+}
+
+// AtEnd reports whether Advance moved past the last token.
+func (t *Tokenizer) AtEnd() bool {
+	return t.ptr >= len(t.tokens)
 }
 
 func (t *Tokenizer) HasMoreTokens() bool {
@@ -157,10 +158,12 @@ func (t *Tokenizer) Advance() {
 }
 
 func (t *Tokenizer) CurrentToken() string {
-	token := t.tokens[t.ptr]
+	if t.AtEnd() {
+		return ""
+	}
+	token := t.tokens[t.ptr].Text
 	if t.TokenType() == STRING_CONST {
-		length := len(token)
-		token = token[1 : length-1]
+		token = strings.TrimSuffix(token[1:], "\"")
 	} else if token == "<" {
 		token = "&lt;"
 	} else if token == ">" {
@@ -171,27 +174,19 @@ func (t *Tokenizer) CurrentToken() string {
 	return token
 }
 
-func (t *Tokenizer) TokenType() string {
-	token := t.tokens[t.ptr]
-	if strings.HasPrefix(token, "\"") {
-		return STRING_CONST
-	}
-
-	if strings.Contains(symbols, token) {
-		return SYMBOL
-	}
-
-	for _, keyword := range keywords {
-		if token == keyword {
-			return KEYWORD
-		}
+// Text returns the current token as written in the source.
+func (t *Tokenizer) Text() string {
+	if t.AtEnd() {
+		return ""
 	}
+	return t.tokens[t.ptr].Text
+}
 
-	_, err := strconv.Atoi(token)
-	if err == nil {
-		return INT_CONST
+func (t *Tokenizer) TokenType() string {
+	if t.AtEnd() {
+		return ""
 	}
-	return IDENTIFIER
+	return t.tokens[t.ptr].Kind
 }
 
 func (t *Tokenizer) Keyword() string {
@@ -3,10 +3,16 @@ package compiler
 import (
 	"io"
 	"strconv"
+
+	"github.com/mingpepe/Nand2teris/srcmap"
 )
 
 type VMWriter struct {
 	writer io.Writer
+	// Lines written so far and where the next ones come from
+	line      int
+	origin    srcmap.Location
+	sourceMap *srcmap.Map
 }
 
 func NewVMWriter(writer io.Writer) *VMWriter {
@@ -15,6 +21,16 @@ func NewVMWriter(writer io.Writer) *VMWriter {
 	return &vm
 }
 
+// SetSourceMap records in m the origin of every line written from now on.
+func (v *VMWriter) SetSourceMap(m *srcmap.Map) {
+	v.sourceMap = m
+}
+
+// SetOrigin sets the Jack line the next lines are generated for.
+func (v *VMWriter) SetOrigin(loc srcmap.Location) {
+	v.origin = loc
+}
+
 func (v *VMWriter) WritePush(segment string, index int) {
 	v.writeVMCode("push", segment, strconv.Itoa(index))
 }
@@ -52,7 +68,7 @@ func (v *VMWriter) WriteReturn() {
 }
 
 func (v *VMWriter) WriteComment(comment string) {
-	v.writer.Write([]byte("// " + comment + "\n"))
+	v.write("// " + comment + "\n")
 }
 
 func (v *VMWriter) writeVMCode(cmd string, args ...string) {
@@ -63,5 +79,13 @@ func (v *VMWriter) writeVMCode(cmd string, args ...string) {
 		cmd += " " + args[1]
 	}
 	cmd += "\n"
-	v.writer.Write([]byte(cmd))
+	v.write(cmd)
+}
+
+func (v *VMWriter) write(line string) {
+	v.line++
+	if v.sourceMap != nil && v.origin.Line > 0 {
+		v.sourceMap.Set(v.line, v.origin)
+	}
+	v.writer.Write([]byte(line))
 }
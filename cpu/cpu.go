@@ -0,0 +1,165 @@
+package cpu
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/mingpepe/Nand2teris/assembler"
+)
+
+const (
+	ROMSize = 32768
+	// Data memory ends with the keyboard register
+	RAMSize = KBD + 1
+
+	SCREEN = 16384
+	KBD    = 24576
+)
+
+type CPU struct {
+	ROM    [ROMSize]uint16
+	RAM    [RAMSize]uint16
+	A      uint16
+	D      uint16
+	PC     uint16
+	Cycles int
+	// KeyboardPolls counts the reads of the keyboard register
+	KeyboardPolls int
+}
+
+func New() *CPU {
+	return &CPU{}
+}
+
+// Load copies the big-endian word stream produced by assembler.Compile into ROM.
+func (c *CPU) Load(program []byte) error {
+	if len(program)%2 != 0 {
+		return fmt.Errorf("program has odd length: %d", len(program))
+	}
+	if len(program)/2 > ROMSize {
+		return fmt.Errorf("program too large: %d words", len(program)/2)
+	}
+	c.ROM = [ROMSize]uint16{}
+	for i := 0; i < len(program); i += 2 {
+		c.ROM[i/2] = binary.BigEndian.Uint16(program[i:])
+	}
+	c.Reset()
+	return nil
+}
+
+// LoadHack reads the textual .hack format, one 16 digit binary word per line.
+func (c *CPU) LoadHack(reader io.Reader) error {
+	program, err := assembler.ReadHack(reader)
+	if err != nil {
+		return err
+	}
+	return c.Load(program)
+}
+
+// Reset restarts execution at ROM[0], RAM is left untouched like the hardware reset.
+func (c *CPU) Reset() {
+	c.PC = 0
+	c.Cycles = 0
+}
+
+func (c *CPU) Step() error {
+	instr := c.ROM[c.PC]
+	if instr&0x8000 == 0 {
+		c.A = instr
+		c.PC = (c.PC + 1) & 0x7fff
+		c.Cycles++
+		return nil
+	}
+
+	address := c.A & 0x7fff
+	useM := instr&0x1000 != 0
+	writeM := instr&0b001_000 != 0
+	if (useM || writeM) && address >= RAMSize {
+		return fmt.Errorf("illegal memory address %d at ROM[%d]", address, c.PC)
+	}
+
+	y := c.A
+	if useM {
+		y = c.RAM[address]
+		if address == KBD {
+			c.KeyboardPolls++
+		}
+	}
+	out := ALU(c.D, y, (instr>>6)&0x3f)
+
+	if writeM {
+		c.RAM[address] = out
+	}
+	jumpTo := c.A & 0x7fff
+	if instr&0b100_000 != 0 {
+		c.A = out
+	}
+	if instr&0b010_000 != 0 {
+		c.D = out
+	}
+
+	if jump(out, instr&0b111) {
+		c.PC = jumpTo
+	} else {
+		c.PC = (c.PC + 1) & 0x7fff
+	}
+	c.Cycles++
+	return nil
+}
+
+// Run executes at most maxCycles instructions and returns how many were executed.
+// It stops early when the program reaches an "@X, 0;JMP" loop onto itself,
+// which is how Hack programs end.
+func (c *CPU) Run(maxCycles int) (int, error) {
+	for i := 0; i < maxCycles; i++ {
+		if c.Halted() {
+			return i, nil
+		}
+		if err := c.Step(); err != nil {
+			return i, err
+		}
+	}
+	return maxCycles, nil
+}
+
+func (c *CPU) Halted() bool {
+	at := c.ROM[c.PC]
+	next := c.ROM[(c.PC+1)&0x7fff]
+	return at == c.PC && next == 0b1110_1010_1000_0111 // @PC, 0;JMP
+}
+
+// ALU computes the Hack ALU output for the zx nx zy ny f no control bits.
+func ALU(x, y uint16, control uint16) uint16 {
+	if control&0b100000 != 0 {
+		x = 0
+	}
+	if control&0b010000 != 0 {
+		x = ^x
+	}
+	if control&0b001000 != 0 {
+		y = 0
+	}
+	if control&0b000100 != 0 {
+		y = ^y
+	}
+	var out uint16
+	if control&0b000010 != 0 {
+		out = x + y
+	} else {
+		out = x & y
+	}
+	if control&0b000001 != 0 {
+		out = ^out
+	}
+	return out
+}
+
+func jump(out uint16, bits uint16) bool {
+	neg := out&0x8000 != 0
+	zero := out == 0
+	pos := !neg && !zero
+	return (bits&0b100 != 0 && neg) ||
+		(bits&0b010 != 0 && zero) ||
+		(bits&0b001 != 0 && pos)
+}
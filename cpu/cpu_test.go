@@ -0,0 +1,88 @@
+package cpu
+
+import (
+	"os"
+	"testing"
+
+	"github.com/mingpepe/Nand2teris/assembler"
+)
+
+func loadAsm(t *testing.T, filename string) *CPU {
+	t.Helper()
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	program, err := assembler.New().Compile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := New()
+	if err := c.Load(program); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// runR2 runs the program on R0 and R1 until it halts and returns R2.
+func runR2(t *testing.T, c *CPU, r0, r1 int16) int16 {
+	t.Helper()
+	c.Reset()
+	c.RAM[0], c.RAM[1], c.RAM[2] = uint16(r0), uint16(r1), 0xffff
+	if _, err := c.Run(10000); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Halted() {
+		t.Fatalf("R0=%d R1=%d: not halted after %d cycles", r0, r1, c.Cycles)
+	}
+	return int16(c.RAM[2])
+}
+
+func TestMult(t *testing.T) {
+	c := loadAsm(t, "../projects/04/mult/Mult.asm")
+	tests := []struct {
+		r0, r1, want int16
+	}{
+		{0, 0, 0},
+		{1, 0, 0},
+		{0, 2, 0},
+		{3, 1, 3},
+		{2, 4, 8},
+		{6, 7, 42},
+		{181, 181, 32761},
+		{-3, 5, -15},
+		{3, -5, -15},
+		{-6, -7, 42},
+		{-1, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := runR2(t, c, tt.r0, tt.r1); got != tt.want {
+			t.Errorf("Mult(%d, %d) = %d, want %d", tt.r0, tt.r1, got, tt.want)
+		}
+	}
+}
+
+func TestMax(t *testing.T) {
+	for _, filename := range []string{"../projects/06/max/Max.asm", "../projects/06/max/MaxL.asm"} {
+		c := loadAsm(t, filename)
+		tests := []struct {
+			r0, r1, want int16
+		}{
+			{0, 0, 0},
+			{3, 5, 5},
+			{5, 3, 5},
+			{7, 7, 7},
+			{-3, 0, 0},
+			{0, -3, 0},
+			{-3, -5, -3},
+			{-5, -3, -3},
+			{12345, 23456, 23456},
+		}
+		for _, tt := range tests {
+			if got := runR2(t, c, tt.r0, tt.r1); got != tt.want {
+				t.Errorf("%s: max(%d, %d) = %d, want %d", filename, tt.r0, tt.r1, got, tt.want)
+			}
+		}
+	}
+}
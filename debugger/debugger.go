@@ -0,0 +1,348 @@
+package debugger
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mingpepe/Nand2teris/assembler"
+	"github.com/mingpepe/Nand2teris/cpu"
+	"github.com/mingpepe/Nand2teris/srcmap"
+)
+
+// Reason tells why the program stopped.
+type Reason int
+
+const (
+	Stepped Reason = iota
+	Breakpoint
+	Watchpoint
+	Halted
+	Limit
+)
+
+func (r Reason) String() string {
+	switch r {
+	case Stepped:
+		return "stepped"
+	case Breakpoint:
+		return "breakpoint"
+	case Watchpoint:
+		return "watchpoint"
+	case Halted:
+		return "halted"
+	case Limit:
+		return "cycle limit"
+	}
+	return fmt.Sprintf("reason(%d)", int(r))
+}
+
+// Stop is where and why the program stopped. For a watchpoint, Address is
+// the RAM cell and Old and New its values, otherwise Address is the PC.
+type Stop struct {
+	Reason  Reason
+	Address uint16
+	Old     uint16
+	New     uint16
+}
+
+// change is what one instruction did, enough to undo it.
+type change struct {
+	pc, a, d uint16
+	// RAM cell written and its previous value
+	wrote   bool
+	address uint16
+	old     uint16
+}
+
+// Debugger runs a Hack program on the CPU emulator under control, naming
+// addresses with the labels and variables of the assembler.
+type Debugger struct {
+	CPU     *cpu.CPU
+	Symbols *assembler.SymbolMap
+	// Maps resolve ROM addresses to the assembly, the maps of the VM and Jack
+	// code can be added
+	Maps *srcmap.Maps
+	// MaxCycles bounds a Continue or a Next
+	MaxCycles int
+
+	breakpoints map[uint16]bool
+	watchpoints map[uint16]bool
+	history     []change
+	historySize int
+}
+
+// New debugs the program loaded in c, symbols may be nil.
+func New(c *cpu.CPU, symbols *assembler.SymbolMap) *Debugger {
+	if symbols == nil {
+		symbols = assembler.NewSymbolMap()
+	}
+	d := &Debugger{
+		CPU:         c,
+		Symbols:     symbols,
+		Maps:        srcmap.NewMaps(),
+		MaxCycles:   10000000,
+		breakpoints: make(map[uint16]bool),
+		watchpoints: make(map[uint16]bool),
+		historySize: 100000,
+	}
+	d.Maps.Add(symbols.SourceMap())
+	return d
+}
+
+// SetHistory sets how many instructions Back can undo.
+func (d *Debugger) SetHistory(n int) {
+	d.historySize = n
+	if len(d.history) > n {
+		d.history = d.history[len(d.history)-n:]
+	}
+}
+
+// ROMAddress parses a ROM address given as a number or a label.
+func (d *Debugger) ROMAddress(name string) (uint16, error) {
+	if n, err := strconv.ParseUint(name, 10, 16); err == nil {
+		if n >= cpu.ROMSize {
+			return 0, fmt.Errorf("ROM address out of range: %d", n)
+		}
+		return uint16(n), nil
+	}
+	for address, labels := range d.Symbols.Labels {
+		for _, label := range labels {
+			if label == name {
+				return address, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unknown label %s", name)
+}
+
+var registers = map[string]uint16{
+	"SP":     0,
+	"LCL":    1,
+	"ARG":    2,
+	"THIS":   3,
+	"THAT":   4,
+	"SCREEN": cpu.SCREEN,
+	"KBD":    cpu.KBD,
+}
+
+// RAMAddress parses a RAM address given as a number, a built-in symbol such
+// as SP or R13, or a variable.
+func (d *Debugger) RAMAddress(name string) (uint16, error) {
+	if n, err := strconv.ParseUint(name, 10, 16); err == nil {
+		if n >= cpu.RAMSize {
+			return 0, fmt.Errorf("RAM address out of range: %d", n)
+		}
+		return uint16(n), nil
+	}
+	if address, ok := registers[name]; ok {
+		return address, nil
+	}
+	if strings.HasPrefix(name, "R") {
+		if n, err := strconv.Atoi(name[1:]); err == nil && 0 <= n && n <= 15 {
+			return uint16(n), nil
+		}
+	}
+	for address, variable := range d.Symbols.Variables {
+		if variable == name {
+			return address, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown variable %s", name)
+}
+
+// RAMName names a RAM address after a variable or a register, it returns
+// the empty string for other addresses.
+func (d *Debugger) RAMName(address uint16) string {
+	if v, ok := d.Symbols.Variables[address]; ok {
+		return v
+	}
+	for name, a := range registers {
+		if a == address {
+			return name
+		}
+	}
+	if 5 <= address && address <= 15 {
+		return fmt.Sprintf("R%d", address)
+	}
+	return ""
+}
+
+func (d *Debugger) AddBreakpoint(address uint16) {
+	d.breakpoints[address] = true
+}
+
+func (d *Debugger) RemoveBreakpoint(address uint16) {
+	delete(d.breakpoints, address)
+}
+
+func (d *Debugger) Breakpoints() []uint16 {
+	return sortedAddresses(d.breakpoints)
+}
+
+// Watch stops the program when the value of a RAM cell changes.
+func (d *Debugger) Watch(address uint16) {
+	d.watchpoints[address] = true
+}
+
+func (d *Debugger) Unwatch(address uint16) {
+	delete(d.watchpoints, address)
+}
+
+func (d *Debugger) Watchpoints() []uint16 {
+	return sortedAddresses(d.watchpoints)
+}
+
+func sortedAddresses(set map[uint16]bool) []uint16 {
+	keys := make([]int, 0, len(set))
+	for address := range set {
+		keys = append(keys, int(address))
+	}
+	sort.Ints(keys)
+	addresses := make([]uint16, len(keys))
+	for i, k := range keys {
+		addresses[i] = uint16(k)
+	}
+	return addresses
+}
+
+// Step executes one instruction, recording it for Back. It stops on a
+// watchpoint when the instruction changes a watched cell.
+func (d *Debugger) Step() (Stop, error) {
+	c := d.CPU
+	if c.Halted() {
+		return Stop{Reason: Halted, Address: c.PC}, nil
+	}
+	instr := c.ROM[c.PC]
+	ch := change{pc: c.PC, a: c.A, d: c.D}
+	if instr&0x8000 != 0 && instr&0b001_000 != 0 && c.A&0x7fff < cpu.RAMSize {
+		ch.wrote = true
+		ch.address = c.A & 0x7fff
+		ch.old = c.RAM[ch.address]
+	}
+	if err := c.Step(); err != nil {
+		return Stop{Reason: Stepped, Address: c.PC}, err
+	}
+	if d.historySize > 0 {
+		if len(d.history) >= d.historySize {
+			d.history = d.history[1:]
+		}
+		d.history = append(d.history, ch)
+	}
+	if ch.wrote && d.watchpoints[ch.address] && c.RAM[ch.address] != ch.old {
+		return Stop{Reason: Watchpoint, Address: ch.address, Old: ch.old, New: c.RAM[ch.address]}, nil
+	}
+	return Stop{Reason: Stepped, Address: c.PC}, nil
+}
+
+// Continue runs until a breakpoint, a watchpoint, the end of the program or
+// MaxCycles instructions.
+func (d *Debugger) Continue() (Stop, error) {
+	return d.runUntil(func() bool { return false })
+}
+
+// runUntil steps until done returns true after an instruction, or the
+// program stops for another reason.
+func (d *Debugger) runUntil(done func() bool) (Stop, error) {
+	for i := 0; i < d.MaxCycles; i++ {
+		stop, err := d.Step()
+		if err != nil || stop.Reason != Stepped {
+			return stop, err
+		}
+		if done() {
+			return stop, nil
+		}
+		if d.breakpoints[d.CPU.PC] {
+			return Stop{Reason: Breakpoint, Address: d.CPU.PC}, nil
+		}
+	}
+	return Stop{Reason: Limit, Address: d.CPU.PC}, nil
+}
+
+// Next steps over a VM command, calls included. With the maps of the VM
+// code it runs to the next VM command of the current function or its
+// caller, recursive calls of the same command run in a frame of their own,
+// with a greater LCL. Without them it runs until a call returns when it is
+// at the jump ending the calling sequence, the one followed by a
+// RETURN_LABEL, and is Step otherwise.
+func (d *Debugger) Next() (Stop, error) {
+	c := d.CPU
+	if start, ok := d.sourceAt(c.PC, vmDepth); ok {
+		lcl := c.RAM[1]
+		return d.runUntil(func() bool {
+			loc, ok := d.sourceAt(c.PC, vmDepth)
+			return ok && c.RAM[1] <= lcl && (loc.File != start.File || loc.Line != start.Line)
+		})
+	}
+	if !d.isCall(c.PC) {
+		return d.Step()
+	}
+	ret := (c.PC + 1) & 0x7fff
+	// a recursive call returning to the same label does so higher on the
+	// stack
+	sp := c.RAM[0]
+	return d.runUntil(func() bool { return c.PC == ret && c.RAM[0] <= sp })
+}
+
+// Depths of the source chain of a ROM address
+const (
+	asmDepth = iota
+	vmDepth
+	jackDepth
+)
+
+// sourceAt returns the location at depth of the source chain of a ROM
+// address.
+func (d *Debugger) sourceAt(address uint16, depth int) (srcmap.Location, bool) {
+	chain := d.Source(address)
+	if len(chain) <= depth {
+		return srcmap.Location{}, false
+	}
+	return chain[depth], true
+}
+
+func (d *Debugger) isCall(address uint16) bool {
+	instr := d.CPU.ROM[address]
+	if instr&0x8000 == 0 || instr&0b111 == 0 {
+		return false
+	}
+	for _, label := range d.Symbols.Labels[(address+1)&0x7fff] {
+		if strings.HasPrefix(label, "RETURN_LABEL") {
+			return true
+		}
+	}
+	return false
+}
+
+// Back undoes the last n instructions at most and returns how many were.
+func (d *Debugger) Back(n int) int {
+	c := d.CPU
+	i := 0
+	for ; i < n && len(d.history) > 0; i++ {
+		ch := d.history[len(d.history)-1]
+		d.history = d.history[:len(d.history)-1]
+		c.PC, c.A, c.D = ch.pc, ch.a, ch.d
+		if ch.wrote {
+			c.RAM[ch.address] = ch.old
+		}
+		c.Cycles--
+	}
+	return i
+}
+
+// Instruction disassembles the instruction at a ROM address with names.
+func (d *Debugger) Instruction(address uint16) string {
+	c := d.CPU
+	s, err := assembler.DisassembleInstruction(c.ROM[address], c.ROM[(address+1)&0x7fff], d.Symbols)
+	if err != nil {
+		return err.Error()
+	}
+	return s
+}
+
+// Source resolves a ROM address to the assembly, VM and Jack lines it comes
+// from, as far as symbols and maps know.
+func (d *Debugger) Source(address uint16) []srcmap.Location {
+	return d.Maps.Resolve(srcmap.ROM, int(address))
+}
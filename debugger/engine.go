@@ -0,0 +1,112 @@
+package debugger
+
+import (
+	"strings"
+
+	"github.com/mingpepe/Nand2teris/cpu"
+	"github.com/mingpepe/Nand2teris/srcmap"
+	"github.com/mingpepe/Nand2teris/vm"
+)
+
+// Engine executes a Jack program for the Jack debugger, as VM code on the
+// VM emulator or as machine code on the CPU emulator. Both keep the stack
+// and the call frames in RAM the same way.
+type Engine interface {
+	// Step executes one VM command or one instruction.
+	Step() error
+	Halted() bool
+	Memory() []uint16
+	// PC is the address of the next step, a return address is one too.
+	PC() int
+	// Source returns the Jack line the code at an address was generated
+	// for, it is false for code without Jack source.
+	Source(address int) (srcmap.Location, bool)
+	// Starts tells whether a VM command starts at an address. The Jack
+	// debugger only stops there, in the middle of a call or a return the
+	// frame is half made.
+	Starts(address int) bool
+}
+
+// jackSource returns the end of the source chain when it is Jack code.
+func jackSource(chain []srcmap.Location) (srcmap.Location, bool) {
+	if len(chain) == 0 || !strings.HasSuffix(chain[len(chain)-1].File, ".jack") {
+		return srcmap.Location{}, false
+	}
+	return chain[len(chain)-1], true
+}
+
+// sources resolves the Jack lines of n addresses once, a lookup per step
+// would slow the run down.
+type sources struct {
+	locs   []srcmap.Location
+	known  []bool
+	starts []bool
+}
+
+// newSources resolves the source chains of n addresses, vmDepth of a chain
+// is the VM command of the address.
+func newSources(n int, vmDepth int, resolve func(address int) []srcmap.Location) *sources {
+	s := &sources{locs: make([]srcmap.Location, n), known: make([]bool, n), starts: make([]bool, n)}
+	var last srcmap.Location
+	for i := 0; i < n; i++ {
+		chain := resolve(i)
+		s.locs[i], s.known[i] = jackSource(chain)
+		if len(chain) > vmDepth {
+			s.starts[i] = chain[vmDepth] != last
+			last = chain[vmDepth]
+		} else {
+			last = srcmap.Location{}
+		}
+	}
+	return s
+}
+
+func (s *sources) Starts(address int) bool {
+	return address >= 0 && address < len(s.starts) && s.starts[address]
+}
+
+func (s *sources) Source(address int) (srcmap.Location, bool) {
+	if address < 0 || address >= len(s.locs) {
+		return srcmap.Location{}, false
+	}
+	return s.locs[address], s.known[address]
+}
+
+type vmEngine struct {
+	e *vm.Emulator
+	*sources
+}
+
+// NewVMEngine runs a program loaded and started in the VM emulator, maps
+// hold the source maps of its .vm files.
+func NewVMEngine(e *vm.Emulator, maps *srcmap.Maps) Engine {
+	program := e.Program()
+	return &vmEngine{e: e, sources: newSources(len(program), 0, func(i int) []srcmap.Location {
+		// the emulator names the commands of a file after its class
+		loc := srcmap.Location{File: program[i].File + ".vm", Line: program[i].Line, Function: program[i].Function}
+		return append([]srcmap.Location{loc}, maps.Resolve(loc.File, loc.Line)...)
+	})}
+}
+
+func (v *vmEngine) Step() error      { return v.e.Step() }
+func (v *vmEngine) Halted() bool     { return v.e.Halted }
+func (v *vmEngine) Memory() []uint16 { return v.e.RAM[:] }
+func (v *vmEngine) PC() int          { return v.e.PC }
+
+type cpuEngine struct {
+	c *cpu.CPU
+	*sources
+}
+
+// NewCPUEngine runs a program loaded in the CPU emulator, maps hold the
+// source maps from ROM addresses to the Jack code.
+func NewCPUEngine(c *cpu.CPU, maps *srcmap.Maps) Engine {
+	return &cpuEngine{c: c, sources: newSources(cpu.ROMSize, vmDepth, func(address int) []srcmap.Location {
+		return maps.Resolve(srcmap.ROM, address)
+	})}
+}
+
+func (c *cpuEngine) Step() error      { return c.c.Step() }
+func (c *cpuEngine) Halted() bool     { return c.c.Halted() }
+func (c *cpuEngine) Memory() []uint16 { return c.c.RAM[:] }
+func (c *cpuEngine) PC() int          { return int(c.c.PC) }
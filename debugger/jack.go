@@ -0,0 +1,331 @@
+package debugger
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mingpepe/Nand2teris/compiler"
+	"github.com/mingpepe/Nand2teris/jack/ast"
+	"github.com/mingpepe/Nand2teris/srcmap"
+)
+
+// Variable is a variable of a subroutine where the compiler put it.
+type Variable struct {
+	Name    string
+	Type    string
+	Segment string
+	Index   int
+}
+
+// subroutine holds the variables a frame of a subroutine sees.
+type subroutine struct {
+	args   []Variable
+	locals []Variable
+	fields []Variable
+}
+
+// subroutines finds the variables of every subroutine of the classes with
+// the symbol table of the compiler.
+func subroutines(classes []*ast.Class) map[string]*subroutine {
+	subs := make(map[string]*subroutine)
+	for _, class := range classes {
+		table := compiler.NewSymbolTable()
+		fields := make([]Variable, 0)
+		for _, d := range class.Vars {
+			kind := compiler.SYMBOL_FIELD
+			if d.Kind == compiler.STATIC {
+				kind = compiler.SYMBOL_STATIC
+			}
+			for _, name := range d.Names {
+				table.Define(name.Name, d.Type.Name, kind)
+				if kind == compiler.SYMBOL_FIELD {
+					fields = append(fields, variable(table, name.Name))
+				}
+			}
+		}
+		for _, s := range class.Subroutines {
+			table.StartSubroutine()
+			sub := &subroutine{}
+			if s.Kind != compiler.FUNCTION {
+				sub.fields = fields
+			}
+			for _, p := range s.Params {
+				table.Define(p.Name.Name, p.Type.Name, compiler.SYMBOL_ARG)
+				v := variable(table, p.Name.Name)
+				if s.Kind == compiler.METHOD {
+					// argument 0 is this
+					v.Index++
+				}
+				sub.args = append(sub.args, v)
+			}
+			for _, d := range s.Locals {
+				for _, name := range d.Names {
+					table.Define(name.Name, d.Type.Name, compiler.SYMBOL_VAR)
+					sub.locals = append(sub.locals, variable(table, name.Name))
+				}
+			}
+			subs[class.Name.Name+"."+s.Name.Name] = sub
+		}
+	}
+	return subs
+}
+
+func variable(table *compiler.SymbolTable, name string) Variable {
+	return Variable{
+		Name:    name,
+		Type:    table.TypeOf(name),
+		Segment: compiler.KindToSegment(table.KindOf(name)),
+		Index:   table.IndexOf(name),
+	}
+}
+
+// Frame is a subroutine call in progress. LCL, ARG and THIS are the bases
+// of its segments, Location the line it is at.
+type Frame struct {
+	Location srcmap.Location
+	LCL      uint16
+	ARG      uint16
+	THIS     uint16
+}
+
+// Binding is a variable of a frame and its value.
+type Binding struct {
+	Variable
+	Value uint16
+}
+
+// JackDebugger runs a Jack program line by line on an engine.
+type JackDebugger struct {
+	Engine Engine
+	// MaxSteps bounds a run between two stops
+	MaxSteps int
+
+	subroutines map[string]*subroutine
+	// source files by base name, and their lines once read
+	files       map[string]string
+	lines       map[string][]string
+	breakpoints map[string]bool
+}
+
+// NewJack debugs the program of the classes, the engine must run their
+// code with source maps.
+func NewJack(engine Engine, classes []*ast.Class) *JackDebugger {
+	d := &JackDebugger{
+		Engine:      engine,
+		MaxSteps:    100000000,
+		subroutines: subroutines(classes),
+		files:       make(map[string]string),
+		lines:       make(map[string][]string),
+		breakpoints: make(map[string]bool),
+	}
+	for _, class := range classes {
+		d.files[base(class.File)] = class.File
+	}
+	return d
+}
+
+func base(name string) string {
+	return name[strings.LastIndexAny(name, "/\\")+1:]
+}
+
+func breakpointKey(file string, line int) string {
+	return fmt.Sprintf("%s:%d", base(file), line)
+}
+
+// ParseBreakpoint parses "Main.jack:12", the extension may be left out.
+func ParseBreakpoint(s string) (string, int, error) {
+	idx := strings.LastIndex(s, ":")
+	if idx < 0 {
+		return "", 0, fmt.Errorf("expected FILE:LINE, got %s", s)
+	}
+	line, err := strconv.Atoi(s[idx+1:])
+	if err != nil || line < 1 {
+		return "", 0, fmt.Errorf("invalid line %s", s[idx+1:])
+	}
+	file := s[:idx]
+	if !strings.HasSuffix(file, ".jack") {
+		file += ".jack"
+	}
+	return file, line, nil
+}
+
+func (d *JackDebugger) AddBreakpoint(file string, line int) {
+	d.breakpoints[breakpointKey(file, line)] = true
+}
+
+func (d *JackDebugger) RemoveBreakpoint(file string, line int) {
+	delete(d.breakpoints, breakpointKey(file, line))
+}
+
+func (d *JackDebugger) Breakpoints() []string {
+	keys := make([]string, 0, len(d.breakpoints))
+	for key := range d.breakpoints {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Location returns the Jack line the program is at.
+func (d *JackDebugger) Location() (srcmap.Location, bool) {
+	return d.Engine.Source(d.Engine.PC())
+}
+
+// run steps the engine until it reaches the start of a line. The line
+// counts when the frame is at a depth within accepts, given the LCL of the
+// frame, and differs from the last line at such a depth. Breakpoints stop
+// it at any depth on entering their line, which returning from a call
+// does not.
+func (d *JackDebugger) run(accepts func(lcl uint16) bool) (Reason, error) {
+	e := d.Engine
+	ram := e.Memory()
+	start, _ := d.Location()
+	last, lastAccepted := start, start
+	lastLCL := ram[1]
+	for i := 0; i < d.MaxSteps; i++ {
+		if e.Halted() {
+			return Halted, nil
+		}
+		if err := e.Step(); err != nil {
+			return Stepped, err
+		}
+		pc := e.PC()
+		loc, ok := e.Source(pc)
+		if !ok || !e.Starts(pc) {
+			continue
+		}
+		lcl := ram[1]
+		entered := (loc.File != last.File || loc.Line != last.Line) && lcl >= lastLCL
+		last, lastLCL = loc, lcl
+		if entered && d.breakpoints[breakpointKey(loc.File, loc.Line)] {
+			return Breakpoint, nil
+		}
+		if !accepts(lcl) {
+			continue
+		}
+		if loc.File != lastAccepted.File || loc.Line != lastAccepted.Line {
+			return Stepped, nil
+		}
+		lastAccepted = loc
+	}
+	return Limit, nil
+}
+
+// Step runs to the next line, into the subroutines it calls.
+func (d *JackDebugger) Step() (Reason, error) {
+	return d.run(func(lcl uint16) bool { return true })
+}
+
+// Next runs to the next line of the current subroutine, or of its caller
+// once it returns. The frames of the calls have a greater LCL.
+func (d *JackDebugger) Next() (Reason, error) {
+	lcl := d.Engine.Memory()[1]
+	return d.run(func(l uint16) bool { return l <= lcl })
+}
+
+// Finish runs until the current subroutine returns.
+func (d *JackDebugger) Finish() (Reason, error) {
+	lcl := d.Engine.Memory()[1]
+	return d.run(func(l uint16) bool { return l < lcl })
+}
+
+// Continue runs to a breakpoint or the end of the program.
+func (d *JackDebugger) Continue() (Reason, error) {
+	return d.run(func(lcl uint16) bool { return false })
+}
+
+// Frames returns the call stack from the frames saved in RAM, the current
+// subroutine first. It ends at the first caller without Jack source.
+func (d *JackDebugger) Frames() []Frame {
+	ram := d.Engine.Memory()
+	loc, _ := d.Location()
+	lcl, arg, this := ram[1], ram[2], ram[3]
+	frames := make([]Frame, 0)
+	for {
+		frames = append(frames, Frame{Location: loc, LCL: lcl, ARG: arg, THIS: this})
+		if lcl < 5 {
+			break
+		}
+		caller, ok := d.Engine.Source(int(int16(ram[lcl-5])))
+		if !ok || ram[lcl-4] >= lcl {
+			break
+		}
+		loc = caller
+		lcl, arg, this = ram[lcl-4], ram[lcl-3], ram[lcl-2]
+	}
+	return frames
+}
+
+// Variables returns the arguments, the locals and the fields of this of a
+// frame. The fields are left out while this is not set.
+func (d *JackDebugger) Variables(f Frame) []Binding {
+	sub := d.subroutines[f.Location.Function]
+	if sub == nil {
+		return nil
+	}
+	ram := d.Engine.Memory()
+	bindings := make([]Binding, 0)
+	bind := func(vars []Variable, base uint16) {
+		for _, v := range vars {
+			address := int(base) + v.Index
+			if address < len(ram) {
+				bindings = append(bindings, Binding{Variable: v, Value: ram[address]})
+			}
+		}
+	}
+	bind(sub.args, f.ARG)
+	bind(sub.locals, f.LCL)
+	if f.THIS != 0 {
+		bind(sub.fields, f.THIS)
+	}
+	return bindings
+}
+
+// FormatValue shows a word as a value of a Jack type.
+func FormatValue(typ string, v uint16) string {
+	switch typ {
+	case compiler.INT:
+		return strconv.Itoa(int(int16(v)))
+	case compiler.BOOLEAN:
+		switch v {
+		case 0:
+			return "false"
+		case 0xffff:
+			return "true"
+		}
+		return strconv.Itoa(int(int16(v)))
+	case compiler.CHAR:
+		if 32 <= v && v < 127 {
+			return fmt.Sprintf("%d '%c'", v, rune(v))
+		}
+		return strconv.Itoa(int(v))
+	}
+	if v == 0 {
+		return "null"
+	}
+	return fmt.Sprintf("%s@%d", typ, v)
+}
+
+// SourceLine returns the text of a line of a Jack file of the program.
+func (d *JackDebugger) SourceLine(file string, line int) (string, bool) {
+	name := base(file)
+	lines, ok := d.lines[name]
+	if !ok {
+		path, known := d.files[name]
+		if !known {
+			path = file
+		}
+		data, err := ioutil.ReadFile(path)
+		if err == nil {
+			lines = strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+		}
+		d.lines[name] = lines
+	}
+	if line < 1 || line > len(lines) {
+		return "", false
+	}
+	return lines[line-1], true
+}
@@ -0,0 +1,199 @@
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const jackHelp = `break FILE:LINE   (b)   stop on entering a line, e.g. Main.jack:12
+delete FILE:LINE  (d)   remove a breakpoint
+step              (s)   run to the next line, into calls
+next              (n)   run to the next line, over calls
+finish            (f)   run until the subroutine returns
+continue          (c)   run to a breakpoint or the end
+backtrace         (bt)  print the call stack
+locals [N]        (v)   print the variables of frame N, 0 is the current one
+list [N]          (l)   print N lines around the current one
+info                    list the breakpoints
+quit              (q)
+`
+
+// REPL reads commands from in, one per line, until quit or the end of the
+// input and writes the answers to out. An empty line repeats the last
+// command.
+func (d *JackDebugger) REPL(in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	last := ""
+	d.printLocation(out)
+	for {
+		fmt.Fprint(out, "(jdb) ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return scanner.Err()
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			line = last
+		}
+		last = line
+		quit, err := d.Exec(line, out)
+		if err != nil {
+			fmt.Fprintln(out, err)
+		}
+		if quit {
+			return nil
+		}
+	}
+}
+
+// Exec runs one command of the REPL.
+func (d *JackDebugger) Exec(line string, out io.Writer) (bool, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false, nil
+	}
+	args := fields[1:]
+	switch fields[0] {
+	case "break", "b", "delete", "d":
+		if len(args) == 0 {
+			return false, fmt.Errorf("missing FILE:LINE")
+		}
+		file, n, err := ParseBreakpoint(args[0])
+		if err != nil {
+			return false, err
+		}
+		if fields[0] == "delete" || fields[0] == "d" {
+			d.RemoveBreakpoint(file, n)
+			return false, nil
+		}
+		d.AddBreakpoint(file, n)
+		text, ok := d.SourceLine(file, n)
+		if !ok {
+			fmt.Fprintf(out, "warning: %s has no line %d\n", file, n)
+		}
+		fmt.Fprintf(out, "breakpoint at %s:%d %s\n", file, n, strings.TrimSpace(text))
+	case "step", "s":
+		return false, d.report(out, d.Step)
+	case "next", "n":
+		return false, d.report(out, d.Next)
+	case "finish", "f":
+		return false, d.report(out, d.Finish)
+	case "continue", "c":
+		return false, d.report(out, d.Continue)
+	case "backtrace", "bt":
+		for i, f := range d.Frames() {
+			fmt.Fprintf(out, "#%d %s\n", i, frameName(f))
+		}
+	case "locals", "v":
+		frames := d.Frames()
+		n, err := frameIndex(args, len(frames))
+		if err != nil {
+			return false, err
+		}
+		f := frames[n]
+		fmt.Fprintf(out, "#%d %s\n", n, frameName(f))
+		bindings := d.Variables(f)
+		if len(bindings) == 0 {
+			fmt.Fprintln(out, "  no variables known")
+		}
+		for _, b := range bindings {
+			fmt.Fprintf(out, "  %-8s %s %s = %s\n", segmentKind(b.Segment), b.Type, b.Name, FormatValue(b.Type, b.Value))
+		}
+	case "list", "l":
+		n, err := count(args, 0, 10)
+		if err != nil {
+			return false, err
+		}
+		loc, ok := d.Location()
+		if !ok {
+			return false, fmt.Errorf("no Jack source here")
+		}
+		first := loc.Line - n/2
+		if first < 1 {
+			first = 1
+		}
+		for i := first; i < first+n; i++ {
+			text, ok := d.SourceLine(loc.File, i)
+			if !ok {
+				break
+			}
+			mark := "  "
+			if d.breakpoints[breakpointKey(loc.File, i)] {
+				mark = "* "
+			}
+			if i == loc.Line {
+				mark = mark[:1] + ">"
+			}
+			fmt.Fprintf(out, "%s%4d %s\n", mark, i, text)
+		}
+	case "info":
+		for _, key := range d.Breakpoints() {
+			fmt.Fprintf(out, "breakpoint %s\n", key)
+		}
+	case "help", "h":
+		fmt.Fprint(out, jackHelp)
+	case "quit", "q":
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown command %s, try help", fields[0])
+	}
+	return false, nil
+}
+
+func frameIndex(args []string, frames int) (int, error) {
+	if len(args) == 0 {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 || n >= frames {
+		return 0, fmt.Errorf("no frame %s", args[0])
+	}
+	return n, nil
+}
+
+func frameName(f Frame) string {
+	if f.Location.Function == "" {
+		return "?"
+	}
+	return fmt.Sprintf("%s at %s:%d", f.Location.Function, base(f.Location.File), f.Location.Line)
+}
+
+// segmentKind names the variables of a segment as Jack declares them.
+func segmentKind(segment string) string {
+	switch segment {
+	case "argument":
+		return "argument"
+	case "local":
+		return "var"
+	case "this":
+		return "field"
+	}
+	return segment
+}
+
+func (d *JackDebugger) report(out io.Writer, run func() (Reason, error)) error {
+	reason, err := run()
+	switch reason {
+	case Breakpoint:
+		fmt.Fprint(out, "breakpoint: ")
+	case Halted:
+		fmt.Fprintln(out, "program halted")
+	case Limit:
+		fmt.Fprintf(out, "stopped after %d steps\n", d.MaxSteps)
+	}
+	d.printLocation(out)
+	return err
+}
+
+func (d *JackDebugger) printLocation(out io.Writer) {
+	loc, ok := d.Location()
+	if !ok {
+		fmt.Fprintln(out, "no Jack source here")
+		return
+	}
+	text, _ := d.SourceLine(loc.File, loc.Line)
+	fmt.Fprintf(out, "%s:%d (%s)\n%5d %s\n", base(loc.File), loc.Line, loc.Function, loc.Line, text)
+}
@@ -0,0 +1,256 @@
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/mingpepe/Nand2teris/cpu"
+)
+
+const help = `break LOC       (b)  stop before the instruction at a ROM address or label
+delete LOC      (d)  remove a breakpoint
+watch CELL      (w)  stop when a RAM cell changes, e.g. SP, R13 or a variable
+unwatch CELL         remove a watchpoint
+step [N]        (s)  execute N instructions
+next            (n)  step, over a VM call at its jump
+continue        (c)  run to a breakpoint, a watchpoint or the end
+back [N]        (r)  undo the last N instructions
+regs            (p)  print the registers
+ram CELL [N]    (x)  print N RAM cells
+list [LOC] [N]  (l)  disassemble N instructions
+where                print the source of the current instruction
+info                 list the breakpoints and watchpoints
+quit            (q)
+`
+
+// REPL reads commands from in, one per line, until quit or the end of the
+// input and writes the answers to out. An empty line repeats the last
+// command.
+func (d *Debugger) REPL(in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	last := ""
+	d.printPC(out)
+	for {
+		fmt.Fprint(out, "(hdb) ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return scanner.Err()
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			line = last
+		}
+		last = line
+		quit, err := d.Exec(line, out)
+		if err != nil {
+			fmt.Fprintln(out, err)
+		}
+		if quit {
+			return nil
+		}
+	}
+}
+
+// Exec runs one command of the REPL.
+func (d *Debugger) Exec(line string, out io.Writer) (bool, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false, nil
+	}
+	args := fields[1:]
+	switch fields[0] {
+	case "break", "b":
+		address, err := d.romArg(args, 0)
+		if err != nil {
+			return false, err
+		}
+		d.AddBreakpoint(address)
+		fmt.Fprintf(out, "breakpoint at %s\n", d.romName(address))
+	case "delete", "d":
+		address, err := d.romArg(args, 0)
+		if err != nil {
+			return false, err
+		}
+		d.RemoveBreakpoint(address)
+	case "watch", "w":
+		address, err := d.ramArg(args)
+		if err != nil {
+			return false, err
+		}
+		d.Watch(address)
+		fmt.Fprintf(out, "watchpoint at %s = %d\n", d.ramName(address), int16(d.CPU.RAM[address]))
+	case "unwatch":
+		address, err := d.ramArg(args)
+		if err != nil {
+			return false, err
+		}
+		d.Unwatch(address)
+	case "step", "s":
+		n, err := count(args, 0, 1)
+		if err != nil {
+			return false, err
+		}
+		var stop Stop
+		for i := 0; i < n; i++ {
+			stop, err = d.Step()
+			if err != nil || stop.Reason != Stepped {
+				break
+			}
+		}
+		d.report(out, stop)
+		return false, err
+	case "next", "n":
+		stop, err := d.Next()
+		d.report(out, stop)
+		return false, err
+	case "continue", "c":
+		stop, err := d.Continue()
+		d.report(out, stop)
+		return false, err
+	case "back", "r":
+		n, err := count(args, 0, 1)
+		if err != nil {
+			return false, err
+		}
+		if undone := d.Back(n); undone < n {
+			fmt.Fprintf(out, "history ends after %d instructions\n", undone)
+		}
+		d.printPC(out)
+	case "regs", "p":
+		c := d.CPU
+		fmt.Fprintf(out, "PC=%d A=%d D=%d cycles=%d\n", c.PC, int16(c.A), int16(c.D), c.Cycles)
+		fmt.Fprintf(out, "SP=%d LCL=%d ARG=%d THIS=%d THAT=%d\n", c.RAM[0], c.RAM[1], c.RAM[2], c.RAM[3], c.RAM[4])
+	case "ram", "x":
+		address, err := d.ramArg(args)
+		if err != nil {
+			return false, err
+		}
+		n, err := count(args, 1, 1)
+		if err != nil {
+			return false, err
+		}
+		for i := int(address); i < int(address)+n && i < cpu.RAMSize; i++ {
+			fmt.Fprintf(out, "RAM[%d] = %d", i, int16(d.CPU.RAM[i]))
+			if name := d.RAMName(uint16(i)); name != "" {
+				fmt.Fprintf(out, " (%s)", name)
+			}
+			fmt.Fprintln(out)
+		}
+	case "list", "l":
+		address := d.CPU.PC
+		if len(args) > 0 {
+			var err error
+			if address, err = d.romArg(args, 0); err != nil {
+				return false, err
+			}
+		}
+		n, err := count(args, 1, 10)
+		if err != nil {
+			return false, err
+		}
+		for i := int(address); i < int(address)+n && i < cpu.ROMSize; i++ {
+			for _, label := range d.Symbols.Labels[uint16(i)] {
+				fmt.Fprintf(out, "      (%s)\n", label)
+			}
+			mark := " "
+			if d.breakpoints[uint16(i)] {
+				mark = "*"
+			}
+			if uint16(i) == d.CPU.PC {
+				mark += ">"
+			} else {
+				mark += " "
+			}
+			fmt.Fprintf(out, "%s%5d %s\n", mark, i, d.Instruction(uint16(i)))
+		}
+	case "where":
+		locs := d.Source(d.CPU.PC)
+		if len(locs) == 0 {
+			fmt.Fprintln(out, "no source known")
+		}
+		for _, loc := range locs {
+			fmt.Fprintf(out, "  %s\n", loc)
+		}
+	case "info":
+		for _, address := range d.Breakpoints() {
+			fmt.Fprintf(out, "breakpoint %s\n", d.romName(address))
+		}
+		for _, address := range d.Watchpoints() {
+			fmt.Fprintf(out, "watchpoint %s = %d\n", d.ramName(address), int16(d.CPU.RAM[address]))
+		}
+	case "help", "h":
+		fmt.Fprint(out, help)
+	case "quit", "q":
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown command %s, try help", fields[0])
+	}
+	return false, nil
+}
+
+func (d *Debugger) romArg(args []string, i int) (uint16, error) {
+	if len(args) <= i {
+		return 0, fmt.Errorf("missing ROM address or label")
+	}
+	return d.ROMAddress(args[i])
+}
+
+func (d *Debugger) ramArg(args []string) (uint16, error) {
+	if len(args) == 0 {
+		return 0, fmt.Errorf("missing RAM address or variable")
+	}
+	return d.RAMAddress(args[0])
+}
+
+// count parses the optional count at args[i].
+func count(args []string, i int, def int) (int, error) {
+	if len(args) <= i {
+		return def, nil
+	}
+	n, err := strconv.Atoi(args[i])
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid count %s", args[i])
+	}
+	return n, nil
+}
+
+func (d *Debugger) romName(address uint16) string {
+	if labels := d.Symbols.Labels[address]; len(labels) > 0 {
+		return fmt.Sprintf("%d (%s)", address, labels[0])
+	}
+	return strconv.Itoa(int(address))
+}
+
+func (d *Debugger) ramName(address uint16) string {
+	if name := d.RAMName(address); name != "" {
+		return fmt.Sprintf("%d (%s)", address, name)
+	}
+	return strconv.Itoa(int(address))
+}
+
+func (d *Debugger) report(out io.Writer, stop Stop) {
+	switch stop.Reason {
+	case Breakpoint:
+		fmt.Fprintf(out, "breakpoint at %s\n", d.romName(stop.Address))
+	case Watchpoint:
+		fmt.Fprintf(out, "watchpoint %s: %d -> %d\n", d.ramName(stop.Address), int16(stop.Old), int16(stop.New))
+	case Halted:
+		fmt.Fprintln(out, "program halted")
+	case Limit:
+		fmt.Fprintf(out, "stopped after %d instructions\n", d.MaxCycles)
+	}
+	d.printPC(out)
+}
+
+// printPC prints the next instruction and its innermost source.
+func (d *Debugger) printPC(out io.Writer) {
+	pc := d.CPU.PC
+	fmt.Fprintf(out, "%s: %s", d.romName(pc), d.Instruction(pc))
+	if locs := d.Source(pc); len(locs) > 0 {
+		fmt.Fprintf(out, "    %s", locs[len(locs)-1])
+	}
+	fmt.Fprintln(out)
+}
@@ -0,0 +1,78 @@
+package diag
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+	Info
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	case Info:
+		return "info"
+	}
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
+// Diagnostic is a problem found in a source file. Line and Column start at 1,
+// 0 means unknown.
+type Diagnostic struct {
+	File     string
+	Line     int
+	Column   int
+	Severity Severity
+	Message  string
+}
+
+func Errorf(file string, line, column int, format string, args ...interface{}) *Diagnostic {
+	return &Diagnostic{File: file, Line: line, Column: column, Severity: Error, Message: fmt.Sprintf(format, args...)}
+}
+
+func Warningf(file string, line, column int, format string, args ...interface{}) *Diagnostic {
+	return &Diagnostic{File: file, Line: line, Column: column, Severity: Warning, Message: fmt.Sprintf(format, args...)}
+}
+
+// Error formats the diagnostic like "Main.jack:3:7: error: expected ';'".
+func (d *Diagnostic) Error() string {
+	pos := d.File
+	if d.Line > 0 {
+		pos += fmt.Sprintf(":%d", d.Line)
+		if d.Column > 0 {
+			pos += fmt.Sprintf(":%d", d.Column)
+		}
+	}
+	if pos != "" {
+		pos += ": "
+	}
+	return fmt.Sprintf("%s%s: %s", pos, d.Severity, d.Message)
+}
+
+// List is returned by tools reporting several problems at once.
+type List []*Diagnostic
+
+func (l List) Error() string {
+	lines := make([]string, len(l))
+	for i, d := range l {
+		lines[i] = d.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Err returns nil for an empty list, so it can be returned as an error.
+func (l List) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
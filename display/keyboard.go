@@ -0,0 +1,189 @@
+package display
+
+import (
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Hack key codes of the keys without a character
+const (
+	KeyNewLine   = 128
+	KeyBackSpace = 129
+	KeyLeft      = 130
+	KeyUp        = 131
+	KeyRight     = 132
+	KeyDown      = 133
+	KeyHome      = 134
+	KeyEnd       = 135
+	KeyPageUp    = 136
+	KeyPageDown  = 137
+	KeyInsert    = 138
+	KeyDelete    = 139
+	KeyEsc       = 140
+	// F1 to F12 follow
+	KeyF1 = 141
+)
+
+const ctrlC = 3
+
+// Final letters of the escape sequences of the cursor keys, ESC [ A or ESC O A
+var letterKeys = map[byte]uint16{
+	'A': KeyUp,
+	'B': KeyDown,
+	'C': KeyRight,
+	'D': KeyLeft,
+	'H': KeyHome,
+	'F': KeyEnd,
+	'P': KeyF1,
+	'Q': KeyF1 + 1,
+	'R': KeyF1 + 2,
+	'S': KeyF1 + 3,
+}
+
+// Numbers of the escape sequences ESC [ n ~
+var tildeKeys = map[int]uint16{
+	1:  KeyHome,
+	2:  KeyInsert,
+	3:  KeyDelete,
+	4:  KeyEnd,
+	5:  KeyPageUp,
+	6:  KeyPageDown,
+	7:  KeyHome,
+	8:  KeyEnd,
+	11: KeyF1,
+	12: KeyF1 + 1,
+	13: KeyF1 + 2,
+	14: KeyF1 + 3,
+	15: KeyF1 + 4,
+	17: KeyF1 + 5,
+	18: KeyF1 + 6,
+	19: KeyF1 + 7,
+	20: KeyF1 + 8,
+	21: KeyF1 + 9,
+	23: KeyF1 + 10,
+	24: KeyF1 + 11,
+}
+
+// DecodeKey decodes the first key of the input of a raw terminal into its
+// Hack key code and returns how many bytes it took. The code is 0 for keys
+// Hack does not have. An ESC alone is the Esc key, so b must hold whole
+// escape sequences, as one read of a terminal does.
+func DecodeKey(b []byte) (uint16, int) {
+	if len(b) == 0 {
+		return 0, 0
+	}
+	c := b[0]
+	switch {
+	case c == '\r' || c == '\n':
+		return KeyNewLine, 1
+	case c == 0x7f || c == 0x08:
+		return KeyBackSpace, 1
+	case 32 <= c && c < 127:
+		return uint16(c), 1
+	case c != 0x1b:
+		return 0, 1
+	}
+	if len(b) < 3 || (b[1] != '[' && b[1] != 'O') {
+		return KeyEsc, 1
+	}
+	if key, ok := letterKeys[b[2]]; ok {
+		return key, 3
+	}
+	// ESC [ n ~, possibly with modifiers as ESC [ n ; m ~
+	end := 2
+	for end < len(b) && (b[end] == ';' || '0' <= b[end] && b[end] <= '9') {
+		end++
+	}
+	if end == len(b) {
+		return 0, len(b)
+	}
+	if b[end] != '~' {
+		if key, ok := letterKeys[b[end]]; ok {
+			return key, end + 1
+		}
+		return 0, end + 1
+	}
+	number := string(b[2:end])
+	for i := 0; i < len(number); i++ {
+		if number[i] == ';' {
+			number = number[:i]
+			break
+		}
+	}
+	n, _ := strconv.Atoi(number)
+	return tildeKeys[n], end + 1
+}
+
+// Keyboard turns the input of a raw terminal into the value of the KBD
+// register. Terminals only report presses, so a key reads as held down
+// for Hold after it was typed, or until the next key.
+type Keyboard struct {
+	Hold time.Duration
+
+	mu          sync.Mutex
+	key         uint16
+	until       time.Time
+	interrupted bool
+}
+
+// NewKeyboard reads keys from r until it ends or Ctrl-C is typed.
+func NewKeyboard(r io.Reader) *Keyboard {
+	k := &Keyboard{Hold: 150 * time.Millisecond}
+	go k.read(r)
+	return k
+}
+
+func (k *Keyboard) read(r io.Reader) {
+	buf := make([]byte, 64)
+	for {
+		n, err := r.Read(buf)
+		for b := buf[:n]; len(b) > 0; {
+			if b[0] == ctrlC {
+				k.interrupt()
+				return
+			}
+			key, size := DecodeKey(b)
+			b = b[size:]
+			if key != 0 {
+				k.Press(key)
+			}
+		}
+		if err != nil {
+			k.interrupt()
+			return
+		}
+	}
+}
+
+func (k *Keyboard) interrupt() {
+	k.mu.Lock()
+	k.interrupted = true
+	k.mu.Unlock()
+}
+
+// Press holds a key down from now.
+func (k *Keyboard) Press(key uint16) {
+	k.mu.Lock()
+	k.key = key
+	k.until = time.Now().Add(k.Hold)
+	k.mu.Unlock()
+}
+
+// Key returns the code of the key held down, 0 when there is none.
+func (k *Keyboard) Key() uint16 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if time.Now().After(k.until) {
+		return 0
+	}
+	return k.key
+}
+
+// Interrupted tells whether Ctrl-C was typed or the input ended.
+func (k *Keyboard) Interrupted() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.interrupted
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package display
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+func MakeRaw(in, out *os.File) (func() error, error) {
+	return nil, fmt.Errorf("raw terminal input is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,64 @@
+package display
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	enableProcessedInput            = 0x0001
+	enableLineInput                 = 0x0002
+	enableEchoInput                 = 0x0004
+	enableVirtualTerminalInput      = 0x0200
+	enableVirtualTerminalProcessing = 0x0004
+)
+
+var (
+	kernel32           = syscall.NewLazyDLL("kernel32.dll")
+	procGetConsoleMode = kernel32.NewProc("GetConsoleMode")
+	procSetConsoleMode = kernel32.NewProc("SetConsoleMode")
+)
+
+func getConsoleMode(handle uintptr) (uint32, error) {
+	var mode uint32
+	r, _, err := procGetConsoleMode.Call(handle, uintptr(unsafe.Pointer(&mode)))
+	if r == 0 {
+		return 0, err
+	}
+	return mode, nil
+}
+
+func setConsoleMode(handle uintptr, mode uint32) error {
+	r, _, err := procSetConsoleMode.Call(handle, uintptr(mode))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// MakeRaw puts the console of in into raw mode, keys are read as they are
+// typed without echo and as the escape sequences of a terminal, and lets
+// out understand them too. It returns the function restoring both.
+func MakeRaw(in, out *os.File) (func() error, error) {
+	inMode, err := getConsoleMode(in.Fd())
+	if err != nil {
+		return nil, err
+	}
+	outMode, err := getConsoleMode(out.Fd())
+	if err != nil {
+		return nil, err
+	}
+	raw := inMode&^(enableProcessedInput|enableLineInput|enableEchoInput) | enableVirtualTerminalInput
+	if err := setConsoleMode(in.Fd(), raw); err != nil {
+		return nil, err
+	}
+	if err := setConsoleMode(out.Fd(), outMode|enableVirtualTerminalProcessing); err != nil {
+		setConsoleMode(in.Fd(), inMode)
+		return nil, err
+	}
+	return func() error {
+		setConsoleMode(out.Fd(), outMode)
+		return setConsoleMode(in.Fd(), inMode)
+	}, nil
+}
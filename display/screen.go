@@ -0,0 +1,83 @@
+package display
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+)
+
+const (
+	Width  = 512
+	Height = 256
+	// Words is the size of the screen memory map, 32 words per row
+	Words = Width * Height / 16
+)
+
+var palette = color.Palette{color.White, color.Black}
+
+// Pixel tells whether a pixel of the screen memory map is black. Bit 0 of a
+// word is its leftmost pixel.
+func Pixel(screen []uint16, x, y int) bool {
+	return screen[y*Width/16+x/16]&(1<<(x%16)) != 0
+}
+
+// Image draws the screen memory map, screen is the RAM from SCREEN to KBD.
+func Image(screen []uint16) *image.Paletted {
+	img := image.NewPaletted(image.Rect(0, 0, Width, Height), palette)
+	for y := 0; y < Height; y++ {
+		for x := 0; x < Width; x++ {
+			if Pixel(screen, x, y) {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+	return img
+}
+
+func WritePNG(w io.Writer, screen []uint16) error {
+	return png.Encode(w, Image(screen))
+}
+
+// SavePNG writes a snapshot of the screen to a .png file.
+func SavePNG(filename string, screen []uint16) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := WritePNG(f, screen); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func LoadPNG(filename string) (image.Image, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+// Diff counts the pixels of the screen that differ from a golden image, a
+// pixel of the image is black when it is darker than mid gray.
+func Diff(screen []uint16, golden image.Image) (int, error) {
+	bounds := golden.Bounds()
+	if bounds.Dx() != Width || bounds.Dy() != Height {
+		return 0, fmt.Errorf("image is %dx%d, the screen is %dx%d", bounds.Dx(), bounds.Dy(), Width, Height)
+	}
+	n := 0
+	for y := 0; y < Height; y++ {
+		for x := 0; x < Width; x++ {
+			gray := color.GrayModel.Convert(golden.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray)
+			if (gray.Y < 128) != Pixel(screen, x, y) {
+				n++
+			}
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,127 @@
+package display
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// point is a black pixel the Hack screen puts at RAM[SCREEN+32*y+x/16] bit
+// x%16, written out by hand rather than computed.
+type point struct{ x, y int }
+
+// screenWords sets words of the screen memory map, the offsets are from
+// SCREEN.
+func screenWords(words map[int]uint16) []uint16 {
+	screen := make([]uint16, Words)
+	for offset, word := range words {
+		screen[offset] = word
+	}
+	return screen
+}
+
+var handBuilt = []struct {
+	words map[int]uint16
+	black []point
+}{
+	{map[int]uint16{0: 0x0001}, []point{{0, 0}}},
+	{map[int]uint16{0: 0x8000}, []point{{15, 0}}},
+	{map[int]uint16{1: 0x0001}, []point{{16, 0}}},
+	{map[int]uint16{32: 0x0001}, []point{{0, 1}}},
+	{map[int]uint16{0: 0x0005}, []point{{0, 0}, {2, 0}}},
+	{map[int]uint16{33: 0x0300}, []point{{24, 1}, {25, 1}}},
+	{map[int]uint16{8191: 0x8000}, []point{{511, 255}}},
+	{map[int]uint16{4096 + 16: 0xffff}, []point{
+		{256, 128}, {257, 128}, {258, 128}, {259, 128}, {260, 128}, {261, 128}, {262, 128}, {263, 128},
+		{264, 128}, {265, 128}, {266, 128}, {267, 128}, {268, 128}, {269, 128}, {270, 128}, {271, 128},
+	}},
+}
+
+func isBlack(black []point, x, y int) bool {
+	for _, p := range black {
+		if p.x == x && p.y == y {
+			return true
+		}
+	}
+	return false
+}
+
+func TestImageOfScreenWords(t *testing.T) {
+	for _, tt := range handBuilt {
+		img := Image(screenWords(tt.words))
+		for y := 0; y < Height; y++ {
+			for x := 0; x < Width; x++ {
+				got := img.At(x, y) == color.Black
+				if want := isBlack(tt.black, x, y); got != want {
+					t.Errorf("%v: pixel (%d, %d) black = %v, want %v", tt.words, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestPNGOfScreenWords(t *testing.T) {
+	for _, tt := range handBuilt {
+		var buf bytes.Buffer
+		if err := WritePNG(&buf, screenWords(tt.words)); err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range tt.black {
+			if r, _, _, _ := img.At(p.x, p.y).RGBA(); r != 0 {
+				t.Errorf("%v: pixel (%d, %d) of the png is not black", tt.words, p.x, p.y)
+			}
+		}
+	}
+}
+
+// drawn is a golden image drawn by hand, white but for the black pixels.
+func drawn(black []point) image.Image {
+	img := image.NewGray(image.Rect(0, 0, Width, Height))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	for _, p := range black {
+		img.SetGray(p.x, p.y, color.Gray{0})
+	}
+	return img
+}
+
+func TestDiffHandDrawnGolden(t *testing.T) {
+	for _, tt := range handBuilt {
+		n, err := Diff(screenWords(tt.words), drawn(tt.black))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Errorf("%v: %d pixels differ from the drawn golden", tt.words, n)
+		}
+	}
+	// one pixel off to the right
+	n, err := Diff(screenWords(map[int]uint16{0: 0x0001}), drawn([]point{{1, 0}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("%d pixels differ, want 2", n)
+	}
+}
+
+func TestRender(t *testing.T) {
+	// (0, 0) and (1, 3) are the top left and bottom right dots of the
+	// first braille character, (0, 0) and (0, 1) the first half block
+	screen := screenWords(map[int]uint16{0: 0x0001, 96: 0x0002})
+	if got := []rune(Render(screen, Braille))[0]; got != '⢁' {
+		t.Errorf("braille %q, want %q", got, '⢁')
+	}
+	screen = screenWords(map[int]uint16{0: 0x0001, 32: 0x0001, 1: 0x0001})
+	text := []rune(Render(screen, HalfBlock))
+	if text[0] != '█' || text[1] != ' ' || text[16] != '▀' {
+		t.Errorf("half blocks %q, want \"█ \" and '▀' at 16", string(text[:17]))
+	}
+}
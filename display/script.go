@@ -0,0 +1,276 @@
+package display
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Trigger is when a line of a key script starts typing.
+type Trigger int
+
+const (
+	// AtCycle types at an absolute cycle
+	AtCycle Trigger = iota
+	// AfterCycles types some cycles after the previous line is typed
+	AfterCycles
+	// OnPoll types once the program reads the keyboard register again
+	OnPoll
+)
+
+// KeyEvent is a line of a key script, keys typed one after the other. A
+// key is held down until the program has read it twice, a read to see the
+// key and one to get it, and for Hold cycles at least, then released until
+// the program has read the release.
+type KeyEvent struct {
+	Trigger Trigger
+	Cycles  int
+	Hold    int
+	Keys    []uint16
+	Line    int
+}
+
+var keyNames = map[string]uint16{
+	"newline":   KeyNewLine,
+	"backspace": KeyBackSpace,
+	"left":      KeyLeft,
+	"up":        KeyUp,
+	"right":     KeyRight,
+	"down":      KeyDown,
+	"home":      KeyHome,
+	"end":       KeyEnd,
+	"pageup":    KeyPageUp,
+	"pagedown":  KeyPageDown,
+	"insert":    KeyInsert,
+	"delete":    KeyDelete,
+	"esc":       KeyEsc,
+}
+
+// Phases of the key being typed
+const (
+	waiting = iota
+	pressed
+	released
+)
+
+// KeyScript types keys into a headless run, in step with the cycles and
+// the keyboard polls of the program so that a run is repeatable. A line of
+// a script is a trigger, an optional hold and keys:
+//
+//	poll "ls" newline       // once the program polls the keyboard
+//	@2000000 hold 5000 left // at cycle 2000000, held for 5000 cycles
+//	+100000 "-32123\n" 51   // 100000 cycles after the previous line
+//
+// Keys are Go quoted strings, where \n is newline and \b backspace, key
+// names or key codes.
+type KeyScript struct {
+	Events []KeyEvent
+
+	event, key int
+	phase      int
+	// cycle and poll count when the phase started
+	since, polls int
+}
+
+func LoadKeyScript(filename string) (*KeyScript, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseKeyScript(f)
+}
+
+func ParseKeyScript(r io.Reader) (*KeyScript, error) {
+	s := &KeyScript{}
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		tokens, err := tokenize(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		if len(tokens) == 0 {
+			continue
+		}
+		event, err := parseEvent(tokens)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		event.Line = lineNo
+		s.Events = append(s.Events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// tokenize splits a line into words and quoted strings, which keep their
+// quotes, up to a // comment.
+func tokenize(line string) ([]string, error) {
+	tokens := make([]string, 0)
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case strings.HasPrefix(line[i:], "//"):
+			return tokens, nil
+		case c == '"':
+			j := i + 1
+			for j < len(line) && line[j] != '"' {
+				if line[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(line) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, line[i:j+1])
+			i = j + 1
+		default:
+			j := i
+			for j < len(line) && line[j] != ' ' && line[j] != '\t' && line[j] != '"' {
+				j++
+			}
+			tokens = append(tokens, line[i:j])
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+func parseEvent(tokens []string) (KeyEvent, error) {
+	var event KeyEvent
+	trigger := tokens[0]
+	switch {
+	case trigger == "poll":
+		event.Trigger = OnPoll
+	case strings.HasPrefix(trigger, "@") || strings.HasPrefix(trigger, "+"):
+		n, err := strconv.Atoi(trigger[1:])
+		if err != nil || n < 0 {
+			return event, fmt.Errorf("invalid cycle count %s", trigger)
+		}
+		event.Trigger, event.Cycles = AtCycle, n
+		if trigger[0] == '+' {
+			event.Trigger = AfterCycles
+		}
+	default:
+		return event, fmt.Errorf("expected poll, @CYCLE or +CYCLES, got %s", trigger)
+	}
+	tokens = tokens[1:]
+	if len(tokens) >= 2 && tokens[0] == "hold" {
+		n, err := strconv.Atoi(tokens[1])
+		if err != nil || n < 0 {
+			return event, fmt.Errorf("invalid hold %s", tokens[1])
+		}
+		event.Hold = n
+		tokens = tokens[2:]
+	}
+	for _, token := range tokens {
+		keys, err := parseKeys(token)
+		if err != nil {
+			return event, err
+		}
+		event.Keys = append(event.Keys, keys...)
+	}
+	if len(event.Keys) == 0 {
+		return event, fmt.Errorf("no keys")
+	}
+	return event, nil
+}
+
+func parseKeys(token string) ([]uint16, error) {
+	if strings.HasPrefix(token, "\"") {
+		text, err := strconv.Unquote(token)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %s", token)
+		}
+		keys := make([]uint16, 0, len(text))
+		for _, r := range text {
+			switch {
+			case r == '\n':
+				keys = append(keys, KeyNewLine)
+			case r == '\b':
+				keys = append(keys, KeyBackSpace)
+			case 32 <= r && r < 127:
+				keys = append(keys, uint16(r))
+			default:
+				return nil, fmt.Errorf("no key types %q", r)
+			}
+		}
+		return keys, nil
+	}
+	if key, ok := keyNames[strings.ToLower(token)]; ok {
+		return []uint16{key}, nil
+	}
+	lower := strings.ToLower(token)
+	if strings.HasPrefix(lower, "f") {
+		if n, err := strconv.Atoi(lower[1:]); err == nil && 1 <= n && n <= 12 {
+			return []uint16{KeyF1 + uint16(n-1)}, nil
+		}
+	}
+	n, err := strconv.Atoi(token)
+	if err != nil || n <= 0 || n > 32767 {
+		return nil, fmt.Errorf("unknown key %s", token)
+	}
+	return []uint16{uint16(n)}, nil
+}
+
+// Key advances the script to the cycle count and the number of keyboard
+// polls of the program and returns the value of the keyboard register for
+// the next cycle.
+func (s *KeyScript) Key(cycles int, polls int) uint16 {
+	for s.event < len(s.Events) {
+		event := &s.Events[s.event]
+		switch s.phase {
+		case waiting:
+			var ready bool
+			switch event.Trigger {
+			case AtCycle:
+				ready = cycles >= event.Cycles
+			case AfterCycles:
+				ready = cycles >= s.since+event.Cycles
+			case OnPoll:
+				ready = polls > s.polls
+			}
+			if !ready {
+				return 0
+			}
+			s.start(pressed, cycles, polls)
+		case pressed:
+			if polls < s.polls+2 || cycles < s.since+event.Hold {
+				return event.Keys[s.key]
+			}
+			s.start(released, cycles, polls)
+		case released:
+			if polls == s.polls {
+				return 0
+			}
+			s.key++
+			if s.key == len(event.Keys) {
+				s.event++
+				s.key = 0
+				s.start(waiting, cycles, polls)
+			} else {
+				s.start(pressed, cycles, polls)
+			}
+		}
+	}
+	return 0
+}
+
+func (s *KeyScript) start(phase int, cycles int, polls int) {
+	s.phase, s.since, s.polls = phase, cycles, polls
+}
+
+// Done tells whether every key of the script was typed and released.
+func (s *KeyScript) Done() bool {
+	return s.event == len(s.Events)
+}
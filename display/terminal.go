@@ -0,0 +1,110 @@
+package display
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Mode is how characters of a terminal draw the pixels.
+type Mode int
+
+const (
+	// Braille draws 2x4 pixels per character, 256 columns by 64 rows
+	Braille Mode = iota
+	// HalfBlock draws 1x2 pixels per character, 512 columns by 128 rows
+	HalfBlock
+)
+
+func ParseMode(name string) (Mode, error) {
+	switch name {
+	case "braille":
+		return Braille, nil
+	case "half":
+		return HalfBlock, nil
+	}
+	return 0, fmt.Errorf("unknown mode %s, expected braille or half", name)
+}
+
+// Bits of the dots of a braille character, by row and column
+var brailleDots = [4][2]rune{
+	{0x01, 0x08},
+	{0x02, 0x10},
+	{0x04, 0x20},
+	{0x40, 0x80},
+}
+
+// Render draws the screen memory map as text, one line per row of
+// characters.
+func Render(screen []uint16, mode Mode) string {
+	var sb strings.Builder
+	if mode == HalfBlock {
+		for y := 0; y < Height; y += 2 {
+			for x := 0; x < Width; x++ {
+				top, bottom := Pixel(screen, x, y), Pixel(screen, x, y+1)
+				switch {
+				case top && bottom:
+					sb.WriteRune('█')
+				case top:
+					sb.WriteRune('▀')
+				case bottom:
+					sb.WriteRune('▄')
+				default:
+					sb.WriteByte(' ')
+				}
+			}
+			sb.WriteByte('\n')
+		}
+		return sb.String()
+	}
+	for y := 0; y < Height; y += 4 {
+		for x := 0; x < Width; x += 2 {
+			r := rune(0x2800)
+			for dy := 0; dy < 4; dy++ {
+				for dx := 0; dx < 2; dx++ {
+					if Pixel(screen, x+dx, y+dy) {
+						r |= brailleDots[dy][dx]
+					}
+				}
+			}
+			sb.WriteRune(r)
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// Terminal redraws the screen in place on an ANSI terminal.
+type Terminal struct {
+	w    io.Writer
+	mode Mode
+	last string
+}
+
+func NewTerminal(w io.Writer, mode Mode) *Terminal {
+	return &Terminal{w: w, mode: mode}
+}
+
+// Start clears the terminal and hides the cursor.
+func (t *Terminal) Start() error {
+	_, err := io.WriteString(t.w, "\x1b[2J\x1b[?25l")
+	return err
+}
+
+// Draw writes the screen when it changed since the last draw, status is
+// shown below it.
+func (t *Terminal) Draw(screen []uint16, status string) error {
+	frame := Render(screen, t.mode) + "\x1b[K" + status
+	if frame == t.last {
+		return nil
+	}
+	t.last = frame
+	_, err := io.WriteString(t.w, "\x1b[H"+frame)
+	return err
+}
+
+// Stop shows the cursor again below the screen.
+func (t *Terminal) Stop() error {
+	_, err := io.WriteString(t.w, "\x1b[?25h\n")
+	return err
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mingpepe/Nand2teris/assembler"
+	"github.com/mingpepe/Nand2teris/srcmap"
+)
+
+func exist(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+func main() {
+	var sym = flag.String("sym", "input.sym", "symbol file the assembler wrote with -sym")
+	var directory = flag.String("d", ".", "directory contains the .map files of the vm translator and the compiler")
+	var address = flag.Int("addr", 0, "ROM address to resolve")
+	flag.Parse()
+
+	if !exist(*sym) {
+		log.Printf("file not found: %s", *sym)
+		return
+	}
+	f, err := os.Open(*sym)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	symbols, err := assembler.ReadSymbolMap(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	maps := srcmap.NewMaps()
+	maps.Add(symbols.SourceMap())
+	err = filepath.Walk(*directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(path, ".map") {
+			m, err := srcmap.Load(path)
+			if err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+			maps.Add(m)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("ROM[%d]\n", *address)
+	for _, loc := range maps.Resolve(srcmap.ROM, *address) {
+		fmt.Printf("  %s\n", loc)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/mingpepe/Nand2teris/assembler"
 )
@@ -16,6 +17,7 @@ func exist(name string) bool {
 
 func main() {
 	var filename = flag.String("f", "input.asm", "input filename")
+	var sym = flag.Bool("sym", false, "write the labels, variables and source lines of the program to a .sym file")
 	flag.Parse()
 
 	if !exist(*filename) {
@@ -30,6 +32,7 @@ func main() {
 	defer f.Close()
 
 	assemb := assembler.New()
+	assemb.SetFile(*filename)
 	binary, err := assemb.Compile(f)
 	if err != nil {
 		log.Fatal(err)
@@ -38,4 +41,16 @@ func main() {
 	for i := 0; i < len(binary); i += 2 {
 		fmt.Printf("%08b%08b\n", binary[i], binary[i+1])
 	}
+
+	if *sym {
+		out_filename := strings.TrimSuffix(*filename, ".asm") + ".sym"
+		out_f, err := os.Create(out_filename)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer out_f.Close()
+		if err := assemb.Symbols().Write(out_f); err != nil {
+			log.Fatal(err)
+		}
+	}
 }
@@ -58,6 +58,7 @@ func main() {
 		defer f.Close()
 
 		tokenizer := compiler.NewTokenizer(f)
+		tokenizer.SetFile(_filename)
 		tokenizer.Parse()
 
 		length := len(_filename)
@@ -71,6 +72,8 @@ func main() {
 
 		tokenizer.Advance()
 		compilation_engine := compiler.NewCompilationEngineXml(tokenizer, out_f)
-		compilation_engine.CompileClass()
+		if err := compilation_engine.CompileClass(); err != nil {
+			log.Fatal(err)
+		}
 	}
 }
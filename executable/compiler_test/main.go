@@ -1,73 +1,139 @@
-// Project11
-package main
-
-import (
-	"flag"
-	"log"
-	"os"
-	"path/filepath"
-	"strings"
-
-	"github.com/mingpepe/Nand2teris/compiler"
-)
-
-func exist(name string) bool {
-	_, err := os.Stat(name)
-	return err == nil
-}
-
-func main() {
-	var filename = flag.String("f", "input.jack", "input filename")
-	var directory = flag.String("d", "", "directory contains jack files")
-	flag.Parse()
-
-	filenames := make([]string, 0)
-	if *directory == "" {
-		if !exist(*filename) {
-			log.Printf("file not found: %s", *filename)
-			return
-		}
-
-		if !strings.HasSuffix(*filename, ".jack") {
-			log.Println("input must be a jack file")
-			return
-		}
-		filenames = append(filenames, *filename)
-	} else {
-		err := filepath.Walk(*directory, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			if !info.IsDir() {
-				if strings.HasSuffix(path, ".jack") {
-					filenames = append(filenames, path)
-				}
-			}
-			return nil
-		})
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
-
-	for _, _filename := range filenames {
-		println(_filename)
-		f, err := os.Open(_filename)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer f.Close()
-
-		length := len(_filename)
-		out_filename := (_filename)[:length-5] + ".vm"
-
-		out_f, err := os.Create(out_filename)
-		if err != nil {
-			log.Print(err.Error())
-		}
-		defer out_f.Close()
-
-		compilation_engine := compiler.NewCompilationEngineVM(f, out_f)
-		compilation_engine.CompileClass()
-	}
-}
+// Project11
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mingpepe/Nand2teris/compiler"
+	"github.com/mingpepe/Nand2teris/diag"
+	"github.com/mingpepe/Nand2teris/srcmap"
+	"github.com/mingpepe/Nand2teris/vm"
+)
+
+func exist(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+func main() {
+	var filename = flag.String("f", "input.jack", "input filename")
+	var directory = flag.String("d", "", "directory contains jack files")
+	var osDir = flag.String("os", "", "directory contains the jack files of the OS API, e.g. projects/12")
+	var optimize = flag.Bool("O", false, "optimize the generated vm code")
+	var sourceMap = flag.Bool("map", false, "write the jack line of every vm line to a .vm.map file")
+	var strict = flag.Bool("strict", false, "compile nothing when the semantic check finds errors")
+	flag.Parse()
+
+	filenames := make([]string, 0)
+	if *directory == "" {
+		if !exist(*filename) {
+			log.Printf("file not found: %s", *filename)
+			return
+		}
+
+		if !strings.HasSuffix(*filename, ".jack") {
+			log.Println("input must be a jack file")
+			return
+		}
+		filenames = append(filenames, *filename)
+	} else {
+		err := filepath.Walk(*directory, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				if strings.HasSuffix(path, ".jack") {
+					filenames = append(filenames, path)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// every directory is a program of its own
+	dirs := make([]string, 0)
+	programs := make(map[string][]string)
+	for _, _filename := range filenames {
+		dir := filepath.Dir(_filename)
+		if _, exist := programs[dir]; !exist {
+			dirs = append(dirs, dir)
+		}
+		programs[dir] = append(programs[dir], _filename)
+	}
+	for _, dir := range dirs {
+		compile(programs[dir], *osDir, *optimize, *sourceMap, *strict)
+	}
+}
+
+func compile(filenames []string, osDir string, optimize bool, sourceMap bool, strict bool) {
+	project, err := compiler.LoadProject(filenames, osDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	failed := false
+	for _, d := range project.Check() {
+		log.Print(d)
+		if d.Severity == diag.Error {
+			failed = true
+		}
+	}
+	if failed && strict {
+		log.Fatal("semantic errors, nothing compiled")
+	}
+
+	for _, class := range project.Classes {
+		_filename := class.File
+		println(_filename)
+
+		length := len(_filename)
+		out_filename := (_filename)[:length-5] + ".vm"
+
+		var m *srcmap.Map
+		if sourceMap {
+			m = srcmap.New(out_filename)
+		}
+		// the .vm file is only written once the class compiles
+		var buf bytes.Buffer
+		if err := project.CompileWithSourceMap(class, &buf, m); err != nil {
+			log.Fatal(err)
+		}
+		code := buf.Bytes()
+		if optimize {
+			var optimized bytes.Buffer
+			reports, err := vm.OptimizeWithSourceMap(&buf, &optimized, m)
+			if err != nil {
+				log.Fatal(err)
+			}
+			for _, r := range reports {
+				log.Printf("%s: %d -> %d, %d saved", r.Name, r.Before, r.After, r.Before-r.After)
+			}
+			code = optimized.Bytes()
+		}
+		if err := ioutil.WriteFile(out_filename, code, 0644); err != nil {
+			log.Fatal(err)
+		}
+		if m != nil {
+			writeSourceMap(m)
+		}
+	}
+}
+
+func writeSourceMap(m *srcmap.Map) {
+	f, err := os.Create(m.File + ".map")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	if err := m.Write(f); err != nil {
+		log.Fatal(err)
+	}
+}
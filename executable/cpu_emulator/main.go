@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/mingpepe/Nand2teris/tst"
+)
+
+func exist(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+func main() {
+	var filename = flag.String("f", "input.tst", "test script filename")
+	var verbose = flag.Bool("v", false, "print the produced output")
+	flag.Parse()
+
+	if !exist(*filename) {
+		log.Printf("file not found: %s", *filename)
+		return
+	}
+
+	runner, err := tst.RunFile(tst.NewCPUSimulator(), *filename)
+	if runner != nil && *verbose {
+		for _, line := range runner.Output() {
+			fmt.Println(line)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("End of script - Comparison ended successfully")
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/mingpepe/Nand2teris/assembler"
+)
+
+func exist(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+func main() {
+	var filename = flag.String("f", "input.hack", "input filename, .hack text or raw big-endian words")
+	var source = flag.String("sym", "", ".sym file or assembly source of the program to restore its labels and variables")
+	flag.Parse()
+
+	if !exist(*filename) {
+		log.Printf("file not found: %s", *filename)
+		return
+	}
+
+	data, err := ioutil.ReadFile(*filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	program := data
+	if strings.HasSuffix(*filename, ".hack") {
+		program, err = assembler.ReadHack(bytes.NewReader(data))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var symbols *assembler.SymbolMap
+	if *source != "" {
+		f, err := os.Open(*source)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		if strings.HasSuffix(*source, ".sym") {
+			symbols, err = assembler.ReadSymbolMap(f)
+			if err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			assemb := assembler.New()
+			assemb.SetFile(*source)
+			if _, err := assemb.Compile(f); err != nil {
+				log.Fatal(err)
+			}
+			symbols = assemb.Symbols()
+		}
+	}
+
+	asm, err := assembler.Disassemble(program, symbols)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Print(asm)
+}
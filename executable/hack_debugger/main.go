@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mingpepe/Nand2teris/assembler"
+	"github.com/mingpepe/Nand2teris/cpu"
+	"github.com/mingpepe/Nand2teris/debugger"
+	"github.com/mingpepe/Nand2teris/srcmap"
+)
+
+func exist(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+func main() {
+	var filename = flag.String("f", "input.asm", "program to debug, .asm source or .hack machine code")
+	var sym = flag.String("sym", "", "symbol file of a .hack program, the .sym next to it by default")
+	var directory = flag.String("d", "", "directory contains the .map files of the vm translator and the compiler")
+	var cycles = flag.Int("n", 10000000, "max number of instructions of a continue")
+	var history = flag.Int("history", 100000, "number of instructions back can undo")
+	flag.Parse()
+
+	if !exist(*filename) {
+		log.Printf("file not found: %s", *filename)
+		return
+	}
+
+	c := cpu.New()
+	var symbols *assembler.SymbolMap
+	f, err := os.Open(*filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if strings.HasSuffix(*filename, ".hack") {
+		err = c.LoadHack(f)
+		f.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *sym == "" && exist(strings.TrimSuffix(*filename, ".hack")+".sym") {
+			*sym = strings.TrimSuffix(*filename, ".hack") + ".sym"
+		}
+		if *sym != "" {
+			symbols = readSymbols(*sym)
+		}
+	} else {
+		assemb := assembler.New()
+		assemb.SetFile(*filename)
+		program, err := assemb.Compile(f)
+		f.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := c.Load(program); err != nil {
+			log.Fatal(err)
+		}
+		symbols = assemb.Symbols()
+	}
+
+	d := debugger.New(c, symbols)
+	d.MaxCycles = *cycles
+	d.SetHistory(*history)
+	if *directory != "" {
+		loadMaps(d.Maps, *directory)
+	}
+
+	fmt.Println("Type help for the commands")
+	if err := d.REPL(os.Stdin, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func readSymbols(filename string) *assembler.SymbolMap {
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	symbols, err := assembler.ReadSymbolMap(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return symbols
+}
+
+func loadMaps(maps *srcmap.Maps, directory string) {
+	err := filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(path, ".map") {
+			m, err := srcmap.Load(path)
+			if err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+			maps.Add(m)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
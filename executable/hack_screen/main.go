@@ -0,0 +1,223 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mingpepe/Nand2teris/assembler"
+	"github.com/mingpepe/Nand2teris/cpu"
+	"github.com/mingpepe/Nand2teris/display"
+	"github.com/mingpepe/Nand2teris/vm"
+)
+
+func exist(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// machine is the CPU emulator or the VM emulator, both map the screen and
+// the keyboard at the same RAM addresses.
+type machine interface {
+	Run(maxCycles int) (int, error)
+	Step() error
+	Halted() bool
+	RAM() []uint16
+	CycleCount() int
+	PollCount() int
+}
+
+type cpuMachine struct{ *cpu.CPU }
+
+func (c cpuMachine) RAM() []uint16   { return c.CPU.RAM[:] }
+func (c cpuMachine) CycleCount() int { return c.Cycles }
+func (c cpuMachine) PollCount() int  { return c.KeyboardPolls }
+
+type vmMachine struct{ *vm.Emulator }
+
+func (e vmMachine) Halted() bool    { return e.Emulator.Halted }
+func (e vmMachine) RAM() []uint16   { return e.Emulator.RAM[:] }
+func (e vmMachine) CycleCount() int { return e.Cycles }
+func (e vmMachine) PollCount() int  { return e.KeyboardPolls }
+
+func main() {
+	var filename = flag.String("f", "", "program to run, .asm source or .hack machine code on the cpu emulator, a .vm file on the vm emulator")
+	var directory = flag.String("d", "", "directory contains vm files to run on the vm emulator")
+	var osDirectory = flag.String("os", "", "directory of OS .vm files to load for classes not in the program")
+	var prefer = flag.String("prefer", "", "comma separated OS classes to run from .vm code instead of built-ins")
+	var noBuiltin = flag.Bool("nobuiltin", false, "run only .vm code")
+	var modeName = flag.String("mode", "braille", "characters drawing the screen, braille or half")
+	var rate = flag.Int("rate", 0, "instructions or vm commands per second, 0 for the default of the emulator")
+	var fps = flag.Int("fps", 30, "frames per second")
+	var headless = flag.Bool("headless", false, "run without a terminal, for snapshots")
+	var cycles = flag.Int("n", 10000000, "max number of cycles to run headless")
+	var keys = flag.String("keys", "", "key script typed into a headless run, which ends once the script is over and the program polls the keyboard again")
+	var snapshot = flag.String("png", "", "write the final screen to a .png file")
+	var golden = flag.String("golden", "", "compare the final screen to a .png file")
+	flag.Parse()
+
+	var m machine
+	speed := *rate
+	if *directory != "" || strings.HasSuffix(*filename, ".vm") {
+		e := vm.NewEmulator()
+		if *noBuiltin {
+			e.DisableBuiltins()
+		}
+		if *prefer != "" {
+			e.PreferVM(strings.Split(*prefer, ",")...)
+		}
+		if *directory != "" {
+			if err := e.LoadDir(*directory); err != nil {
+				log.Fatal(err)
+			}
+		} else if err := e.LoadFile(*filename); err != nil {
+			log.Fatal(err)
+		}
+		if *osDirectory != "" {
+			if err := e.LoadMissing(*osDirectory); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := e.Start(); err != nil {
+			log.Fatal(err)
+		}
+		m = vmMachine{e}
+		if speed == 0 {
+			speed = vm.CyclesPerMillisecond * 1000
+		}
+	} else {
+		if !exist(*filename) {
+			log.Printf("file not found: %s", *filename)
+			return
+		}
+		m = cpuMachine{load(*filename)}
+		if speed == 0 {
+			speed = 10000000
+		}
+	}
+
+	screen := m.RAM()[cpu.SCREEN:cpu.KBD]
+	if *headless {
+		var script *display.KeyScript
+		if *keys != "" {
+			var err error
+			if script, err = display.LoadKeyScript(*keys); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := run(m, script, *cycles); err != nil {
+			log.Print(err)
+		}
+		fmt.Printf("Executed %d cycles, halted: %v\n", m.CycleCount(), m.Halted())
+	} else {
+		mode, err := display.ParseMode(*modeName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		interactive(m, mode, speed, *fps)
+	}
+
+	if *snapshot != "" {
+		if err := display.SavePNG(*snapshot, screen); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *golden != "" {
+		img, err := display.LoadPNG(*golden)
+		if err != nil {
+			log.Fatal(err)
+		}
+		n, err := display.Diff(screen, img)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if n > 0 {
+			log.Fatalf("%d pixels differ from %s", n, *golden)
+		}
+		fmt.Printf("Screen matches %s\n", *golden)
+	}
+}
+
+// run runs the program headless, typing the keys of the script when there
+// is one.
+func run(m machine, script *display.KeyScript, cycles int) error {
+	if script == nil {
+		_, err := m.Run(cycles)
+		return err
+	}
+	ram := m.RAM()
+	donePolls := -1
+	for i := 0; i < cycles && !m.Halted(); i++ {
+		ram[cpu.KBD] = script.Key(m.CycleCount(), m.PollCount())
+		if script.Done() {
+			if donePolls < 0 {
+				donePolls = m.PollCount()
+			} else if m.PollCount() > donePolls {
+				// waiting for input that never comes
+				return nil
+			}
+		}
+		if err := m.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// interactive runs the program in the terminal until Ctrl-C, the keys typed
+// are fed to the keyboard register.
+func interactive(m machine, mode display.Mode, speed int, fps int) {
+	restore, err := display.MakeRaw(os.Stdin, os.Stdout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer restore()
+	keyboard := display.NewKeyboard(os.Stdin)
+	term := display.NewTerminal(os.Stdout, mode)
+	term.Start()
+	defer term.Stop()
+
+	ram := m.RAM()
+	frame := time.Second / time.Duration(fps)
+	status := "Ctrl-C to quit"
+	failed := false
+	for !keyboard.Interrupted() {
+		start := time.Now()
+		if !failed && !m.Halted() {
+			ram[cpu.KBD] = keyboard.Key()
+			if _, err := m.Run(speed / fps); err != nil {
+				failed = true
+				status = err.Error() + ", Ctrl-C to quit"
+			} else if m.Halted() {
+				status = "halted, Ctrl-C to quit"
+			}
+		}
+		term.Draw(ram[cpu.SCREEN:cpu.KBD], status)
+		time.Sleep(frame - time.Since(start))
+	}
+}
+
+func load(filename string) *cpu.CPU {
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	c := cpu.New()
+	if strings.HasSuffix(filename, ".hack") {
+		err = c.LoadHack(f)
+	} else {
+		var program []byte
+		program, err = assembler.New().Compile(f)
+		if err == nil {
+			err = c.Load(program)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	return c
+}
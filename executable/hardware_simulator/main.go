@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/mingpepe/Nand2teris/tst"
+)
+
+func exist(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+func main() {
+	var filename = flag.String("f", "input.tst", "test script filename")
+	var verbose = flag.Bool("v", false, "print the produced output")
+	var all = flag.Bool("all", false, "compare every line instead of stopping at the first mismatch")
+	flag.Parse()
+
+	if !exist(*filename) {
+		log.Printf("file not found: %s", *filename)
+		return
+	}
+
+	f, err := os.Open(*filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cmds, err := tst.Parse(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("%s: %v", *filename, err)
+	}
+
+	runner := tst.NewRunner(tst.NewHDLSimulator(), filepath.Dir(*filename))
+	runner.KeepGoing = *all
+	err = runner.Run(cmds)
+	if *verbose {
+		for _, line := range runner.Output() {
+			fmt.Println(line)
+		}
+	}
+	if cmpErr, ok := err.(*tst.CompareError); ok {
+		mismatches := runner.Mismatches
+		if len(mismatches) == 0 {
+			mismatches = []*tst.CompareError{cmpErr}
+		}
+		for _, m := range mismatches {
+			fmt.Println(m.Diff())
+		}
+		log.Fatalf("%s: comparison failure", *filename)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("End of script - Comparison ended successfully")
+}
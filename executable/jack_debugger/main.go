@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mingpepe/Nand2teris/assembler"
+	"github.com/mingpepe/Nand2teris/compiler"
+	"github.com/mingpepe/Nand2teris/cpu"
+	"github.com/mingpepe/Nand2teris/debugger"
+	"github.com/mingpepe/Nand2teris/jack/ast"
+	"github.com/mingpepe/Nand2teris/srcmap"
+	"github.com/mingpepe/Nand2teris/vm"
+)
+
+func exist(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+func main() {
+	var directory = flag.String("d", "", "directory contains the .jack files, the .vm files and the .map files of the compiler and the vm translator")
+	var engine = flag.String("engine", "vm", "execution engine, vm or cpu")
+	var filename = flag.String("f", "", "program of the cpu engine, .asm source or .hack machine code")
+	var sym = flag.String("sym", "", "symbol file of a .hack program, the .sym next to it by default")
+	var osDirectory = flag.String("os", "", "directory of OS .vm files to load for classes not in the program, vm engine only")
+	var steps = flag.Int("n", 100000000, "max number of steps of a continue")
+	flag.Parse()
+
+	if *directory == "" || !exist(*directory) {
+		log.Printf("directory not found: %s", *directory)
+		return
+	}
+
+	classes := make([]*ast.Class, 0)
+	maps := srcmap.NewMaps()
+	err := filepath.Walk(*directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if strings.HasSuffix(path, ".jack") {
+			class, err := parse(path)
+			if err != nil {
+				return err
+			}
+			classes = append(classes, class)
+		} else if strings.HasSuffix(path, ".map") {
+			m, err := srcmap.Load(path)
+			if err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+			maps.Add(m)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var e debugger.Engine
+	switch *engine {
+	case "vm":
+		emulator := vm.NewEmulator()
+		if err := emulator.LoadDir(*directory); err != nil {
+			log.Fatal(err)
+		}
+		if *osDirectory != "" {
+			if err := emulator.LoadMissing(*osDirectory); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := emulator.Start(); err != nil {
+			log.Fatal(err)
+		}
+		e = debugger.NewVMEngine(emulator, maps)
+	case "cpu":
+		if !exist(*filename) {
+			log.Printf("file not found: %s", *filename)
+			return
+		}
+		c := cpu.New()
+		maps.Add(load(c, *filename, *sym).SourceMap())
+		e = debugger.NewCPUEngine(c, maps)
+	default:
+		log.Printf("unknown engine: %s", *engine)
+		return
+	}
+
+	d := debugger.NewJack(e, classes)
+	d.MaxSteps = *steps
+	fmt.Println("Type help for the commands")
+	if err := d.REPL(os.Stdin, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func parse(filename string) (*ast.Class, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return compiler.Parse(f, filename)
+}
+
+// load loads a program into c and returns its symbols, the lines of the
+// assembly they map ROM addresses to are needed.
+func load(c *cpu.CPU, filename string, sym string) *assembler.SymbolMap {
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	if !strings.HasSuffix(filename, ".hack") {
+		assemb := assembler.New()
+		assemb.SetFile(filename)
+		program, err := assemb.Compile(f)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := c.Load(program); err != nil {
+			log.Fatal(err)
+		}
+		return assemb.Symbols()
+	}
+	if err := c.LoadHack(f); err != nil {
+		log.Fatal(err)
+	}
+	if sym == "" {
+		sym = strings.TrimSuffix(filename, ".hack") + ".sym"
+	}
+	s, err := os.Open(sym)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer s.Close()
+	symbols, err := assembler.ReadSymbolMap(s)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return symbols
+}
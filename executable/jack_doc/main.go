@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mingpepe/Nand2teris/compiler"
+	"github.com/mingpepe/Nand2teris/jack/ast"
+	"github.com/mingpepe/Nand2teris/jack/doc"
+)
+
+func exist(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// Prints the API reference of the jack files to stdout, in Markdown or HTML.
+func main() {
+	var filename = flag.String("f", "input.jack", "input filename")
+	var directory = flag.String("d", "", "directory contains jack files, sub directories are not documented")
+	var asHTML = flag.Bool("html", false, "write HTML instead of Markdown")
+	flag.Parse()
+
+	filenames := make([]string, 0)
+	if *directory == "" {
+		if !exist(*filename) {
+			log.Printf("file not found: %s", *filename)
+			return
+		}
+
+		if !strings.HasSuffix(*filename, ".jack") {
+			log.Println("input must be a jack file")
+			return
+		}
+		filenames = append(filenames, *filename)
+	} else {
+		matches, err := filepath.Glob(filepath.Join(*directory, "*.jack"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		filenames = append(filenames, matches...)
+	}
+
+	classes := make([]*ast.Class, 0)
+	for _, _filename := range filenames {
+		f, err := os.Open(_filename)
+		if err != nil {
+			log.Fatal(err)
+		}
+		class, err := compiler.Parse(f, _filename)
+		f.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		classes = append(classes, class)
+	}
+
+	var err error
+	if *asHTML {
+		err = doc.HTML(os.Stdout, classes)
+	} else {
+		err = doc.Markdown(os.Stdout, classes)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mingpepe/Nand2teris/compiler"
+	"github.com/mingpepe/Nand2teris/jack/ast"
+)
+
+func exist(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// Prints the jack files in canonical form to stdout, comments other than doc
+// comments are dropped.
+func main() {
+	var filename = flag.String("f", "input.jack", "input filename")
+	var directory = flag.String("d", "", "directory contains jack files")
+	flag.Parse()
+
+	filenames := make([]string, 0)
+	if *directory == "" {
+		if !exist(*filename) {
+			log.Printf("file not found: %s", *filename)
+			return
+		}
+
+		if !strings.HasSuffix(*filename, ".jack") {
+			log.Println("input must be a jack file")
+			return
+		}
+		filenames = append(filenames, *filename)
+	} else {
+		err := filepath.Walk(*directory, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				if strings.HasSuffix(path, ".jack") {
+					filenames = append(filenames, path)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	for _, _filename := range filenames {
+		f, err := os.Open(_filename)
+		if err != nil {
+			log.Fatal(err)
+		}
+		class, err := compiler.Parse(f, _filename)
+		f.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := ast.Format(os.Stdout, class); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
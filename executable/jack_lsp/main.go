@@ -0,0 +1,25 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/mingpepe/Nand2teris/jack/lsp"
+)
+
+// Language server for Jack on stdin and stdout, logs go to stderr.
+func main() {
+	var osDir = flag.String("os", "", "directory contains the jack files of the OS API, e.g. projects/12")
+	flag.Parse()
+
+	server := lsp.NewServer(os.Stdin, os.Stdout)
+	if *osDir != "" {
+		if err := server.LoadOS(*osDir); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := server.Serve(); err != nil {
+		log.Fatal(err)
+	}
+}
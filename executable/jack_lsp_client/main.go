@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"github.com/mingpepe/Nand2teris/jack/lsp"
+)
+
+func exist(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// Test client of the language server: opens a jack file, asks for its
+// symbols, then hover, definition and completion at a position, and prints
+// every message of the server.
+func main() {
+	var filename = flag.String("f", "input.jack", "input filename")
+	var server = flag.String("server", "jack_lsp.exe", "language server executable")
+	var osDir = flag.String("os", "", "directory contains the jack files of the OS API, passed to the server")
+	var line = flag.Int("line", 0, "line of the position to query, from 1")
+	var column = flag.Int("col", 0, "column of the position to query, from 1")
+	flag.Parse()
+
+	if !exist(*filename) {
+		log.Printf("file not found: %s", *filename)
+		return
+	}
+	path, err := filepath.Abs(*filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	text, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	args := make([]string, 0)
+	if *osDir != "" {
+		args = append(args, "-os", *osDir)
+	}
+	cmd := exec.Command(*server, args...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		log.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		log.Fatal(err)
+	}
+	conn := lsp.NewConn(stdout, stdin)
+
+	id := 0
+	call := func(method string, params interface{}) {
+		id++
+		if err := conn.Call(id, method, params); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("--> %s\n", method)
+		// print notifications until the response
+		for {
+			msg, err := conn.Read()
+			if err != nil {
+				log.Fatal(err)
+			}
+			printMessage(msg)
+			if string(msg.ID) == strconv.Itoa(id) {
+				return
+			}
+		}
+	}
+	notify := func(method string, params interface{}) {
+		if err := conn.Notify(method, params); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("--> %s\n", method)
+	}
+
+	uri := "file://" + filepath.ToSlash(path)
+	if uri[7] != '/' {
+		uri = "file:///" + filepath.ToSlash(path)
+	}
+	document := lsp.TextDocumentIdentifier{URI: uri}
+	call("initialize", map[string]interface{}{"processId": os.Getpid(), "rootUri": nil, "capabilities": map[string]interface{}{}})
+	notify("initialized", map[string]interface{}{})
+	notify("textDocument/didOpen", lsp.DidOpenTextDocumentParams{
+		TextDocument: lsp.TextDocumentItem{URI: uri, LanguageID: "jack", Version: 1, Text: string(text)},
+	})
+	call("textDocument/documentSymbol", lsp.DocumentSymbolParams{TextDocument: document})
+	if *line > 0 && *column > 0 {
+		position := lsp.TextDocumentPositionParams{
+			TextDocument: document,
+			Position:     lsp.Position{Line: *line - 1, Character: *column - 1},
+		}
+		call("textDocument/hover", position)
+		call("textDocument/definition", position)
+		call("textDocument/completion", position)
+	}
+	call("shutdown", nil)
+	notify("exit", nil)
+	if err := cmd.Wait(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func printMessage(msg *lsp.Message) {
+	data, _ := json.Marshal(msg)
+	fmt.Printf("<-- %s\n", data)
+}
@@ -58,7 +58,11 @@ func main() {
 		defer f.Close()
 
 		tokenizer := compiler.NewTokenizer(f)
+		tokenizer.SetFile(_filename)
 		tokenizer.Parse()
+		for _, err := range tokenizer.Errors() {
+			log.Print(err)
+		}
 
 		length := len(_filename)
 		out_filename := (_filename)[:length-5] + "_KMT.xml"
@@ -2,11 +2,14 @@ package main
 
 import (
 	"flag"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/mingpepe/Nand2teris/cpu"
+	"github.com/mingpepe/Nand2teris/srcmap"
 	"github.com/mingpepe/Nand2teris/vm"
 )
 
@@ -27,6 +30,9 @@ func main() {
 	var bypass_bootstrap = flag.Bool("bypass", false, "bypass bootstrap code for test")
 	var directory = flag.String("d", "", "directory contains vm files")
 	var verbose = flag.Bool("v", false, "output detail")
+	var optimize = flag.Bool("O", false, "generate compact code sharing the call, return and comparison routines, which are appended after the program behind an infinite loop ending it")
+	var link = flag.Bool("link", false, "remove the functions unreachable from Sys.init")
+	var sourceMap = flag.Bool("map", false, "write the vm line of every assembly line to a .asm.map file")
 	flag.Parse()
 
 	filenames := make([]string, 0)
@@ -60,41 +66,83 @@ func main() {
 		}
 	}
 
+	if *directory == "" {
+		idx := strings.LastIndex(*filename, ".")
+		out_filename = (*filename)[:idx] + ".asm"
+	} else {
+		idx := strings.LastIndex(*directory, "\\")
+		dir_name := (*directory)[idx+1:]
+		out_filename = *directory + "\\" + dir_name + ".asm"
+	}
+
 	v := vm.New()
+	v.SetOptimize(*optimize)
+	var m *srcmap.Map
+	if *sourceMap {
+		m = srcmap.New(out_filename)
+		v.SetSourceMap(m)
+	}
+	// plain translation to compare the size with
+	plain := vm.New()
 	code := ""
+	plainCode := ""
 	if !*bypass_bootstrap {
 		if *verbose {
 			log.Println("Write bootstrap code")
 		}
 		code += v.BootstrapCode()
+		plainCode += plain.BootstrapCode()
 	}
 
-	for _, filepath := range filenames {
-		f, err := os.Open(filepath)
+	sources := make([]string, len(filenames))
+	for i, filepath := range filenames {
+		src, err := ioutil.ReadFile(filepath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sources[i] = string(src)
+	}
+	if *link {
+		linked, removed, err := vm.Link(sources)
 		if err != nil {
 			log.Fatal(err)
 		}
-		defer f.Close()
+		sources = linked
+		for _, name := range removed {
+			log.Printf("Remove %s\n", name)
+		}
+		log.Printf("%d functions unreachable from Sys.init removed", len(removed))
+	}
 
+	for i, filepath := range filenames {
 		if *verbose {
 			log.Printf("Compile %s\n", filepath)
 		}
 
 		_filename := get_filename_without_ext(filepath)
-		asm, err := v.Compile(_filename, f)
+		v.SetFile(filepath)
+		asm, err := v.Compile(_filename, strings.NewReader(sources[i]))
 		if err != nil {
-			log.Print(err.Error())
+			log.Fatal(err)
 		}
 		code += asm
+		if *optimize {
+			asm, err := plain.Compile(_filename, strings.NewReader(sources[i]))
+			if err != nil {
+				log.Fatal(err)
+			}
+			plainCode += asm
+		}
 	}
 
-	if *directory == "" {
-		idx := strings.LastIndex(*filename, ".")
-		out_filename = (*filename)[:idx] + ".asm"
-	} else {
-		idx := strings.LastIndex(*directory, "\\")
-		dir_name := (*directory)[idx+1:]
-		out_filename = *directory + "\\" + dir_name + ".asm"
+	if *optimize {
+		code += v.SharedCode()
+		size := vm.CountInstructions(code)
+		plainSize := vm.CountInstructions(plainCode)
+		log.Printf("%d instructions, %d without -O (%.1f%%)", size, plainSize, 100*float64(size)/float64(plainSize))
+		if size > cpu.ROMSize {
+			log.Printf("the program does not fit in the %d words of ROM", cpu.ROMSize)
+		}
 	}
 
 	out_f, err := os.Create(out_filename)
@@ -107,6 +155,17 @@ func main() {
 		log.Print(err.Error())
 	}
 
+	if m != nil {
+		map_f, err := os.Create(out_filename + ".map")
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer map_f.Close()
+		if err := m.Write(map_f); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	if *verbose {
 		log.Printf("Output to %s\n", out_filename)
 	}
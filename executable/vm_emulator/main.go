@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/mingpepe/Nand2teris/vm"
+)
+
+func exist(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+func main() {
+	var filename = flag.String("f", "input.vm", "input filename")
+	var directory = flag.String("d", "", "directory contains vm files")
+	var cycles = flag.Int("n", 1000000, "max number of vm commands to execute")
+	var ramStart = flag.Int("ram", 256, "first RAM address to dump")
+	var ramLength = flag.Int("len", 16, "number of RAM words to dump")
+	var prefer = flag.String("prefer", "", "comma separated OS classes to run from .vm code instead of built-ins")
+	var noBuiltin = flag.Bool("nobuiltin", false, "run only .vm code")
+	var osDirectory = flag.String("os", "", "directory of OS .vm files to load for classes not in the program")
+	flag.Parse()
+
+	e := vm.NewEmulator()
+	if *noBuiltin {
+		e.DisableBuiltins()
+	}
+	if *prefer != "" {
+		e.PreferVM(strings.Split(*prefer, ",")...)
+	}
+	if *directory == "" {
+		if !exist(*filename) {
+			log.Printf("file not found: %s", *filename)
+			return
+		}
+		if !strings.HasSuffix(*filename, ".vm") {
+			log.Printf("input must be a vm file")
+			return
+		}
+		if err := e.LoadFile(*filename); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		if err := e.LoadDir(*directory); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *osDirectory != "" {
+		if err := e.LoadMissing(*osDirectory); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := e.Start(); err != nil {
+		log.Fatal(err)
+	}
+	n, err := e.Run(*cycles)
+	if err != nil {
+		log.Print(err)
+	}
+
+	fmt.Printf("Executed %d commands, halted: %v\n", n, e.Halted)
+	if cmd := e.CurrentCommand(); cmd != nil {
+		fmt.Printf("Next command: %s (%s:%d)\n", cmd.Text, cmd.File, cmd.Line)
+	}
+	fmt.Println("Call stack:")
+	for i := len(e.CallStack) - 1; i >= 0; i-- {
+		frame := e.CallStack[i]
+		fmt.Printf("  %s (ARG=%d, LCL=%d)\n", frame.Function, frame.ARG, frame.LCL)
+	}
+	fmt.Printf("SP=%d LCL=%d ARG=%d THIS=%d THAT=%d\n", e.RAM[0], e.RAM[1], e.RAM[2], e.RAM[3], e.RAM[4])
+	for i := *ramStart; i < *ramStart+*ramLength && i < vm.RAMSize; i++ {
+		fmt.Printf("RAM[%d] = %d\n", i, int16(e.RAM[i]))
+	}
+}
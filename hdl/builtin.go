@@ -0,0 +1,229 @@
+package hdl
+
+// builtin is a chip implemented in Go, mirroring tools/builtInChips.
+type builtin struct {
+	inputs  []Pin
+	outputs []Pin
+	// eval computes the outputs from the inputs, both in pin order
+	eval func(in, out []uint16)
+
+	// Clocked chips keep memory words per instance, updated from the inputs
+	// listed in clocked on tick. Registers show their memory on the outputs
+	// through latch words copied on tock, the other inputs may still drive
+	// the outputs through read.
+	clocked []string
+	memory  int
+	latches int
+	read    func(in, out, mem, latch []uint16)
+	tick    func(in, mem []uint16)
+	tock    func(mem, latch []uint16)
+}
+
+// combinational tells whether input i of the chip can change its outputs
+// without a clock cycle.
+func (b *builtin) combinational(i int) bool {
+	for _, name := range b.clocked {
+		if b.inputs[i].Name == name {
+			return false
+		}
+	}
+	return true
+}
+
+func pins(spec ...interface{}) []Pin {
+	result := make([]Pin, 0, len(spec)/2)
+	for i := 0; i < len(spec); i += 2 {
+		result = append(result, Pin{Name: spec[i].(string), Width: spec[i+1].(int)})
+	}
+	return result
+}
+
+func bit(b bool) uint16 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+var builtins = map[string]*builtin{
+	"Nand": {
+		inputs:  pins("a", 1, "b", 1),
+		outputs: pins("out", 1),
+		eval: func(in, out []uint16) {
+			out[0] = ^(in[0] & in[1]) & 1
+		},
+	},
+	"Not": {
+		inputs:  pins("in", 1),
+		outputs: pins("out", 1),
+		eval: func(in, out []uint16) {
+			out[0] = ^in[0] & 1
+		},
+	},
+	"And": {
+		inputs:  pins("a", 1, "b", 1),
+		outputs: pins("out", 1),
+		eval: func(in, out []uint16) {
+			out[0] = in[0] & in[1]
+		},
+	},
+	"Or": {
+		inputs:  pins("a", 1, "b", 1),
+		outputs: pins("out", 1),
+		eval: func(in, out []uint16) {
+			out[0] = in[0] | in[1]
+		},
+	},
+	"Xor": {
+		inputs:  pins("a", 1, "b", 1),
+		outputs: pins("out", 1),
+		eval: func(in, out []uint16) {
+			out[0] = in[0] ^ in[1]
+		},
+	},
+	"Mux": {
+		inputs:  pins("a", 1, "b", 1, "sel", 1),
+		outputs: pins("out", 1),
+		eval: func(in, out []uint16) {
+			out[0] = in[in[2]]
+		},
+	},
+	"DMux": {
+		inputs:  pins("in", 1, "sel", 1),
+		outputs: pins("a", 1, "b", 1),
+		eval: func(in, out []uint16) {
+			out[0], out[1] = 0, 0
+			out[in[1]] = in[0]
+		},
+	},
+	"Not16": {
+		inputs:  pins("in", 16),
+		outputs: pins("out", 16),
+		eval: func(in, out []uint16) {
+			out[0] = ^in[0]
+		},
+	},
+	"And16": {
+		inputs:  pins("a", 16, "b", 16),
+		outputs: pins("out", 16),
+		eval: func(in, out []uint16) {
+			out[0] = in[0] & in[1]
+		},
+	},
+	"Or16": {
+		inputs:  pins("a", 16, "b", 16),
+		outputs: pins("out", 16),
+		eval: func(in, out []uint16) {
+			out[0] = in[0] | in[1]
+		},
+	},
+	"Mux16": {
+		inputs:  pins("a", 16, "b", 16, "sel", 1),
+		outputs: pins("out", 16),
+		eval: func(in, out []uint16) {
+			out[0] = in[in[2]]
+		},
+	},
+	"Or8Way": {
+		inputs:  pins("in", 8),
+		outputs: pins("out", 1),
+		eval: func(in, out []uint16) {
+			out[0] = bit(in[0] != 0)
+		},
+	},
+	"Mux4Way16": {
+		inputs:  pins("a", 16, "b", 16, "c", 16, "d", 16, "sel", 2),
+		outputs: pins("out", 16),
+		eval: func(in, out []uint16) {
+			out[0] = in[in[4]]
+		},
+	},
+	"Mux8Way16": {
+		inputs:  pins("a", 16, "b", 16, "c", 16, "d", 16, "e", 16, "f", 16, "g", 16, "h", 16, "sel", 3),
+		outputs: pins("out", 16),
+		eval: func(in, out []uint16) {
+			out[0] = in[in[8]]
+		},
+	},
+	"DMux4Way": {
+		inputs:  pins("in", 1, "sel", 2),
+		outputs: pins("a", 1, "b", 1, "c", 1, "d", 1),
+		eval: func(in, out []uint16) {
+			for i := range out {
+				out[i] = 0
+			}
+			out[in[1]] = in[0]
+		},
+	},
+	"DMux8Way": {
+		inputs:  pins("in", 1, "sel", 3),
+		outputs: pins("a", 1, "b", 1, "c", 1, "d", 1, "e", 1, "f", 1, "g", 1, "h", 1),
+		eval: func(in, out []uint16) {
+			for i := range out {
+				out[i] = 0
+			}
+			out[in[1]] = in[0]
+		},
+	},
+	"HalfAdder": {
+		inputs:  pins("a", 1, "b", 1),
+		outputs: pins("sum", 1, "carry", 1),
+		eval: func(in, out []uint16) {
+			s := in[0] + in[1]
+			out[0], out[1] = s&1, s>>1
+		},
+	},
+	"FullAdder": {
+		inputs:  pins("a", 1, "b", 1, "c", 1),
+		outputs: pins("sum", 1, "carry", 1),
+		eval: func(in, out []uint16) {
+			s := in[0] + in[1] + in[2]
+			out[0], out[1] = s&1, s>>1
+		},
+	},
+	"Add16": {
+		inputs:  pins("a", 16, "b", 16),
+		outputs: pins("out", 16),
+		eval: func(in, out []uint16) {
+			out[0] = in[0] + in[1]
+		},
+	},
+	"Inc16": {
+		inputs:  pins("in", 16),
+		outputs: pins("out", 16),
+		eval: func(in, out []uint16) {
+			out[0] = in[0] + 1
+		},
+	},
+	"ALU": {
+		inputs:  pins("x", 16, "y", 16, "zx", 1, "nx", 1, "zy", 1, "ny", 1, "f", 1, "no", 1),
+		outputs: pins("out", 16, "zr", 1, "ng", 1),
+		eval: func(in, out []uint16) {
+			x, y := in[0], in[1]
+			if in[2] == 1 {
+				x = 0
+			}
+			if in[3] == 1 {
+				x = ^x
+			}
+			if in[4] == 1 {
+				y = 0
+			}
+			if in[5] == 1 {
+				y = ^y
+			}
+			var result uint16
+			if in[6] == 1 {
+				result = x + y
+			} else {
+				result = x & y
+			}
+			if in[7] == 1 {
+				result = ^result
+			}
+			out[0] = result
+			out[1] = bit(result == 0)
+			out[2] = result >> 15
+		},
+	},
+}
@@ -0,0 +1,107 @@
+package hdl
+
+func init() {
+	builtins["DFF"] = &builtin{
+		inputs:  pins("in", 1),
+		outputs: pins("out", 1),
+		clocked: []string{"in"},
+		memory:  1,
+		latches: 1,
+		read:    readLatch,
+		tick: func(in, mem []uint16) {
+			mem[0] = in[0]
+		},
+		tock: commitLatch,
+	}
+	builtins["Bit"] = register(1)
+	builtins["Register"] = register(16)
+	builtins["ARegister"] = register(16)
+	builtins["DRegister"] = register(16)
+	builtins["PC"] = &builtin{
+		inputs:  pins("in", 16, "load", 1, "inc", 1, "reset", 1),
+		outputs: pins("out", 16),
+		clocked: []string{"in", "load", "inc", "reset"},
+		memory:  1,
+		latches: 1,
+		read:    readLatch,
+		tick: func(in, mem []uint16) {
+			switch {
+			case in[3] == 1:
+				mem[0] = 0
+			case in[1] == 1:
+				mem[0] = in[0]
+			case in[2] == 1:
+				mem[0]++
+			}
+		},
+		tock: commitLatch,
+	}
+	builtins["RAM8"] = ram(3)
+	builtins["RAM64"] = ram(6)
+	builtins["RAM512"] = ram(9)
+	builtins["RAM4K"] = ram(12)
+	builtins["RAM16K"] = ram(14)
+	builtins["Screen"] = ram(13)
+	builtins["ROM32K"] = &builtin{
+		inputs:  pins("address", 15),
+		outputs: pins("out", 16),
+		memory:  1 << 15,
+		read: func(in, out, mem, latch []uint16) {
+			out[0] = mem[in[0]]
+		},
+	}
+	// The memory word holds the key currently pressed
+	builtins["Keyboard"] = &builtin{
+		inputs:  pins(),
+		outputs: pins("out", 16),
+		memory:  1,
+		read: func(in, out, mem, latch []uint16) {
+			out[0] = mem[0]
+		},
+	}
+}
+
+func readLatch(in, out, mem, latch []uint16) {
+	out[0] = latch[0]
+}
+
+func commitLatch(mem, latch []uint16) {
+	latch[0] = mem[0]
+}
+
+// register stores its input on tick when load is set, the output follows on tock.
+func register(width int) *builtin {
+	return &builtin{
+		inputs:  pins("in", width, "load", 1),
+		outputs: pins("out", width),
+		clocked: []string{"in", "load"},
+		memory:  1,
+		latches: 1,
+		read:    readLatch,
+		tick: func(in, mem []uint16) {
+			if in[1] == 1 {
+				mem[0] = in[0]
+			}
+		},
+		tock: commitLatch,
+	}
+}
+
+// ram builds a memory of 2^addressBits words written on tick, while reads
+// follow the address immediately.
+func ram(addressBits int) *builtin {
+	return &builtin{
+		inputs:  pins("in", 16, "load", 1, "address", addressBits),
+		outputs: pins("out", 16),
+		clocked: []string{"in", "load"},
+		memory:  1 << uint(addressBits),
+		read: func(in, out, mem, latch []uint16) {
+			out[0] = mem[in[2]]
+		},
+		tick: func(in, mem []uint16) {
+			if in[1] == 1 {
+				mem[in[2]] = in[0]
+			}
+		},
+	}
+}
@@ -0,0 +1,256 @@
+package hdl
+
+import (
+	"fmt"
+)
+
+// wire copies bits between a pin of the chip and a pin of one of its parts.
+type wire struct {
+	// Index and bit range in the values of the chip, pin is -1 for true/false
+	pin    int
+	lo, hi int
+	value  uint16
+	// Index and bit range in the values of the part
+	partPin        int
+	partLo, partHi int
+	// Both sides are entire pins
+	whole bool
+}
+
+type partType struct {
+	typ     *chipType
+	line    int
+	inputs  []wire
+	outputs []wire
+}
+
+// chipType is a compiled chip definition shared by all its instances.
+type chipType struct {
+	name    string
+	inputs  []Pin
+	outputs []Pin
+	// Inputs first, then outputs, then internal pins
+	pins    []Pin
+	index   map[string]int
+	builtin *builtin
+	parts   []*partType
+}
+
+func newChipType(name string, inputs, outputs []Pin) (*chipType, error) {
+	t := &chipType{name: name, inputs: inputs, outputs: outputs}
+	t.index = make(map[string]int)
+	for _, pin := range append(append([]Pin{}, inputs...), outputs...) {
+		if err := t.addPin(pin); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (t *chipType) addPin(pin Pin) error {
+	if _, exist := t.index[pin.Name]; exist {
+		return fmt.Errorf("pin %s is declared twice", pin.Name)
+	}
+	if pin.Name == "true" || pin.Name == "false" {
+		return fmt.Errorf("%s cannot be used as a pin name", pin.Name)
+	}
+	t.index[pin.Name] = len(t.pins)
+	t.pins = append(t.pins, pin)
+	return nil
+}
+
+func (t *chipType) isInput(idx int) bool {
+	return idx < len(t.inputs)
+}
+
+func (t *chipType) isOutput(idx int) bool {
+	return idx >= len(t.inputs) && idx < len(t.inputs)+len(t.outputs)
+}
+
+func newBuiltinType(name string) (*chipType, bool) {
+	b, exist := builtins[name]
+	if !exist {
+		return nil, false
+	}
+	t, err := newChipType(name, b.inputs, b.outputs)
+	if err != nil {
+		panic(err)
+	}
+	t.builtin = b
+	return t, true
+}
+
+// compile resolves the parts of def, lookup returning the type of a part by name.
+func compile(def *ChipDef, lookup func(name string) (*chipType, error)) (*chipType, error) {
+	t, err := newChipType(def.Name, def.Inputs, def.Outputs)
+	if err != nil {
+		return nil, err
+	}
+	if def.Builtin != "" {
+		b, exist := builtins[def.Builtin]
+		if !exist {
+			return nil, fmt.Errorf("unknown builtin chip %s", def.Builtin)
+		}
+		if !samePins(b.inputs, def.Inputs) || !samePins(b.outputs, def.Outputs) {
+			return nil, fmt.Errorf("pins of %s do not match builtin chip %s", def.Name, def.Builtin)
+		}
+		t.builtin = b
+		return t, nil
+	}
+
+	parts := make([]*partType, len(def.Parts))
+	for i, part := range def.Parts {
+		pt, err := lookup(part.Name)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", part.Line, err)
+		}
+		parts[i] = &partType{typ: pt, line: part.Line}
+	}
+
+	// Outputs of parts first, they declare the internal pins
+	written := make(map[int]uint16)
+	for i, part := range def.Parts {
+		for _, conn := range part.Connections {
+			w, err := partWire(parts[i].typ, conn)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", part.Line, err)
+			}
+			if parts[i].typ.isInput(w.partPin) {
+				continue
+			}
+			ext := conn.External
+			if ext.Name == "true" || ext.Name == "false" {
+				return nil, fmt.Errorf("line %d: output %s cannot be connected to %s", part.Line, conn.Internal, ext.Name)
+			}
+			idx, exist := t.index[ext.Name]
+			if !exist {
+				if !ext.Whole() {
+					return nil, fmt.Errorf("line %d: internal pin %s cannot be sub-bused", part.Line, ext)
+				}
+				t.addPin(Pin{Name: ext.Name, Width: w.partHi - w.partLo + 1})
+				idx = t.index[ext.Name]
+			}
+			if t.isInput(idx) {
+				return nil, fmt.Errorf("line %d: input pin %s cannot be an output of a part", part.Line, ext.Name)
+			}
+			if !t.isOutput(idx) && !ext.Whole() {
+				return nil, fmt.Errorf("line %d: internal pin %s cannot be sub-bused", part.Line, ext)
+			}
+			if err := t.bind(&w, ext, idx); err != nil {
+				return nil, fmt.Errorf("line %d: %v", part.Line, err)
+			}
+			bits := mask(w.lo, w.hi)
+			if written[idx]&bits != 0 {
+				return nil, fmt.Errorf("line %d: pin %s has more than one source", part.Line, ext)
+			}
+			written[idx] |= bits
+			parts[i].outputs = append(parts[i].outputs, w)
+		}
+	}
+
+	for i, part := range def.Parts {
+		connected := make(map[int]uint16)
+		for _, conn := range part.Connections {
+			w, _ := partWire(parts[i].typ, conn)
+			if !parts[i].typ.isInput(w.partPin) {
+				continue
+			}
+			bits := mask(w.partLo, w.partHi)
+			if connected[w.partPin]&bits != 0 {
+				return nil, fmt.Errorf("line %d: pin %s is connected more than once", part.Line, conn.Internal)
+			}
+			connected[w.partPin] |= bits
+			ext := conn.External
+			if ext.Name == "true" || ext.Name == "false" {
+				if !ext.Whole() {
+					return nil, fmt.Errorf("line %d: %s cannot be sub-bused", part.Line, ext.Name)
+				}
+				w.pin, w.lo, w.hi = -1, 0, w.partHi-w.partLo
+				if ext.Name == "true" {
+					w.value = 0xffff
+				}
+				parts[i].inputs = append(parts[i].inputs, w)
+				continue
+			}
+			idx, exist := t.index[ext.Name]
+			if !exist {
+				return nil, fmt.Errorf("line %d: pin %s has no source", part.Line, ext.Name)
+			}
+			if t.isOutput(idx) {
+				return nil, fmt.Errorf("line %d: output pin %s cannot be an input of a part", part.Line, ext.Name)
+			}
+			if !t.isInput(idx) && !ext.Whole() {
+				return nil, fmt.Errorf("line %d: internal pin %s cannot be sub-bused", part.Line, ext)
+			}
+			if err := t.bind(&w, ext, idx); err != nil {
+				return nil, fmt.Errorf("line %d: %v", part.Line, err)
+			}
+			parts[i].inputs = append(parts[i].inputs, w)
+		}
+	}
+
+	t.parts = parts
+	return t, nil
+}
+
+// partWire resolves the part side of a connection.
+func partWire(pt *chipType, conn Connection) (wire, error) {
+	w := wire{}
+	ref := conn.Internal
+	idx, exist := pt.index[ref.Name]
+	if !exist || idx >= len(pt.inputs)+len(pt.outputs) {
+		return w, fmt.Errorf("%s has no pin %s", pt.name, ref.Name)
+	}
+	width := pt.pins[idx].Width
+	w.partPin, w.partLo, w.partHi = idx, 0, width-1
+	w.whole = ref.Whole()
+	if !ref.Whole() {
+		if ref.Hi >= width {
+			return w, fmt.Errorf("%s is out of range for %s[%d]", ref, ref.Name, width)
+		}
+		w.partLo, w.partHi = ref.Lo, ref.Hi
+	}
+	return w, nil
+}
+
+// bind resolves the chip side of a connection and checks the widths agree.
+func (t *chipType) bind(w *wire, ref PinRef, idx int) error {
+	width := t.pins[idx].Width
+	w.pin, w.lo, w.hi = idx, 0, width-1
+	w.whole = w.whole && ref.Whole()
+	if !ref.Whole() {
+		if ref.Hi >= width {
+			return fmt.Errorf("%s is out of range for %s[%d]", ref, ref.Name, width)
+		}
+		w.lo, w.hi = ref.Lo, ref.Hi
+	}
+	if w.hi-w.lo != w.partHi-w.partLo {
+		return fmt.Errorf("width mismatch: %d bits connected to %d bits of %s", w.partHi-w.partLo+1, w.hi-w.lo+1, ref)
+	}
+	return nil
+}
+
+func samePins(a, b []Pin) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func mask(lo, hi int) uint16 {
+	return uint16((1<<uint(hi-lo+1))-1) << uint(lo)
+}
+
+func getBits(v uint16, lo, hi int) uint16 {
+	return (v & mask(lo, hi)) >> uint(lo)
+}
+
+func setBits(v uint16, lo, hi int, bits uint16) uint16 {
+	m := mask(lo, hi)
+	return (v &^ m) | ((bits << uint(lo)) & m)
+}
@@ -0,0 +1,79 @@
+package hdl
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Loader resolves chips from a project directory, falling back to the
+// built-in chips, and caches the compiled definitions.
+type Loader struct {
+	dir     string
+	types   map[string]*chipType
+	loading map[string]bool
+}
+
+func NewLoader(dir string) *Loader {
+	l := &Loader{}
+	l.dir = dir
+	l.types = make(map[string]*chipType)
+	l.loading = make(map[string]bool)
+	return l
+}
+
+// Load builds a new instance of the chip, e.g. "Mux16" from dir/Mux16.hdl.
+func (l *Loader) Load(name string) (*Chip, error) {
+	t, err := l.chipType(name)
+	if err != nil {
+		return nil, err
+	}
+	return newChip(t)
+}
+
+// LoadFile loads a chip from an .hdl file, resolving its parts in the same directory.
+func LoadFile(path string) (*Chip, error) {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return NewLoader(filepath.Dir(path)).Load(name)
+}
+
+func (l *Loader) chipType(name string) (*chipType, error) {
+	if t, exist := l.types[name]; exist {
+		return t, nil
+	}
+	if l.loading[name] {
+		return nil, fmt.Errorf("chip %s is made of itself", name)
+	}
+
+	path := filepath.Join(l.dir, name+".hdl")
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		t, exist := newBuiltinType(name)
+		if !exist {
+			return nil, fmt.Errorf("chip %s not found", name)
+		}
+		l.types[name] = t
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	def, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if def.Name != name {
+		return nil, fmt.Errorf("%s: defines chip %s", path, def.Name)
+	}
+	l.loading[name] = true
+	t, err := compile(def, l.chipType)
+	delete(l.loading, name)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	l.types[name] = t
+	return t, nil
+}
@@ -0,0 +1,354 @@
+package hdl
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type Pin struct {
+	Name  string
+	Width int
+}
+
+// PinRef is one side of a connection, e.g. a, a[3] or a[0..7].
+// Lo and Hi are -1 when the whole pin is meant.
+type PinRef struct {
+	Name string
+	Lo   int
+	Hi   int
+}
+
+func (r PinRef) Whole() bool {
+	return r.Lo == -1
+}
+
+func (r PinRef) String() string {
+	if r.Whole() {
+		return r.Name
+	}
+	if r.Lo == r.Hi {
+		return fmt.Sprintf("%s[%d]", r.Name, r.Lo)
+	}
+	return fmt.Sprintf("%s[%d..%d]", r.Name, r.Lo, r.Hi)
+}
+
+type Connection struct {
+	// Pin of the part
+	Internal PinRef
+	// Pin of the chip being defined, or true/false
+	External PinRef
+}
+
+type Part struct {
+	Name        string
+	Connections []Connection
+	Line        int
+}
+
+type ChipDef struct {
+	Name    string
+	Inputs  []Pin
+	Outputs []Pin
+	Parts   []Part
+	// Name after BUILTIN, empty for chips made of parts
+	Builtin string
+	Clocked []string
+}
+
+type token struct {
+	text string
+	line int
+}
+
+type parser struct {
+	tokens []token
+	ptr    int
+}
+
+func Parse(reader io.Reader) (*ChipDef, error) {
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := tokenize(string(content))
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	return p.parseChip()
+}
+
+func tokenize(src string) ([]token, error) {
+	tokens := make([]token, 0)
+	line := 1
+	for i := 0; i < len(src); {
+		ch := rune(src[i])
+		switch {
+		case ch == '\n':
+			line++
+			i++
+		case unicode.IsSpace(ch):
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end == -1 {
+				return nil, fmt.Errorf("line %d: unterminated comment", line)
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+		case strings.HasPrefix(src[i:], ".."):
+			tokens = append(tokens, token{"..", line})
+			i += 2
+		case strings.ContainsRune("{}()[],;=:", ch):
+			tokens = append(tokens, token{string(ch), line})
+			i++
+		case isIdentChar(ch):
+			start := i
+			for i < len(src) && isIdentChar(rune(src[i])) {
+				i++
+			}
+			tokens = append(tokens, token{src[start:i], line})
+		default:
+			return nil, fmt.Errorf("line %d: unexpected character %q", line, ch)
+		}
+	}
+	return tokens, nil
+}
+
+func isIdentChar(ch rune) bool {
+	return ch == '_' || unicode.IsLetter(ch) || unicode.IsDigit(ch)
+}
+
+func (p *parser) peek() string {
+	if p.ptr < len(p.tokens) {
+		return p.tokens[p.ptr].text
+	}
+	return ""
+}
+
+func (p *parser) line() int {
+	if p.ptr < len(p.tokens) {
+		return p.tokens[p.ptr].line
+	}
+	if len(p.tokens) > 0 {
+		return p.tokens[len(p.tokens)-1].line
+	}
+	return 1
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.line(), fmt.Sprintf(format, args...))
+}
+
+func (p *parser) expect(text string) error {
+	if p.peek() != text {
+		return p.errorf("expected %q, got %q", text, p.peek())
+	}
+	p.ptr++
+	return nil
+}
+
+func (p *parser) identifier() (string, error) {
+	s := p.peek()
+	if s == "" || !isIdentChar(rune(s[0])) || unicode.IsDigit(rune(s[0])) {
+		return "", p.errorf("expected identifier, got %q", s)
+	}
+	p.ptr++
+	return s, nil
+}
+
+func (p *parser) number() (int, error) {
+	n, err := strconv.Atoi(p.peek())
+	if err != nil {
+		return 0, p.errorf("expected number, got %q", p.peek())
+	}
+	p.ptr++
+	return n, nil
+}
+
+func (p *parser) parseChip() (*ChipDef, error) {
+	def := &ChipDef{}
+	if err := p.expect("CHIP"); err != nil {
+		return nil, err
+	}
+	name, err := p.identifier()
+	if err != nil {
+		return nil, err
+	}
+	def.Name = name
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+
+	for {
+		switch p.peek() {
+		case "IN":
+			p.ptr++
+			if def.Inputs, err = p.parsePinList(); err != nil {
+				return nil, err
+			}
+		case "OUT":
+			p.ptr++
+			if def.Outputs, err = p.parsePinList(); err != nil {
+				return nil, err
+			}
+		case "PARTS":
+			p.ptr++
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			if def.Parts, err = p.parseParts(); err != nil {
+				return nil, err
+			}
+		case "BUILTIN":
+			p.ptr++
+			if def.Builtin, err = p.identifier(); err != nil {
+				return nil, err
+			}
+			if err := p.expect(";"); err != nil {
+				return nil, err
+			}
+		case "CLOCKED":
+			p.ptr++
+			if def.Clocked, err = p.parseNameList(); err != nil {
+				return nil, err
+			}
+		case "}":
+			p.ptr++
+			if p.ptr != len(p.tokens) {
+				return nil, p.errorf("unexpected %q after chip definition", p.peek())
+			}
+			return def, nil
+		default:
+			return nil, p.errorf("unexpected %q", p.peek())
+		}
+	}
+}
+
+// parsePinList reads "a[16], b, sel;"
+func (p *parser) parsePinList() ([]Pin, error) {
+	pins := make([]Pin, 0)
+	for {
+		name, err := p.identifier()
+		if err != nil {
+			return nil, err
+		}
+		pin := Pin{Name: name, Width: 1}
+		if p.peek() == "[" {
+			p.ptr++
+			if pin.Width, err = p.number(); err != nil {
+				return nil, err
+			}
+			if pin.Width < 1 || pin.Width > 16 {
+				return nil, p.errorf("pin width of %s must be 1..16", name)
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+		}
+		pins = append(pins, pin)
+		if p.peek() == ";" {
+			p.ptr++
+			return pins, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseNameList() ([]string, error) {
+	names := make([]string, 0)
+	for {
+		name, err := p.identifier()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		if p.peek() == ";" {
+			p.ptr++
+			return names, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseParts() ([]Part, error) {
+	parts := make([]Part, 0)
+	for p.peek() != "}" && p.peek() != "" && p.peek() != "BUILTIN" && p.peek() != "CLOCKED" {
+		part := Part{Line: p.line()}
+		name, err := p.identifier()
+		if err != nil {
+			return nil, err
+		}
+		part.Name = name
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		for {
+			conn := Connection{}
+			if conn.Internal, err = p.parsePinRef(); err != nil {
+				return nil, err
+			}
+			if err := p.expect("="); err != nil {
+				return nil, err
+			}
+			if conn.External, err = p.parsePinRef(); err != nil {
+				return nil, err
+			}
+			part.Connections = append(part.Connections, conn)
+			if p.peek() == ")" {
+				p.ptr++
+				break
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expect(";"); err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+func (p *parser) parsePinRef() (PinRef, error) {
+	ref := PinRef{Lo: -1, Hi: -1}
+	name, err := p.identifier()
+	if err != nil {
+		return ref, err
+	}
+	ref.Name = name
+	if p.peek() != "[" {
+		return ref, nil
+	}
+	p.ptr++
+	if ref.Lo, err = p.number(); err != nil {
+		return ref, err
+	}
+	ref.Hi = ref.Lo
+	if p.peek() == ".." {
+		p.ptr++
+		if ref.Hi, err = p.number(); err != nil {
+			return ref, err
+		}
+	}
+	if ref.Lo > ref.Hi || ref.Hi > 15 {
+		return ref, p.errorf("bad sub bus %s", ref)
+	}
+	if err := p.expect("]"); err != nil {
+		return ref, err
+	}
+	return ref, nil
+}
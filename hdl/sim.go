@@ -0,0 +1,469 @@
+package hdl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// instance is a built-in chip somewhere inside the simulated chip.
+type instance struct {
+	typ *chipType
+	// Nets of the inputs followed by the outputs
+	nets  []int32
+	mem   []uint16
+	latch []uint16
+}
+
+// step either copies bits between two nets or evaluates a built-in part.
+type step struct {
+	// from is -1 for true/false
+	from       int32
+	to         int32
+	lo, hi     uint8
+	toLo, toHi uint8
+	value      uint16
+	// Index into instances, -1 for copy steps
+	inst int32
+	// Where the step comes from, for error messages
+	chip *chipType
+	part *partType
+}
+
+// Chip is a simulated chip. Its parts are flattened down to the built-in
+// chips, so that evaluation order and combinational loops are decided per pin
+// rather than per part, and clocked inputs do not count as dependencies.
+// Pins connected as a whole share one net, the other connections become copy
+// steps. Eval only runs the steps whose inputs changed.
+type Chip struct {
+	typ       *chipType
+	pins      []int32
+	values    []uint16
+	steps     []step
+	instances []instance
+	// Steps reading net n are readers[readerStart[n]:readerStart[n+1]]
+	readerStart []int32
+	readers     []int32
+	dirty       []bool
+	// Steps of the parts with memory
+	clocked []int32
+	in, out []uint16
+}
+
+type builder struct {
+	c      *Chip
+	parent []int32
+	// Backing array for the nets of all instances
+	nets []int32
+}
+
+func (b *builder) find(x int32) int32 {
+	for b.parent[x] != x {
+		b.parent[x] = b.parent[b.parent[x]]
+		x = b.parent[x]
+	}
+	return x
+}
+
+func newChip(t *chipType) (*Chip, error) {
+	c := &Chip{typ: t}
+	c.in = make([]uint16, 16)
+	c.out = make([]uint16, 16)
+	b := &builder{c: c}
+	n, instances, wires := size(t)
+	c.instances = make([]instance, 0, instances)
+	c.steps = make([]step, 0, instances+wires)
+	b.nets = make([]int32, 0, n)
+	b.parent = make([]int32, n)
+	for i := range b.parent {
+		b.parent[i] = int32(i)
+	}
+	b.build(t, 0, nil, nil)
+
+	// Number the nets and rewrite every slot to its net
+	net := make([]int32, n)
+	count := int32(0)
+	for i := range net {
+		if b.find(int32(i)) == int32(i) {
+			net[i] = count
+			count++
+		}
+	}
+	resolve := func(slot int32) int32 {
+		return net[b.find(slot)]
+	}
+	c.values = make([]uint16, count)
+	c.pins = make([]int32, len(t.pins))
+	for i := range c.pins {
+		c.pins[i] = resolve(int32(i))
+	}
+	for i := range c.steps {
+		s := &c.steps[i]
+		if s.inst != -1 {
+			continue
+		}
+		if s.from != -1 {
+			s.from = resolve(s.from)
+		}
+		s.to = resolve(s.to)
+	}
+	for i := range c.instances {
+		nets := c.instances[i].nets
+		for j, slot := range nets {
+			nets[j] = resolve(slot)
+		}
+	}
+
+	if err := c.sortSteps(); err != nil {
+		return nil, err
+	}
+	c.dirty = make([]bool, len(c.steps))
+	for i := range c.dirty {
+		c.dirty[i] = true
+	}
+	return c, nil
+}
+
+// size counts the pins, built-in parts and connections of a chip and its parts.
+func size(t *chipType) (int, int, int) {
+	pins, instances, wires := len(t.pins), 0, 0
+	if t.builtin != nil {
+		instances = 1
+	}
+	for _, part := range t.parts {
+		p, i, w := size(part.typ)
+		pins += p
+		instances += i
+		wires += w + len(part.inputs) + len(part.outputs)
+	}
+	return pins, instances, wires
+}
+
+// build adds the steps of a chip whose pins start at base and returns the
+// first slot after it and its parts.
+func (b *builder) build(t *chipType, base int32, owner *chipType, part *partType) int32 {
+	c := b.c
+	next := base + int32(len(t.pins))
+	if t.builtin != nil {
+		inst := instance{typ: t}
+		start := len(b.nets)
+		for i := 0; i < len(t.inputs)+len(t.outputs); i++ {
+			b.nets = append(b.nets, base+int32(i))
+		}
+		inst.nets = b.nets[start:len(b.nets):len(b.nets)]
+		if t.builtin.memory > 0 {
+			inst.mem = make([]uint16, t.builtin.memory)
+			inst.latch = make([]uint16, t.builtin.latches)
+		}
+		c.steps = append(c.steps, step{inst: int32(len(c.instances)), chip: owner, part: part})
+		c.instances = append(c.instances, inst)
+		return next
+	}
+
+	for _, pt := range t.parts {
+		partBase := next
+		next = b.build(pt.typ, partBase, t, pt)
+		for _, w := range pt.inputs {
+			s := step{from: -1, value: w.value, lo: uint8(w.lo), hi: uint8(w.hi), inst: -1, chip: t, part: pt}
+			s.to, s.toLo, s.toHi = partBase+int32(w.partPin), uint8(w.partLo), uint8(w.partHi)
+			if w.pin != -1 {
+				s.from = base + int32(w.pin)
+			}
+			b.connect(s, w.whole)
+		}
+		for _, w := range pt.outputs {
+			s := step{from: partBase + int32(w.partPin), lo: uint8(w.partLo), hi: uint8(w.partHi), inst: -1, chip: t, part: pt}
+			s.to, s.toLo, s.toHi = base+int32(w.pin), uint8(w.lo), uint8(w.hi)
+			b.connect(s, w.whole)
+		}
+	}
+	return next
+}
+
+// connect joins two whole pins into one net, otherwise adds the copy step.
+func (b *builder) connect(s step, whole bool) {
+	if whole && s.from != -1 {
+		b.parent[b.find(s.from)] = b.find(s.to)
+		return
+	}
+	b.c.steps = append(b.c.steps, s)
+}
+
+// reads calls f with each net the step depends on combinationally.
+func (c *Chip) reads(s *step, f func(net int32)) {
+	if s.inst == -1 {
+		if s.from != -1 {
+			f(s.from)
+		}
+		return
+	}
+	inst := &c.instances[s.inst]
+	for i := range inst.typ.inputs {
+		if inst.typ.builtin.combinational(i) {
+			f(inst.nets[i])
+		}
+	}
+}
+
+// writes calls f with each net the step drives.
+func (c *Chip) writes(s *step, f func(net int32)) {
+	if s.inst == -1 {
+		f(s.to)
+		return
+	}
+	inst := &c.instances[s.inst]
+	for _, net := range inst.nets[len(inst.typ.inputs):] {
+		f(net)
+	}
+}
+
+// index builds a table from each net to the steps returned by edges.
+func (c *Chip) index(edges func(s *step, f func(net int32))) ([]int32, []int32) {
+	start := make([]int32, len(c.values)+1)
+	for i := range c.steps {
+		edges(&c.steps[i], func(net int32) {
+			start[net+1]++
+		})
+	}
+	for i := 1; i < len(start); i++ {
+		start[i] += start[i-1]
+	}
+	list := make([]int32, start[len(start)-1])
+	fill := make([]int32, len(c.values))
+	copy(fill, start)
+	for i := range c.steps {
+		edges(&c.steps[i], func(net int32) {
+			list[fill[net]] = int32(i)
+			fill[net]++
+		})
+	}
+	return start, list
+}
+
+// sortSteps orders the steps so that every net is written before it is read.
+func (c *Chip) sortSteps() error {
+	writerStart, writers := c.index(c.writes)
+	readerStart, readers := c.index(c.reads)
+
+	indegree := make([]int32, len(c.steps))
+	for i := range c.steps {
+		c.reads(&c.steps[i], func(net int32) {
+			indegree[i] += writerStart[net+1] - writerStart[net]
+		})
+	}
+	order := make([]int32, 0, len(c.steps))
+	for i := range c.steps {
+		if indegree[i] == 0 {
+			order = append(order, int32(i))
+		}
+	}
+	for k := 0; k < len(order); k++ {
+		c.writes(&c.steps[order[k]], func(net int32) {
+			for _, j := range readers[readerStart[net]:readerStart[net+1]] {
+				indegree[j]--
+				if indegree[j] == 0 {
+					order = append(order, j)
+				}
+			}
+		})
+	}
+
+	if len(order) != len(c.steps) {
+		// Walk back through unsorted steps until one repeats, it is on the loop
+		i := int32(0)
+		for indegree[i] == 0 {
+			i++
+		}
+		seen := make(map[int32]bool)
+		for !seen[i] {
+			seen[i] = true
+			prev := i
+			c.reads(&c.steps[i], func(net int32) {
+				for _, j := range writers[writerStart[net]:writerStart[net+1]] {
+					if indegree[j] > 0 && i == prev {
+						i = j
+					}
+				}
+			})
+		}
+		s := c.steps[i]
+		return fmt.Errorf("%s.hdl: line %d: combinational loop through %s", s.chip.name, s.part.line, s.part.typ.name)
+	}
+
+	sorted := make([]step, len(c.steps))
+	for k, i := range order {
+		sorted[k] = c.steps[i]
+	}
+	c.steps = sorted
+	c.readerStart, c.readers = c.index(c.reads)
+	for i, s := range c.steps {
+		if s.inst != -1 && c.instances[s.inst].mem != nil {
+			c.clocked = append(c.clocked, int32(i))
+		}
+	}
+	return nil
+}
+
+func (c *Chip) Name() string {
+	return c.typ.name
+}
+
+func (c *Chip) Inputs() []Pin {
+	return c.typ.inputs
+}
+
+func (c *Chip) Outputs() []Pin {
+	return c.typ.outputs
+}
+
+// Set assigns an input pin, e.g. "a", "sel" or a single bit like "a[3]".
+func (c *Chip) Set(name string, value int) error {
+	idx, lo, hi, err := c.lookup(name)
+	if err != nil {
+		return err
+	}
+	if !c.typ.isInput(idx) {
+		return fmt.Errorf("%s is not an input pin of %s", name, c.typ.name)
+	}
+	net := c.pins[idx]
+	c.write(net, setBits(c.values[net], lo, hi, uint16(value)))
+	return nil
+}
+
+// Get reads any pin of the chip, internal pins included.
+func (c *Chip) Get(name string) (int, error) {
+	idx, lo, hi, err := c.lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	v := getBits(c.values[c.pins[idx]], lo, hi)
+	if hi-lo == 15 {
+		// Full words are signed like in the hardware simulator
+		return int(int16(v)), nil
+	}
+	return int(v), nil
+}
+
+func (c *Chip) lookup(name string) (int, int, int, error) {
+	base := name
+	bitIdx := -1
+	if i := strings.Index(name, "["); i != -1 && strings.HasSuffix(name, "]") {
+		n, err := strconv.Atoi(name[i+1 : len(name)-1])
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("bad pin name %s", name)
+		}
+		base = name[:i]
+		bitIdx = n
+	}
+	idx, exist := c.typ.index[base]
+	if !exist {
+		return 0, 0, 0, fmt.Errorf("%s has no pin %s", c.typ.name, base)
+	}
+	width := c.typ.pins[idx].Width
+	if bitIdx == -1 {
+		return idx, 0, width - 1, nil
+	}
+	if bitIdx < 0 || bitIdx >= width {
+		return 0, 0, 0, fmt.Errorf("%s is out of range", name)
+	}
+	return idx, bitIdx, bitIdx, nil
+}
+
+// Memory returns the memory words of the first built-in part with the given
+// chip name, e.g. "RAM16K", "ROM32K" or "DRegister" (a single word). Changes
+// show on the outputs after the next Eval.
+func (c *Chip) Memory(chip string) ([]uint16, bool) {
+	for i := range c.instances {
+		inst := &c.instances[i]
+		if inst.typ.name == chip && inst.mem != nil {
+			c.touchMemory()
+			return inst.mem, true
+		}
+	}
+	return nil, false
+}
+
+// write sets a net and schedules the steps reading it.
+func (c *Chip) write(net int32, v uint16) {
+	if c.values[net] == v {
+		return
+	}
+	c.values[net] = v
+	for _, j := range c.readers[c.readerStart[net]:c.readerStart[net+1]] {
+		c.dirty[j] = true
+	}
+}
+
+func (c *Chip) touchMemory() {
+	for _, i := range c.clocked {
+		c.dirty[i] = true
+	}
+}
+
+// Eval recomputes all outputs and internal pins from the inputs and the
+// memory of the clocked parts.
+func (c *Chip) Eval() {
+	for i := range c.steps {
+		if !c.dirty[i] {
+			continue
+		}
+		c.dirty[i] = false
+		s := &c.steps[i]
+		if s.inst == -1 {
+			v := s.value
+			if s.from != -1 {
+				v = c.values[s.from]
+			}
+			lo, hi := int(s.toLo), int(s.toHi)
+			c.write(s.to, setBits(c.values[s.to], lo, hi, getBits(v, int(s.lo), int(s.hi))))
+			continue
+		}
+
+		inst := &c.instances[s.inst]
+		b := inst.typ.builtin
+		nIn := len(inst.typ.inputs)
+		in, out := c.in[:nIn], c.out[:len(inst.nets)-nIn]
+		for j := range in {
+			in[j] = c.values[inst.nets[j]]
+		}
+		if b.read != nil {
+			b.read(in, out, inst.mem, inst.latch)
+		} else {
+			b.eval(in, out)
+		}
+		for j, v := range out {
+			c.write(inst.nets[nIn+j], v)
+		}
+	}
+}
+
+// Tick is the rising clock edge: clocked parts sample their inputs but the
+// outputs do not change yet.
+func (c *Chip) Tick() {
+	c.Eval()
+	for _, i := range c.clocked {
+		inst := &c.instances[c.steps[i].inst]
+		b := inst.typ.builtin
+		if b.tick == nil {
+			continue
+		}
+		in := c.in[:len(inst.typ.inputs)]
+		for j := range in {
+			in[j] = c.values[inst.nets[j]]
+		}
+		b.tick(in, inst.mem)
+	}
+}
+
+// Tock is the falling clock edge: the sampled values are committed and
+// propagate to the outputs.
+func (c *Chip) Tock() {
+	for _, i := range c.clocked {
+		inst := &c.instances[c.steps[i].inst]
+		if inst.typ.builtin.tock != nil {
+			inst.typ.builtin.tock(inst.mem, inst.latch)
+		}
+	}
+	c.touchMemory()
+	c.Eval()
+}
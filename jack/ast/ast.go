@@ -0,0 +1,210 @@
+// Package ast declares the syntax tree of a Jack class.
+package ast
+
+import "fmt"
+
+// Pos is a position in a source file, Line and Column start at 1.
+type Pos struct {
+	Line   int
+	Column int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Ident is a name as written in the source: a class, subroutine, variable or
+// a type, which may also be one of the keywords int, char, boolean or void.
+type Ident struct {
+	Pos  Pos
+	Name string
+}
+
+// Class is the root of a .jack file, File names it in diagnostics.
+//
+// Doc fields hold the text of the /** */ comment before a declaration,
+// without the comment markers.
+type Class struct {
+	File        string
+	Pos         Pos
+	Doc         string
+	Name        Ident
+	Vars        []*ClassVarDec
+	Subroutines []*SubroutineDec
+	End         Pos
+}
+
+// ClassVarDec is "static int a, b;" or "field Point p;", Kind is static or field.
+type ClassVarDec struct {
+	Pos   Pos
+	Doc   string
+	Kind  string
+	Type  Ident
+	Names []Ident
+}
+
+// SubroutineDec is a constructor, function or method, Kind tells which.
+type SubroutineDec struct {
+	Pos        Pos
+	Doc        string
+	Kind       string
+	ReturnType Ident
+	Name       Ident
+	Params     []*Param
+	Locals     []*VarDec
+	Statements []Statement
+	End        Pos
+}
+
+type Param struct {
+	Type Ident
+	Name Ident
+}
+
+type VarDec struct {
+	Pos   Pos
+	Type  Ident
+	Names []Ident
+}
+
+// Block is a list of statements between braces.
+type Block struct {
+	Pos        Pos
+	Statements []Statement
+	End        Pos
+}
+
+type Statement interface {
+	Position() Pos
+	statement()
+}
+
+// LetStatement assigns Value to Name, or to Name[Index] when Index is set.
+type LetStatement struct {
+	Pos   Pos
+	Name  Ident
+	Index Expression
+	Value Expression
+}
+
+// IfStatement has a nil Else without an else part.
+type IfStatement struct {
+	Pos  Pos
+	Cond Expression
+	Then *Block
+	Else *Block
+}
+
+type WhileStatement struct {
+	Pos  Pos
+	Cond Expression
+	Body *Block
+}
+
+type DoStatement struct {
+	Pos  Pos
+	Call *CallExpr
+}
+
+// ReturnStatement has a nil Value in void subroutines.
+type ReturnStatement struct {
+	Pos   Pos
+	Value Expression
+}
+
+func (s *LetStatement) Position() Pos    { return s.Pos }
+func (s *IfStatement) Position() Pos     { return s.Pos }
+func (s *WhileStatement) Position() Pos  { return s.Pos }
+func (s *DoStatement) Position() Pos     { return s.Pos }
+func (s *ReturnStatement) Position() Pos { return s.Pos }
+
+func (*LetStatement) statement()    {}
+func (*IfStatement) statement()     {}
+func (*WhileStatement) statement()  {}
+func (*DoStatement) statement()     {}
+func (*ReturnStatement) statement() {}
+
+// Expression is a term or a BinaryExpr. Jack has no operator precedence, so
+// "a + b * c" is a left-leaning chain of BinaryExpr and only ParenExpr
+// groups differently.
+type Expression interface {
+	Position() Pos
+	expression()
+}
+
+type BinaryExpr struct {
+	OpPos Pos
+	Op    byte
+	X     Expression
+	Y     Expression
+}
+
+// UnaryExpr is "-x" or "~x".
+type UnaryExpr struct {
+	Pos Pos
+	Op  byte
+	X   Expression
+}
+
+type ParenExpr struct {
+	Pos Pos
+	X   Expression
+}
+
+type IntLiteral struct {
+	Pos   Pos
+	Value int
+}
+
+type StringLiteral struct {
+	Pos   Pos
+	Value string
+}
+
+// KeywordConst is true, false, null or this.
+type KeywordConst struct {
+	Pos   Pos
+	Value string
+}
+
+type VarExpr struct {
+	Name Ident
+}
+
+// IndexExpr is an array access "a[i]".
+type IndexExpr struct {
+	Name  Ident
+	Index Expression
+}
+
+// CallExpr is "f(x)", or "Receiver.f(x)" where Receiver is a class or a variable.
+type CallExpr struct {
+	Receiver *Ident
+	Name     Ident
+	Args     []Expression
+}
+
+func (x *BinaryExpr) Position() Pos    { return x.X.Position() }
+func (x *UnaryExpr) Position() Pos     { return x.Pos }
+func (x *ParenExpr) Position() Pos     { return x.Pos }
+func (x *IntLiteral) Position() Pos    { return x.Pos }
+func (x *StringLiteral) Position() Pos { return x.Pos }
+func (x *KeywordConst) Position() Pos  { return x.Pos }
+func (x *VarExpr) Position() Pos       { return x.Name.Pos }
+func (x *IndexExpr) Position() Pos     { return x.Name.Pos }
+func (x *CallExpr) Position() Pos {
+	if x.Receiver != nil {
+		return x.Receiver.Pos
+	}
+	return x.Name.Pos
+}
+
+func (*BinaryExpr) expression()    {}
+func (*UnaryExpr) expression()     {}
+func (*ParenExpr) expression()     {}
+func (*IntLiteral) expression()    {}
+func (*StringLiteral) expression() {}
+func (*KeywordConst) expression()  {}
+func (*VarExpr) expression()       {}
+func (*IndexExpr) expression()     {}
+func (*CallExpr) expression()      {}
@@ -0,0 +1,177 @@
+package ast
+
+import (
+	"io"
+	"strconv"
+	"strings"
+)
+
+const indent = "    "
+
+// Format prints the class as canonical Jack source: one declaration or
+// statement per line, four space indentation and spaces around binary
+// operators. Doc comments are kept, other comments are not part of the
+// tree and are not printed.
+func Format(w io.Writer, c *Class) error {
+	p := &printer{}
+	p.class(c)
+	_, err := io.WriteString(w, p.String())
+	return err
+}
+
+type printer struct {
+	strings.Builder
+	depth int
+}
+
+func (p *printer) line(parts ...string) {
+	if len(parts) > 0 {
+		p.WriteString(strings.Repeat(indent, p.depth))
+	}
+	for _, s := range parts {
+		p.WriteString(s)
+	}
+	p.WriteString("\n")
+}
+
+// doc prints a doc comment, on one line when it is short.
+func (p *printer) doc(text string) {
+	if text == "" {
+		return
+	}
+	lines := strings.Split(text, "\n")
+	if len(lines) == 1 {
+		p.line("/** ", text, " */")
+		return
+	}
+	p.line("/**")
+	for _, line := range lines {
+		if line == "" {
+			p.line(" *")
+		} else {
+			p.line(" * ", line)
+		}
+	}
+	p.line(" */")
+}
+
+func (p *printer) class(c *Class) {
+	p.doc(c.Doc)
+	p.line("class ", c.Name.Name, " {")
+	p.depth++
+	for _, d := range c.Vars {
+		p.doc(d.Doc)
+		p.line(d.Kind, " ", d.Type.Name, " ", names(d.Names), ";")
+	}
+	for i, s := range c.Subroutines {
+		if i > 0 || len(c.Vars) > 0 {
+			p.line()
+		}
+		p.subroutine(s)
+	}
+	p.depth--
+	p.line("}")
+}
+
+func (p *printer) subroutine(s *SubroutineDec) {
+	params := make([]string, len(s.Params))
+	for i, param := range s.Params {
+		params[i] = param.Type.Name + " " + param.Name.Name
+	}
+	p.doc(s.Doc)
+	p.line(s.Kind, " ", s.ReturnType.Name, " ", s.Name.Name, "(", strings.Join(params, ", "), ") {")
+	p.depth++
+	for _, d := range s.Locals {
+		p.line("var ", d.Type.Name, " ", names(d.Names), ";")
+	}
+	if len(s.Locals) > 0 && len(s.Statements) > 0 {
+		p.line()
+	}
+	p.statements(s.Statements)
+	p.depth--
+	p.line("}")
+}
+
+func names(ids []Ident) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = id.Name
+	}
+	return strings.Join(s, ", ")
+}
+
+func (p *printer) statements(statements []Statement) {
+	for _, s := range statements {
+		p.statement(s)
+	}
+}
+
+func (p *printer) block(b *Block) {
+	p.depth++
+	p.statements(b.Statements)
+	p.depth--
+}
+
+func (p *printer) statement(s Statement) {
+	switch s := s.(type) {
+	case *LetStatement:
+		target := s.Name.Name
+		if s.Index != nil {
+			target += "[" + Expr(s.Index) + "]"
+		}
+		p.line("let ", target, " = ", Expr(s.Value), ";")
+	case *IfStatement:
+		p.line("if (", Expr(s.Cond), ") {")
+		p.block(s.Then)
+		if s.Else != nil {
+			p.line("} else {")
+			p.block(s.Else)
+		}
+		p.line("}")
+	case *WhileStatement:
+		p.line("while (", Expr(s.Cond), ") {")
+		p.block(s.Body)
+		p.line("}")
+	case *DoStatement:
+		p.line("do ", Expr(s.Call), ";")
+	case *ReturnStatement:
+		if s.Value == nil {
+			p.line("return;")
+		} else {
+			p.line("return ", Expr(s.Value), ";")
+		}
+	}
+}
+
+// Expr returns the source of an expression.
+func Expr(x Expression) string {
+	switch x := x.(type) {
+	case *BinaryExpr:
+		return Expr(x.X) + " " + string(x.Op) + " " + Expr(x.Y)
+	case *UnaryExpr:
+		return string(x.Op) + Expr(x.X)
+	case *ParenExpr:
+		return "(" + Expr(x.X) + ")"
+	case *IntLiteral:
+		return strconv.Itoa(x.Value)
+	case *StringLiteral:
+		return "\"" + x.Value + "\""
+	case *KeywordConst:
+		return x.Value
+	case *VarExpr:
+		return x.Name.Name
+	case *IndexExpr:
+		return x.Name.Name + "[" + Expr(x.Index) + "]"
+	case *CallExpr:
+		args := make([]string, len(x.Args))
+		for i, arg := range x.Args {
+			args[i] = Expr(arg)
+		}
+		name := x.Name.Name
+		if x.Receiver != nil {
+			name = x.Receiver.Name + "." + name
+		}
+		return name + "(" + strings.Join(args, ", ") + ")"
+	}
+	return ""
+}
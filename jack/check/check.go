@@ -0,0 +1,331 @@
+// Package check finds semantic errors in the classes of a Jack program:
+// undeclared names, unknown subroutines, wrong argument counts, misplaced
+// returns and assignments between incompatible types.
+package check
+
+import (
+	"github.com/mingpepe/Nand2teris/diag"
+	"github.com/mingpepe/Nand2teris/jack/ast"
+	"github.com/mingpepe/Nand2teris/jack/index"
+)
+
+type variable struct {
+	typ  string
+	kind string
+}
+
+type checker struct {
+	index  *index.Index
+	errors diag.List
+
+	class  *ast.Class
+	fields map[string]variable
+	sub    *ast.SubroutineDec
+	locals map[string]variable
+}
+
+// Check reports the problems of the classes of a program, resolving names
+// with the index of the whole program. Misuse of types are warnings since
+// Jack freely mixes int, char and Array, everything else is an error.
+func Check(classes []*ast.Class, idx *index.Index) diag.List {
+	c := &checker{index: idx}
+	for _, class := range classes {
+		c.checkClass(class)
+	}
+	return c.errors
+}
+
+func (c *checker) errorf(pos ast.Pos, format string, args ...interface{}) {
+	c.errors = append(c.errors, diag.Errorf(c.class.File, pos.Line, pos.Column, format, args...))
+}
+
+func (c *checker) warningf(pos ast.Pos, format string, args ...interface{}) {
+	c.errors = append(c.errors, diag.Warningf(c.class.File, pos.Line, pos.Column, format, args...))
+}
+
+func (c *checker) checkClass(class *ast.Class) {
+	c.class = class
+	c.fields = make(map[string]variable)
+	for _, d := range class.Vars {
+		c.checkType(d.Type, false)
+		for _, name := range d.Names {
+			if _, exist := c.fields[name.Name]; exist {
+				c.errorf(name.Pos, "%s already declared", name.Name)
+			}
+			c.fields[name.Name] = variable{d.Type.Name, d.Kind}
+		}
+	}
+
+	subroutines := make(map[string]bool)
+	for _, s := range class.Subroutines {
+		if subroutines[s.Name.Name] {
+			c.errorf(s.Name.Pos, "subroutine %s already declared", s.Name.Name)
+		}
+		subroutines[s.Name.Name] = true
+		c.checkSubroutine(s)
+	}
+}
+
+// checkType reports unknown classes used as types.
+func (c *checker) checkType(t ast.Ident, void bool) {
+	switch t.Name {
+	case "int", "char", "boolean":
+		return
+	case "void":
+		if !void {
+			c.errorf(t.Pos, "undefined class %s", t.Name)
+		}
+		return
+	}
+	// a class of the program left out of the compilation is not an error
+	if c.index.Class(t.Name) == nil {
+		c.warningf(t.Pos, "undefined class %s", t.Name)
+	}
+}
+
+func (c *checker) checkSubroutine(s *ast.SubroutineDec) {
+	c.sub = s
+	c.locals = make(map[string]variable)
+	c.checkType(s.ReturnType, true)
+	if s.Kind == "constructor" && s.ReturnType.Name != c.class.Name.Name {
+		c.errorf(s.ReturnType.Pos, "constructor must return %s", c.class.Name.Name)
+	}
+	declare := func(t, name ast.Ident, kind string) {
+		c.checkType(t, false)
+		if _, exist := c.locals[name.Name]; exist {
+			c.errorf(name.Pos, "%s already declared", name.Name)
+		}
+		c.locals[name.Name] = variable{t.Name, kind}
+	}
+	for _, p := range s.Params {
+		declare(p.Type, p.Name, "argument")
+	}
+	for _, d := range s.Locals {
+		for _, name := range d.Names {
+			declare(d.Type, name, "var")
+		}
+	}
+
+	c.checkStatements(s.Statements)
+	if !terminates(s.Statements) {
+		c.errorf(s.End, "missing return at the end of %s", s.Name.Name)
+	}
+}
+
+// terminates tells whether the statements never fall through: they end with
+// a return, an if returning in both branches or a "while (true)" loop.
+func terminates(statements []ast.Statement) bool {
+	if len(statements) == 0 {
+		return false
+	}
+	switch s := statements[len(statements)-1].(type) {
+	case *ast.ReturnStatement:
+		return true
+	case *ast.IfStatement:
+		return s.Else != nil && terminates(s.Then.Statements) && terminates(s.Else.Statements)
+	case *ast.WhileStatement:
+		k, ok := s.Cond.(*ast.KeywordConst)
+		return ok && k.Value == "true"
+	}
+	return false
+}
+
+func (c *checker) checkStatements(statements []ast.Statement) {
+	for _, s := range statements {
+		switch s := s.(type) {
+		case *ast.LetStatement:
+			v, ok := c.variable(s.Name)
+			if !ok {
+				c.errorf(s.Name.Pos, "undefined variable %s", s.Name.Name)
+			}
+			if s.Index != nil {
+				c.expect(s.Index, "int", "array index")
+				c.typeOf(s.Value)
+			} else if ok {
+				c.expect(s.Value, v.typ, "assignment to "+s.Name.Name)
+			} else {
+				c.typeOf(s.Value)
+			}
+		case *ast.IfStatement:
+			c.typeOf(s.Cond)
+			c.checkStatements(s.Then.Statements)
+			if s.Else != nil {
+				c.checkStatements(s.Else.Statements)
+			}
+		case *ast.WhileStatement:
+			c.typeOf(s.Cond)
+			c.checkStatements(s.Body.Statements)
+		case *ast.DoStatement:
+			c.call(s.Call)
+		case *ast.ReturnStatement:
+			void := c.sub.ReturnType.Name == "void"
+			if s.Value == nil {
+				if !void {
+					c.errorf(s.Pos, "missing return value, %s returns %s", c.sub.Name.Name, c.sub.ReturnType.Name)
+				}
+			} else if void {
+				c.errorf(s.Value.Position(), "%s is void and cannot return a value", c.sub.Name.Name)
+			} else {
+				c.expect(s.Value, c.sub.ReturnType.Name, "return value")
+			}
+		}
+	}
+}
+
+// variable resolves a name in the subroutine, then in the class.
+func (c *checker) variable(name ast.Ident) (variable, bool) {
+	if v, exist := c.locals[name.Name]; exist {
+		return v, true
+	}
+	v, exist := c.fields[name.Name]
+	if !exist {
+		return v, false
+	}
+	if v.kind == "field" && c.sub.Kind == "function" {
+		c.errorf(name.Pos, "field %s cannot be used in a function", name.Name)
+	}
+	return v, true
+}
+
+// expect warns when x cannot be assigned to type t.
+func (c *checker) expect(x ast.Expression, t string, what string) {
+	typ := c.typeOf(x)
+	if !assignable(t, typ) {
+		c.warningf(x.Position(), "%s: cannot use %s as %s", what, typ, t)
+	}
+}
+
+// assignable is loose on purpose: int and char mix, Array is the pointer
+// type used for any object or address, and "" is an unknown type.
+func assignable(to, from string) bool {
+	if to == "" || from == "" || to == from || from == "null" {
+		return true
+	}
+	numeric := func(t string) bool {
+		return t == "int" || t == "char"
+	}
+	if numeric(to) && numeric(from) {
+		return true
+	}
+	if to == "Array" || from == "Array" {
+		return to != "boolean" && from != "boolean"
+	}
+	return false
+}
+
+// typeOf checks an expression and returns its type, "" when unknown.
+func (c *checker) typeOf(x ast.Expression) string {
+	switch x := x.(type) {
+	case *ast.IntLiteral:
+		return "int"
+	case *ast.StringLiteral:
+		return "String"
+	case *ast.KeywordConst:
+		switch x.Value {
+		case "true", "false":
+			return "boolean"
+		case "null":
+			return "null"
+		}
+		if c.sub.Kind == "function" {
+			c.errorf(x.Pos, "this cannot be used in a function")
+		}
+		return c.class.Name.Name
+	case *ast.VarExpr:
+		v, ok := c.variable(x.Name)
+		if !ok {
+			c.errorf(x.Name.Pos, "undefined variable %s", x.Name.Name)
+		}
+		return v.typ
+	case *ast.IndexExpr:
+		if _, ok := c.variable(x.Name); !ok {
+			c.errorf(x.Name.Pos, "undefined variable %s", x.Name.Name)
+		}
+		c.expect(x.Index, "int", "array index")
+		return ""
+	case *ast.CallExpr:
+		return c.call(x)
+	case *ast.ParenExpr:
+		return c.typeOf(x.X)
+	case *ast.UnaryExpr:
+		t := c.typeOf(x.X)
+		if x.Op == '~' && (t == "boolean" || t == "") {
+			return t
+		}
+		return "int"
+	case *ast.BinaryExpr:
+		tx, ty := c.typeOf(x.X), c.typeOf(x.Y)
+		switch x.Op {
+		case '<', '>', '=':
+			return "boolean"
+		case '&', '|':
+			if tx == "" || ty == "" {
+				return ""
+			}
+			if tx == "boolean" && ty == "boolean" {
+				return "boolean"
+			}
+		}
+		return "int"
+	}
+	return ""
+}
+
+// call checks a subroutine call and returns its type.
+func (c *checker) call(x *ast.CallExpr) string {
+	var class string
+	method := true
+	if x.Receiver == nil {
+		class = c.class.Name.Name
+		if c.sub.Kind == "function" {
+			if s := c.index.Subroutine(class, x.Name.Name); s != nil && s.Kind == "method" {
+				c.errorf(x.Name.Pos, "method %s cannot be called from a function", x.Name.Name)
+			}
+		}
+	} else if v, ok := c.variable(*x.Receiver); ok {
+		class = v.typ
+		switch class {
+		case "int", "char", "boolean":
+			c.errorf(x.Receiver.Pos, "%s is %s and has no methods", x.Receiver.Name, class)
+			class = ""
+		}
+	} else if c.index.Class(x.Receiver.Name) != nil {
+		class = x.Receiver.Name
+		method = false
+	} else {
+		c.warningf(x.Receiver.Pos, "undefined variable or class %s", x.Receiver.Name)
+	}
+
+	if c.index.Class(class) == nil {
+		for _, arg := range x.Args {
+			c.typeOf(arg)
+		}
+		return ""
+	}
+
+	s := c.index.Subroutine(class, x.Name.Name)
+	if s == nil {
+		c.errorf(x.Name.Pos, "undefined subroutine %s.%s", class, x.Name.Name)
+		for _, arg := range x.Args {
+			c.typeOf(arg)
+		}
+		return ""
+	}
+	// a function of the current class may be called without its class name
+	if method && x.Receiver != nil && s.Kind != "method" {
+		c.errorf(x.Name.Pos, "%s.%s is a %s, call it as %s.%s", class, s.Name, s.Kind, class, s.Name)
+	} else if !method && s.Kind == "method" {
+		c.errorf(x.Name.Pos, "%s.%s is a method and needs an object", class, s.Name)
+	}
+	if len(x.Args) != len(s.Params) {
+		c.errorf(x.Name.Pos, "%s.%s takes %d arguments, got %d", class, s.Name, len(s.Params), len(x.Args))
+	}
+	for i, arg := range x.Args {
+		if i < len(s.Params) {
+			c.expect(arg, s.Params[i].Type, "argument "+s.Params[i].Name+" of "+class+"."+s.Name)
+		} else {
+			c.typeOf(arg)
+		}
+	}
+	return s.ReturnType
+}
@@ -0,0 +1,150 @@
+// Package doc generates the API reference of Jack classes from their
+// declarations and doc comments, in Markdown or HTML.
+package doc
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/mingpepe/Nand2teris/jack/ast"
+)
+
+type page struct {
+	name     string
+	doc      string
+	sections []section
+}
+
+type section struct {
+	title string
+	items []item
+}
+
+type item struct {
+	signature string
+	doc       string
+}
+
+// pages lists the classes by name, their members in the order of the API
+// documents of the book: fields, statics, constructors, functions, methods.
+func pages(classes []*ast.Class) []page {
+	sorted := make([]*ast.Class, len(classes))
+	copy(sorted, classes)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Name.Name < sorted[j].Name.Name
+	})
+
+	result := make([]page, 0, len(sorted))
+	for _, c := range sorted {
+		p := page{name: c.Name.Name, doc: c.Doc}
+		vars := func(title, kind string) {
+			s := section{title: title}
+			for _, d := range c.Vars {
+				if d.Kind == kind {
+					s.items = append(s.items, item{fmt.Sprintf("%s %s %s", d.Kind, d.Type.Name, names(d.Names)), d.Doc})
+				}
+			}
+			if len(s.items) > 0 {
+				p.sections = append(p.sections, s)
+			}
+		}
+		subroutines := func(title, kind string) {
+			s := section{title: title}
+			for _, sub := range c.Subroutines {
+				if sub.Kind == kind {
+					s.items = append(s.items, item{signature(c, sub), sub.Doc})
+				}
+			}
+			if len(s.items) > 0 {
+				p.sections = append(p.sections, s)
+			}
+		}
+		vars("Fields", "field")
+		vars("Statics", "static")
+		subroutines("Constructors", "constructor")
+		subroutines("Functions", "function")
+		subroutines("Methods", "method")
+		result = append(result, p)
+	}
+	return result
+}
+
+func names(ids []ast.Ident) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = id.Name
+	}
+	return strings.Join(s, ", ")
+}
+
+// signature is written like "function int Math.multiply(int x, int y)".
+func signature(c *ast.Class, s *ast.SubroutineDec) string {
+	params := make([]string, len(s.Params))
+	for i, p := range s.Params {
+		params[i] = p.Type.Name + " " + p.Name.Name
+	}
+	return fmt.Sprintf("%s %s %s.%s(%s)", s.Kind, s.ReturnType.Name, c.Name.Name, s.Name.Name, strings.Join(params, ", "))
+}
+
+// Markdown writes one section per class.
+func Markdown(w io.Writer, classes []*ast.Class) error {
+	var b strings.Builder
+	b.WriteString("# API reference\n")
+	for _, p := range pages(classes) {
+		fmt.Fprintf(&b, "\n## %s\n", p.name)
+		if p.doc != "" {
+			fmt.Fprintf(&b, "\n%s\n", p.doc)
+		}
+		for _, s := range p.sections {
+			fmt.Fprintf(&b, "\n### %s\n", s.title)
+			for _, it := range s.items {
+				fmt.Fprintf(&b, "\n`%s`\n", it.signature)
+				if it.doc != "" {
+					fmt.Fprintf(&b, "\n%s\n", it.doc)
+				}
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// HTML writes a standalone page with a table of contents.
+func HTML(w io.Writer, classes []*ast.Class) error {
+	var b strings.Builder
+	all := pages(classes)
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>API reference</title>\n</head>\n<body>\n")
+	b.WriteString("<h1>API reference</h1>\n<ul>\n")
+	for _, p := range all {
+		fmt.Fprintf(&b, "<li><a href=\"#%s\">%s</a></li>\n", p.name, p.name)
+	}
+	b.WriteString("</ul>\n")
+	for _, p := range all {
+		fmt.Fprintf(&b, "<h2 id=\"%s\">%s</h2>\n", p.name, p.name)
+		if p.doc != "" {
+			fmt.Fprintf(&b, "%s\n", paragraphs(p.doc))
+		}
+		for _, s := range p.sections {
+			fmt.Fprintf(&b, "<h3>%s</h3>\n<dl>\n", s.title)
+			for _, it := range s.items {
+				fmt.Fprintf(&b, "<dt><code>%s</code></dt>\n", html.EscapeString(it.signature))
+				if it.doc != "" {
+					fmt.Fprintf(&b, "<dd>%s</dd>\n", paragraphs(it.doc))
+				}
+			}
+			b.WriteString("</dl>\n")
+		}
+	}
+	b.WriteString("</body>\n</html>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// paragraphs escapes a doc comment, blank lines separate paragraphs.
+func paragraphs(text string) string {
+	text = html.EscapeString(text)
+	return "<p>" + strings.Replace(text, "\n\n", "</p>\n<p>", -1) + "</p>"
+}
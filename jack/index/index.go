@@ -0,0 +1,110 @@
+// Package index is the table of every class of a Jack program and of their
+// subroutines, the OS included, shared by the checker and the code generator.
+package index
+
+import (
+	"github.com/mingpepe/Nand2teris/diag"
+	"github.com/mingpepe/Nand2teris/jack/ast"
+)
+
+type Param struct {
+	Type string
+	Name string
+}
+
+type Subroutine struct {
+	Class      string
+	Name       string
+	Kind       string // constructor, function or method
+	ReturnType string
+	Params     []Param
+	Decl       *ast.SubroutineDec // nil for the built-in OS API
+}
+
+type Class struct {
+	Name        string
+	OS          bool
+	Decl        *ast.Class // nil for the built-in OS API
+	Subroutines []*Subroutine
+}
+
+// Subroutine returns nil when the class has no subroutine called name.
+func (c *Class) Subroutine(name string) *Subroutine {
+	for _, s := range c.Subroutines {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+type Index struct {
+	classes map[string]*Class
+	names   []string
+}
+
+func New() *Index {
+	x := &Index{}
+	x.classes = make(map[string]*Class)
+	return x
+}
+
+// Add indexes a parsed class. A program may replace an OS class with its own,
+// as the tests of project 12 do, but declaring a class twice is an error.
+func (x *Index) Add(c *ast.Class, os bool) error {
+	name := c.Name.Name
+	if old, exist := x.classes[name]; exist {
+		if os {
+			return nil
+		}
+		if !old.OS {
+			return diag.Errorf(c.File, c.Name.Pos.Line, c.Name.Pos.Column, "class %s already declared in %s", name, old.Decl.File)
+		}
+	}
+	class := &Class{Name: name, OS: os, Decl: c}
+	for _, s := range c.Subroutines {
+		sub := &Subroutine{
+			Class:      name,
+			Name:       s.Name.Name,
+			Kind:       s.Kind,
+			ReturnType: s.ReturnType.Name,
+			Decl:       s,
+		}
+		for _, p := range s.Params {
+			sub.Params = append(sub.Params, Param{p.Type.Name, p.Name.Name})
+		}
+		class.Subroutines = append(class.Subroutines, sub)
+	}
+	x.add(class)
+	return nil
+}
+
+func (x *Index) add(c *Class) {
+	if _, exist := x.classes[c.Name]; !exist {
+		x.names = append(x.names, c.Name)
+	}
+	x.classes[c.Name] = c
+}
+
+// Class returns nil for an unknown class.
+func (x *Index) Class(name string) *Class {
+	return x.classes[name]
+}
+
+// Subroutine returns nil for an unknown class or subroutine.
+func (x *Index) Subroutine(class, name string) *Subroutine {
+	c := x.classes[class]
+	if c == nil {
+		return nil
+	}
+	return c.Subroutine(name)
+}
+
+// Classes returns the classes in the order they were added.
+func (x *Index) Classes() []*Class {
+	classes := make([]*Class, len(x.names))
+	for i, name := range x.names {
+		classes[i] = x.classes[name]
+	}
+	return classes
+}
@@ -0,0 +1,78 @@
+package index
+
+import "strings"
+
+// osAPI is the public API of the Jack OS, as declared in projects/12.
+const osAPI = `
+function void Math.init()
+function int Math.abs(int x)
+function int Math.multiply(int x, int y)
+function int Math.divide(int x, int y)
+function int Math.sqrt(int x)
+function int Math.max(int a, int b)
+function int Math.min(int a, int b)
+constructor String String.new(int maxLength)
+method void String.dispose()
+method int String.length()
+method char String.charAt(int j)
+method void String.setCharAt(int j, char c)
+method String String.appendChar(char c)
+method void String.eraseLastChar()
+method int String.intValue()
+method void String.setInt(int val)
+function char String.newLine()
+function char String.backSpace()
+function char String.doubleQuote()
+function Array Array.new(int size)
+method void Array.dispose()
+function void Output.init()
+function void Output.moveCursor(int i, int j)
+function void Output.printChar(char c)
+function void Output.printString(String s)
+function void Output.printInt(int i)
+function void Output.println()
+function void Output.backSpace()
+function void Screen.init()
+function void Screen.clearScreen()
+function void Screen.setColor(boolean b)
+function void Screen.drawPixel(int x, int y)
+function void Screen.drawLine(int x1, int y1, int x2, int y2)
+function void Screen.drawRectangle(int x1, int y1, int x2, int y2)
+function void Screen.drawCircle(int x, int y, int r)
+function void Keyboard.init()
+function char Keyboard.keyPressed()
+function char Keyboard.readChar()
+function String Keyboard.readLine(String message)
+function int Keyboard.readInt(String message)
+function void Memory.init()
+function int Memory.peek(int address)
+function void Memory.poke(int address, int value)
+function int Memory.alloc(int size)
+function void Memory.deAlloc(Array o)
+function void Sys.init()
+function void Sys.halt()
+function void Sys.wait(int duration)
+function void Sys.error(int errorCode)
+`
+
+// AddOS indexes the built-in OS API, for programs compiled without the
+// sources of the OS. Classes already in the index are kept.
+func (x *Index) AddOS() {
+	classes := make(map[string]*Class)
+	for _, line := range strings.Split(strings.TrimSpace(osAPI), "\n") {
+		fields := strings.Fields(strings.NewReplacer("(", " ", ")", " ", ",", " ", ".", " ").Replace(line))
+		s := &Subroutine{Kind: fields[0], ReturnType: fields[1], Class: fields[2], Name: fields[3]}
+		for i := 4; i+1 < len(fields); i += 2 {
+			s.Params = append(s.Params, Param{fields[i], fields[i+1]})
+		}
+		c := classes[s.Class]
+		if c == nil {
+			c = &Class{Name: s.Class, OS: true}
+			classes[s.Class] = c
+			if x.classes[s.Class] == nil {
+				x.add(c)
+			}
+		}
+		c.Subroutines = append(c.Subroutines, s)
+	}
+}
@@ -0,0 +1,191 @@
+package lsp
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/mingpepe/Nand2teris/compiler"
+	"github.com/mingpepe/Nand2teris/jack/ast"
+	"github.com/mingpepe/Nand2teris/jack/index"
+)
+
+// lookup resolves the identifier at a position of a document.
+func (s *Server) lookup(params TextDocumentPositionParams) (ast.Ident, *target) {
+	path := uriToPath(params.TextDocument.URI)
+	w := s.load(path)
+	class := w.classes[path]
+	if class == nil {
+		return ast.Ident{}, nil
+	}
+	return resolve(w, class, params.Position.Line+1, params.Position.Character+1)
+}
+
+// definition returns a Location, or nil when there is nothing to go to.
+func (s *Server) definition(params TextDocumentPositionParams) interface{} {
+	_, t := s.lookup(params)
+	if t == nil || t.file == "" {
+		return nil
+	}
+	return Location{URI: pathToURI(t.file), Range: identRange(t.pos, t.name)}
+}
+
+func (s *Server) hover(params TextDocumentPositionParams) interface{} {
+	id, t := s.lookup(params)
+	if t == nil {
+		return nil
+	}
+	r := identRange(id.Pos, id.Name)
+	return Hover{Contents: MarkupContent{Kind: "markdown", Value: t.hover}, Range: &r}
+}
+
+func identRange(pos ast.Pos, name string) Range {
+	start := Position{pos.Line - 1, pos.Column - 1}
+	return Range{start, Position{start.Line, start.Character + len(name)}}
+}
+
+// span goes from start to the closing brace at end, which is unknown after
+// a syntax error.
+func span(start, end ast.Pos) Range {
+	if end.Line == 0 {
+		end = start
+	}
+	return Range{identRange(start, "").Start, identRange(end, "}").End}
+}
+
+func (s *Server) documentSymbols(params DocumentSymbolParams) []DocumentSymbol {
+	path := uriToPath(params.TextDocument.URI)
+	w := s.load(path)
+	class := w.classes[path]
+	symbols := make([]DocumentSymbol, 0)
+	if class == nil || class.Name.Name == "" {
+		return symbols
+	}
+
+	root := DocumentSymbol{
+		Name:           class.Name.Name,
+		Kind:           SymbolClass,
+		Range:          span(class.Pos, class.End),
+		SelectionRange: identRange(class.Name.Pos, class.Name.Name),
+	}
+	for _, d := range class.Vars {
+		for _, name := range d.Names {
+			r := identRange(name.Pos, name.Name)
+			root.Children = append(root.Children, DocumentSymbol{
+				Name:           name.Name,
+				Detail:         d.Kind + " " + d.Type.Name,
+				Kind:           SymbolField,
+				Range:          r,
+				SelectionRange: r,
+			})
+		}
+	}
+	kinds := map[string]int{
+		compiler.CONSTRUCTOR: SymbolConstructor,
+		compiler.FUNCTION:    SymbolFunction,
+		compiler.METHOD:      SymbolMethod,
+	}
+	for _, sub := range class.Subroutines {
+		root.Children = append(root.Children, DocumentSymbol{
+			Name:           sub.Name.Name,
+			Detail:         sub.Kind + " " + sub.ReturnType.Name,
+			Kind:           kinds[sub.Kind],
+			Range:          span(sub.Pos, sub.End),
+			SelectionRange: identRange(sub.Name.Pos, sub.Name.Name),
+		})
+	}
+	return append(symbols, root)
+}
+
+var memberPrefix = regexp.MustCompile(`([A-Za-z_][A-Za-z0-9_]*)\.[A-Za-z0-9_]*$`)
+
+// completion lists the subroutines after "Name.": the methods of the class
+// of a variable, or the constructors and functions of a class.
+func (s *Server) completion(params TextDocumentPositionParams) CompletionList {
+	list := CompletionList{Items: make([]CompletionItem, 0)}
+	path := uriToPath(params.TextDocument.URI)
+	w := s.load(path)
+	lines := strings.Split(w.sources[path], "\n")
+	line := params.Position.Line
+	if line >= len(lines) {
+		return list
+	}
+	text := lines[line]
+	if params.Position.Character < len(text) {
+		text = text[:params.Position.Character]
+	}
+	m := memberPrefix.FindStringSubmatch(text)
+	if m == nil {
+		return list
+	}
+
+	name := m[1]
+	methods := false
+	class := w.index.Class(name)
+	if typ := variableType(w.classes[path], line+1, name); typ != "" {
+		class = w.index.Class(typ)
+		methods = true
+	}
+	if class == nil {
+		return list
+	}
+	kinds := map[string]int{
+		compiler.CONSTRUCTOR: CompletionConstructor,
+		compiler.FUNCTION:    CompletionFunction,
+		compiler.METHOD:      CompletionMethod,
+	}
+	for _, sub := range class.Subroutines {
+		if (sub.Kind == compiler.METHOD) != methods {
+			continue
+		}
+		item := CompletionItem{Label: sub.Name, Kind: kinds[sub.Kind], Detail: signature(sub)}
+		if doc := subroutineDoc(sub); doc != "" {
+			item.Documentation = &MarkupContent{Kind: "markdown", Value: doc}
+		}
+		list.Items = append(list.Items, item)
+	}
+	return list
+}
+
+func subroutineDoc(s *index.Subroutine) string {
+	if s.Decl == nil {
+		return ""
+	}
+	return s.Decl.Doc
+}
+
+// variableType returns the type of a variable visible at a line, "" when
+// there is no such variable. The subroutine of the line is the last one
+// starting before it, so unfinished code still works.
+func variableType(class *ast.Class, line int, name string) string {
+	if class == nil {
+		return ""
+	}
+	var sub *ast.SubroutineDec
+	for _, s := range class.Subroutines {
+		if s.Pos.Line <= line {
+			sub = s
+		}
+	}
+	if sub != nil {
+		for _, p := range sub.Params {
+			if p.Name.Name == name {
+				return p.Type.Name
+			}
+		}
+		for _, d := range sub.Locals {
+			for _, n := range d.Names {
+				if n.Name == name {
+					return d.Type.Name
+				}
+			}
+		}
+	}
+	for _, d := range class.Vars {
+		for _, n := range d.Names {
+			if n.Name == name {
+				return d.Type.Name
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,247 @@
+package lsp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mingpepe/Nand2teris/compiler"
+	"github.com/mingpepe/Nand2teris/jack/ast"
+	"github.com/mingpepe/Nand2teris/jack/index"
+)
+
+// target is the declaration an identifier refers to. File is empty for the
+// built-in OS API, which has no source.
+type target struct {
+	file  string
+	pos   ast.Pos
+	name  string
+	hover string
+}
+
+// resolver finds the identifier at a 1-based line and column of a class and
+// what it refers to. Variables are looked up in a SymbolTable filled the
+// way the code generator fills it.
+type resolver struct {
+	index  *index.Index
+	class  *ast.Class
+	line   int
+	column int
+
+	table  *compiler.SymbolTable
+	decls  map[string]ast.Ident
+	locals map[string]ast.Ident
+
+	at    ast.Ident
+	found *target
+}
+
+func resolve(w *workspace, class *ast.Class, line, column int) (ast.Ident, *target) {
+	r := &resolver{index: w.index, class: class, line: line, column: column}
+	r.table = compiler.NewSymbolTable()
+	r.decls = make(map[string]ast.Ident)
+	r.locals = make(map[string]ast.Ident)
+
+	r.classRef(class.Name)
+	for _, d := range class.Vars {
+		kind := compiler.SYMBOL_FIELD
+		if d.Kind == compiler.STATIC {
+			kind = compiler.SYMBOL_STATIC
+		}
+		for _, name := range d.Names {
+			r.table.Define(name.Name, d.Type.Name, kind)
+			r.decls[name.Name] = name
+		}
+	}
+	for _, d := range class.Vars {
+		r.typeRef(d.Type)
+		for _, name := range d.Names {
+			r.varRef(name)
+		}
+	}
+	for _, s := range class.Subroutines {
+		r.subroutine(s)
+	}
+	return r.at, r.found
+}
+
+func (r *resolver) hit(id ast.Ident) bool {
+	if r.found != nil || id.Name == "" || id.Pos.Line != r.line {
+		return false
+	}
+	return id.Pos.Column <= r.column && r.column <= id.Pos.Column+len(id.Name)
+}
+
+func (r *resolver) set(id ast.Ident, t *target) {
+	if t != nil {
+		r.at = id
+		r.found = t
+	}
+}
+
+func (r *resolver) classRef(id ast.Ident) {
+	if r.hit(id) {
+		r.set(id, classTarget(r.index.Class(id.Name)))
+	}
+}
+
+func (r *resolver) typeRef(id ast.Ident) {
+	switch id.Name {
+	case compiler.INT, compiler.CHAR, compiler.BOOLEAN, compiler.VOID:
+		return
+	}
+	r.classRef(id)
+}
+
+func (r *resolver) varRef(id ast.Ident) {
+	if !r.hit(id) {
+		return
+	}
+	kind := r.table.KindOf(id.Name)
+	if kind == compiler.SYMBOL_NONE {
+		return
+	}
+	decl, local := r.locals[id.Name]
+	if !local {
+		decl = r.decls[id.Name]
+	}
+	hover := fmt.Sprintf("```jack\n%s %s %s\n```\nindex %d", kind, r.table.TypeOf(id.Name), id.Name, r.table.IndexOf(id.Name))
+	r.set(id, &target{file: r.class.File, pos: decl.Pos, name: id.Name, hover: hover})
+}
+
+func (r *resolver) subRef(class string, id ast.Ident) {
+	if r.hit(id) {
+		r.set(id, subroutineTarget(r.index.Class(class), id.Name))
+	}
+}
+
+func (r *resolver) subroutine(s *ast.SubroutineDec) {
+	r.table.StartSubroutine()
+	r.locals = make(map[string]ast.Ident)
+	for _, p := range s.Params {
+		r.table.Define(p.Name.Name, p.Type.Name, compiler.SYMBOL_ARG)
+		r.locals[p.Name.Name] = p.Name
+	}
+	for _, d := range s.Locals {
+		for _, name := range d.Names {
+			r.table.Define(name.Name, d.Type.Name, compiler.SYMBOL_VAR)
+			r.locals[name.Name] = name
+		}
+	}
+
+	r.typeRef(s.ReturnType)
+	r.subRef(r.class.Name.Name, s.Name)
+	for _, p := range s.Params {
+		r.typeRef(p.Type)
+		r.varRef(p.Name)
+	}
+	for _, d := range s.Locals {
+		r.typeRef(d.Type)
+		for _, name := range d.Names {
+			r.varRef(name)
+		}
+	}
+	r.statements(s.Statements)
+}
+
+func (r *resolver) statements(statements []ast.Statement) {
+	for _, s := range statements {
+		switch s := s.(type) {
+		case *ast.LetStatement:
+			r.varRef(s.Name)
+			r.expression(s.Index)
+			r.expression(s.Value)
+		case *ast.IfStatement:
+			r.expression(s.Cond)
+			r.statements(s.Then.Statements)
+			if s.Else != nil {
+				r.statements(s.Else.Statements)
+			}
+		case *ast.WhileStatement:
+			r.expression(s.Cond)
+			r.statements(s.Body.Statements)
+		case *ast.DoStatement:
+			r.call(s.Call)
+		case *ast.ReturnStatement:
+			r.expression(s.Value)
+		}
+	}
+}
+
+func (r *resolver) expression(x ast.Expression) {
+	switch x := x.(type) {
+	case *ast.BinaryExpr:
+		r.expression(x.X)
+		r.expression(x.Y)
+	case *ast.UnaryExpr:
+		r.expression(x.X)
+	case *ast.ParenExpr:
+		r.expression(x.X)
+	case *ast.VarExpr:
+		r.varRef(x.Name)
+	case *ast.IndexExpr:
+		r.varRef(x.Name)
+		r.expression(x.Index)
+	case *ast.CallExpr:
+		r.call(x)
+	}
+}
+
+func (r *resolver) call(x *ast.CallExpr) {
+	class := r.class.Name.Name
+	if x.Receiver != nil {
+		if r.table.KindOf(x.Receiver.Name) != compiler.SYMBOL_NONE {
+			r.varRef(*x.Receiver)
+			class = r.table.TypeOf(x.Receiver.Name)
+		} else {
+			r.classRef(*x.Receiver)
+			class = x.Receiver.Name
+		}
+	}
+	r.subRef(class, x.Name)
+	for _, arg := range x.Args {
+		r.expression(arg)
+	}
+}
+
+func classTarget(c *index.Class) *target {
+	if c == nil {
+		return nil
+	}
+	t := &target{name: c.Name, hover: "```jack\nclass " + c.Name + "\n```"}
+	if c.Decl != nil {
+		t.file = c.Decl.File
+		t.pos = c.Decl.Name.Pos
+		if c.Decl.Doc != "" {
+			t.hover += "\n" + c.Decl.Doc
+		}
+	}
+	return t
+}
+
+func subroutineTarget(c *index.Class, name string) *target {
+	if c == nil {
+		return nil
+	}
+	s := c.Subroutine(name)
+	if s == nil {
+		return nil
+	}
+	t := &target{name: name, hover: "```jack\n" + signature(s) + "\n```"}
+	if s.Decl != nil {
+		t.file = c.Decl.File
+		t.pos = s.Decl.Name.Pos
+		if s.Decl.Doc != "" {
+			t.hover += "\n" + s.Decl.Doc
+		}
+	}
+	return t
+}
+
+// signature is written like "function int Math.multiply(int x, int y)".
+func signature(s *index.Subroutine) string {
+	params := make([]string, len(s.Params))
+	for i, p := range s.Params {
+		params[i] = p.Type + " " + p.Name
+	}
+	return fmt.Sprintf("%s %s %s.%s(%s)", s.Kind, s.ReturnType, s.Class, s.Name, strings.Join(params, ", "))
+}
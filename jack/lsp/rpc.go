@@ -0,0 +1,120 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Conn reads and writes JSON-RPC messages framed by a Content-Length
+// header, as both ends of the protocol do.
+type Conn struct {
+	reader *bufio.Reader
+	writer io.Writer
+	mu     sync.Mutex
+}
+
+func NewConn(reader io.Reader, writer io.Writer) *Conn {
+	c := &Conn{}
+	c.reader = bufio.NewReader(reader)
+	c.writer = writer
+	return c
+}
+
+// ParseError is a message whose body is not valid JSON. The framing is
+// intact, so the next message can still be read.
+type ParseError struct {
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("invalid message: %v", e.Err)
+}
+
+// Read returns the next message, io.EOF when the other end is gone.
+func (c *Conn) Read() (*Message, error) {
+	length := -1
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid header %q", line)
+		}
+		if strings.EqualFold(strings.TrimSpace(line[:i]), "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(line[i+1:]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid header %q", line)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("missing Content-Length header")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		return nil, err
+	}
+	msg := &Message{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		return nil, &ParseError{err}
+	}
+	return msg, nil
+}
+
+// Write sends a message, it is safe to call from several goroutines.
+func (c *Conn) Write(msg *Message) error {
+	msg.JSONRPC = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := fmt.Fprintf(c.writer, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = c.writer.Write(body)
+	return err
+}
+
+// Call sends a request, Notify a notification. The result of a call comes
+// back as a message with the same ID.
+func (c *Conn) Call(id int, method string, params interface{}) error {
+	return c.send(json.RawMessage(strconv.Itoa(id)), method, params)
+}
+
+func (c *Conn) Notify(method string, params interface{}) error {
+	return c.send(nil, method, params)
+}
+
+func (c *Conn) send(id json.RawMessage, method string, params interface{}) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return c.Write(&Message{ID: id, Method: method, Params: raw})
+}
+
+// Reply answers the request with ID id.
+func (c *Conn) Reply(id json.RawMessage, result interface{}) error {
+	raw, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return c.Write(&Message{ID: id, Result: raw})
+}
+
+func (c *Conn) ReplyError(id json.RawMessage, code int, message string) error {
+	return c.Write(&Message{ID: id, Error: &ResponseError{code, message}})
+}
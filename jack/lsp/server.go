@@ -0,0 +1,331 @@
+// Package lsp is a language server for Jack. It publishes the syntax and
+// semantic errors of a program when a file is opened or saved, and answers
+// go to definition, hover, document symbols and completion after "Name.".
+//
+// A program is the directory of the file being edited, the open documents
+// replacing their content on disk.
+package lsp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mingpepe/Nand2teris/compiler"
+	"github.com/mingpepe/Nand2teris/diag"
+	"github.com/mingpepe/Nand2teris/jack/ast"
+	"github.com/mingpepe/Nand2teris/jack/check"
+	"github.com/mingpepe/Nand2teris/jack/index"
+)
+
+type Server struct {
+	conn      *Conn
+	docs      map[string]string
+	osClasses []*ast.Class
+	shutdown  bool
+}
+
+func NewServer(reader io.Reader, writer io.Writer) *Server {
+	s := &Server{}
+	s.conn = NewConn(reader, writer)
+	s.docs = make(map[string]string)
+	return s
+}
+
+// LoadOS reads the OS API from the .jack files of dir, such as projects/12,
+// so definitions of OS subroutines can be found. The built-in manifest of
+// the OS API is used otherwise.
+func (s *Server) LoadOS(dir string) error {
+	filenames, err := filepath.Glob(filepath.Join(dir, "*.jack"))
+	if err != nil {
+		return err
+	}
+	for _, filename := range filenames {
+		path, err := filepath.Abs(filename)
+		if err != nil {
+			return err
+		}
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		class, err := compiler.Parse(strings.NewReader(string(src)), path)
+		if err != nil {
+			return err
+		}
+		s.osClasses = append(s.osClasses, class)
+	}
+	return nil
+}
+
+var errExit = errors.New("exit")
+
+// ErrNoShutdown is returned by Serve when the client asks to exit without
+// asking to shut down first, the server then exits with status 1.
+var ErrNoShutdown = errors.New("exit without shutdown")
+
+// Serve answers the client until it asks to exit or closes the connection.
+func (s *Server) Serve() error {
+	for {
+		msg, err := s.conn.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if parseErr, ok := err.(*ParseError); ok {
+			// the id of the request is unknown, JSON-RPC answers with null
+			log.Print(parseErr)
+			s.conn.ReplyError(json.RawMessage("null"), codeParseError, parseErr.Error())
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.handle(msg); err == errExit {
+			return nil
+		} else if err == ErrNoShutdown {
+			return err
+		} else if err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+func (s *Server) handle(msg *Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s: %v", msg.Method, r)
+			if msg.ID != nil {
+				s.conn.ReplyError(msg.ID, codeInternalError, err.Error())
+			}
+		}
+	}()
+
+	// after shutdown only exit is answered
+	if s.shutdown && msg.Method != "exit" {
+		if msg.ID != nil {
+			return s.conn.ReplyError(msg.ID, codeInvalidRequest, "server is shut down")
+		}
+		return nil
+	}
+
+	switch msg.Method {
+	case "initialize":
+		result := InitializeResult{}
+		result.ServerInfo.Name = "jack_lsp"
+		result.Capabilities = map[string]interface{}{
+			"textDocumentSync": map[string]interface{}{
+				"openClose": true,
+				"change":    1,
+				"save":      map[string]bool{"includeText": true},
+			},
+			"definitionProvider":     true,
+			"hoverProvider":          true,
+			"documentSymbolProvider": true,
+			"completionProvider":     map[string]interface{}{"triggerCharacters": []string{"."}},
+		}
+		return s.conn.Reply(msg.ID, result)
+	case "initialized":
+		return nil
+	case "shutdown":
+		s.shutdown = true
+		return s.conn.Reply(msg.ID, nil)
+	case "exit":
+		if !s.shutdown {
+			return ErrNoShutdown
+		}
+		return errExit
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return err
+		}
+		path := uriToPath(params.TextDocument.URI)
+		s.docs[path] = params.TextDocument.Text
+		return s.publishDiagnostics(path)
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return err
+		}
+		if n := len(params.ContentChanges); n > 0 {
+			s.docs[uriToPath(params.TextDocument.URI)] = params.ContentChanges[n-1].Text
+		}
+		return nil
+	case "textDocument/didSave":
+		var params DidSaveTextDocumentParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return err
+		}
+		path := uriToPath(params.TextDocument.URI)
+		if params.Text != nil {
+			s.docs[path] = *params.Text
+		}
+		return s.publishDiagnostics(path)
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return err
+		}
+		delete(s.docs, uriToPath(params.TextDocument.URI))
+		return nil
+	case "textDocument/definition":
+		var params TextDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return s.conn.ReplyError(msg.ID, codeInvalidParams, err.Error())
+		}
+		return s.conn.Reply(msg.ID, s.definition(params))
+	case "textDocument/hover":
+		var params TextDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return s.conn.ReplyError(msg.ID, codeInvalidParams, err.Error())
+		}
+		return s.conn.Reply(msg.ID, s.hover(params))
+	case "textDocument/documentSymbol":
+		var params DocumentSymbolParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return s.conn.ReplyError(msg.ID, codeInvalidParams, err.Error())
+		}
+		return s.conn.Reply(msg.ID, s.documentSymbols(params))
+	case "textDocument/completion":
+		var params TextDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return s.conn.ReplyError(msg.ID, codeInvalidParams, err.Error())
+		}
+		return s.conn.Reply(msg.ID, s.completion(params))
+	}
+	if msg.ID != nil {
+		return s.conn.ReplyError(msg.ID, codeMethodNotFound, "method not supported: "+msg.Method)
+	}
+	return nil
+}
+
+// workspace is the program of a document.
+type workspace struct {
+	files   []string
+	sources map[string]string
+	classes map[string]*ast.Class
+	errors  map[string]diag.List
+	index   *index.Index
+}
+
+func (s *Server) load(path string) *workspace {
+	w := &workspace{}
+	w.sources = make(map[string]string)
+	w.classes = make(map[string]*ast.Class)
+	w.errors = make(map[string]diag.List)
+	w.index = index.New()
+
+	w.files, _ = filepath.Glob(filepath.Join(filepath.Dir(path), "*.jack"))
+	if _, open := s.docs[path]; open && !contains(w.files, path) {
+		w.files = append(w.files, path)
+		sort.Strings(w.files)
+	}
+	for _, file := range w.files {
+		src, open := s.docs[file]
+		if !open {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				continue
+			}
+			src = string(data)
+		}
+		w.sources[file] = src
+		class, err := compiler.Parse(strings.NewReader(src), file)
+		if list, ok := err.(diag.List); ok {
+			w.errors[file] = list
+		}
+		w.classes[file] = class
+		if class.Name.Name != "" {
+			w.index.Add(class, false)
+		}
+	}
+	for _, class := range s.osClasses {
+		w.index.Add(class, true)
+	}
+	w.index.AddOS()
+	return w
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// publishDiagnostics sends the errors of every file of the program of path,
+// classes with syntax errors are not checked further.
+func (s *Server) publishDiagnostics(path string) error {
+	w := s.load(path)
+	valid := make([]*ast.Class, 0)
+	for _, file := range w.files {
+		if len(w.errors[file]) == 0 && w.classes[file] != nil {
+			valid = append(valid, w.classes[file])
+		}
+	}
+	byFile := make(map[string][]*diag.Diagnostic)
+	for _, file := range w.files {
+		byFile[file] = w.errors[file]
+	}
+	for _, d := range check.Check(valid, w.index) {
+		byFile[d.File] = append(byFile[d.File], d)
+	}
+
+	for _, file := range w.files {
+		diagnostics := make([]Diagnostic, 0)
+		for _, d := range byFile[file] {
+			severity := SeverityError
+			if d.Severity == diag.Warning {
+				severity = SeverityWarning
+			}
+			diagnostics = append(diagnostics, Diagnostic{
+				Range:    wordRange(w.sources[file], d.Line, d.Column),
+				Severity: severity,
+				Source:   "jack",
+				Message:  d.Message,
+			})
+		}
+		params := PublishDiagnosticsParams{URI: pathToURI(file), Diagnostics: diagnostics}
+		if err := s.conn.Notify("textDocument/publishDiagnostics", params); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wordRange covers the word starting at a 1-based line and column, or a
+// single character when there is no word.
+func wordRange(src string, line, column int) Range {
+	if line < 1 {
+		line = 1
+	}
+	if column < 1 {
+		column = 1
+	}
+	start := Position{line - 1, column - 1}
+	end := Position{line - 1, column}
+	lines := strings.Split(src, "\n")
+	if line <= len(lines) {
+		text := lines[line-1]
+		i := column - 1
+		for i < len(text) && isWordChar(text[i]) {
+			i++
+		}
+		if i > column-1 {
+			end.Character = i
+		}
+	}
+	return Range{start, end}
+}
+
+func isWordChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_'
+}
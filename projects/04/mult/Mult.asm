@@ -9,4 +9,46 @@
 // This program only needs to handle arguments that satisfy
 // R0 >= 0, R1 >= 0, and R0*R1 < 32768.
 
-// Put your code here.
\ No newline at end of file
+// Shift and add, one pass per bit of R1 that is set, so negative
+// operands work too.
+   @R2
+   M=0
+   @R0
+   D=M
+   @x
+   M=D              // x = R0 shifted to the bit of R1 at hand
+   @R1
+   D=M
+   @rest
+   M=D              // rest = bits of R1 not added yet
+   @bit
+   M=1
+(LOOP)
+   @rest
+   D=M
+   @END
+   D;JEQ            // no bits left
+   @bit
+   D=D&M
+   @NEXT
+   D;JEQ            // bit not set
+   @x
+   D=M
+   @R2
+   M=D+M            // R2 = R2 + x
+   @bit
+   D=M
+   @rest
+   M=M-D            // clear the bit
+(NEXT)
+   @x
+   D=M
+   M=D+M            // x = x * 2
+   @bit
+   D=M
+   M=D+M            // bit = bit * 2
+   @LOOP
+   0;JMP
+(END)
+   @END
+   0;JMP
\ No newline at end of file
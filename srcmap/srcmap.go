@@ -0,0 +1,177 @@
+package srcmap
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ROM is the file name of the map of machine code, whose keys are ROM
+// addresses instead of lines.
+const ROM = "ROM"
+
+// Location is a line of a source file and the function it belongs to. Line
+// starts at 1, Function may be empty.
+type Location struct {
+	File     string
+	Line     int
+	Function string
+}
+
+// String formats the location like "Main.jack:42 (Main.main)".
+func (l Location) String() string {
+	s := fmt.Sprintf("%s:%d", l.File, l.Line)
+	if l.Function != "" {
+		s += " (" + l.Function + ")"
+	}
+	return s
+}
+
+// Map tells where the lines of a generated file come from, such as the .vm
+// file of a class or the .asm file of a program.
+type Map struct {
+	File    string
+	entries map[int]Location
+}
+
+func New(file string) *Map {
+	return &Map{File: file, entries: make(map[int]Location)}
+}
+
+func (m *Map) Set(line int, loc Location) {
+	m.entries[line] = loc
+}
+
+func (m *Map) Lookup(line int) (Location, bool) {
+	loc, ok := m.entries[line]
+	return loc, ok
+}
+
+// Len returns the number of mapped lines.
+func (m *Map) Len() int {
+	return len(m.entries)
+}
+
+// Write saves the map, the file it describes first, then one line per
+// mapped line:
+//
+//	file Main.vm
+//	12 "Main.jack" 7 Main.main
+func (m *Map) Write(writer io.Writer) error {
+	w := bufio.NewWriter(writer)
+	fmt.Fprintf(w, "file %s\n", strconv.Quote(m.File))
+	lines := make([]int, 0, len(m.entries))
+	for line := range m.entries {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+	for _, line := range lines {
+		loc := m.entries[line]
+		fmt.Fprintf(w, "%d %s %d", line, strconv.Quote(loc.File), loc.Line)
+		if loc.Function != "" {
+			fmt.Fprintf(w, " %s", loc.Function)
+		}
+		w.WriteString("\n")
+	}
+	return w.Flush()
+}
+
+// Read reads a map saved by Write.
+func Read(reader io.Reader) (*Map, error) {
+	m := New("")
+	scanner := bufio.NewScanner(reader)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "file ") {
+			file, err := strconv.Unquote(strings.TrimSpace(text[len("file "):]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNo, err)
+			}
+			m.File = file
+			continue
+		}
+		var line int
+		var loc Location
+		n, err := fmt.Sscanf(text, "%d %q %d %s", &line, &loc.File, &loc.Line, &loc.Function)
+		if n < 3 || (n == 3 && err != io.EOF && err != io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("line %d: unexpected entry %q", lineNo, text)
+		}
+		m.Set(line, loc)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Load reads the map saved in a file.
+func Load(filename string) (*Map, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Maps composes the maps of the stages of a build, Jack to VM, VM to
+// assembly and assembly to ROM, by the files they describe. Files are
+// matched by base name, the directories the tools were run from may differ.
+type Maps struct {
+	maps map[string]*Map
+}
+
+func NewMaps() *Maps {
+	return &Maps{maps: make(map[string]*Map)}
+}
+
+func (ms *Maps) Add(m *Map) {
+	ms.maps[base(m.File)] = m
+}
+
+// Resolve follows a line of a file back through the maps, the location in
+// the file generated last comes first and the original source last. For
+// machine code, file is ROM and line the address.
+func (ms *Maps) Resolve(file string, line int) []Location {
+	chain := make([]Location, 0)
+	for i := 0; i < len(ms.maps); i++ {
+		m := ms.maps[base(file)]
+		if m == nil {
+			break
+		}
+		loc, ok := m.Lookup(line)
+		if !ok {
+			break
+		}
+		chain = append(chain, loc)
+		file, line = loc.File, loc.Line
+	}
+	return chain
+}
+
+// Source returns the original source of a line, the end of Resolve.
+func (ms *Maps) Source(file string, line int) (Location, bool) {
+	chain := ms.Resolve(file, line)
+	if len(chain) == 0 {
+		return Location{}, false
+	}
+	loc := chain[len(chain)-1]
+	// the function is known from the VM code on when the source is assembly
+	for i := len(chain) - 1; loc.Function == "" && i >= 0; i-- {
+		loc.Function = chain[i].Function
+	}
+	return loc, true
+}
+
+func base(name string) string {
+	return name[strings.LastIndexAny(name, "/\\")+1:]
+}
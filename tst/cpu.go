@@ -0,0 +1,107 @@
+package tst
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mingpepe/Nand2teris/assembler"
+	"github.com/mingpepe/Nand2teris/cpu"
+)
+
+// CPUSimulator runs CPUEmulator scripts against an in-process Hack CPU.
+type CPUSimulator struct {
+	CPU *cpu.CPU
+}
+
+func NewCPUSimulator() *CPUSimulator {
+	return &CPUSimulator{CPU: cpu.New()}
+}
+
+func (s *CPUSimulator) Load(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".asm":
+		binary, err := assembler.New().Compile(f)
+		if err != nil {
+			return err
+		}
+		return s.CPU.Load(binary)
+	case ".hack":
+		return s.CPU.LoadHack(f)
+	}
+	return fmt.Errorf("cannot load %s: expected .asm or .hack", filename)
+}
+
+func (s *CPUSimulator) Set(name string, value int) error {
+	switch name {
+	case "A":
+		s.CPU.A = uint16(value)
+	case "D":
+		s.CPU.D = uint16(value)
+	case "PC":
+		s.CPU.PC = uint16(value) & 0x7fff
+	default:
+		mem, idx, err := s.memory(name)
+		if err != nil {
+			return err
+		}
+		mem[idx] = uint16(value)
+	}
+	return nil
+}
+
+func (s *CPUSimulator) Get(name string) (int, error) {
+	switch name {
+	case "A":
+		return int(s.CPU.A), nil
+	case "D":
+		return int(s.CPU.D), nil
+	case "PC":
+		return int(s.CPU.PC), nil
+	}
+	mem, idx, err := s.memory(name)
+	if err != nil {
+		return 0, err
+	}
+	return int(mem[idx]), nil
+}
+
+func (s *CPUSimulator) memory(name string) ([]uint16, int, error) {
+	var mem []uint16
+	if strings.HasPrefix(name, "RAM[") {
+		mem = s.CPU.RAM[:]
+	} else if strings.HasPrefix(name, "ROM[") {
+		mem = s.CPU.ROM[:]
+	} else {
+		return nil, 0, fmt.Errorf("unknown variable: %s", name)
+	}
+	if !strings.HasSuffix(name, "]") {
+		return nil, 0, fmt.Errorf("unknown variable: %s", name)
+	}
+	idx, err := strconv.Atoi(name[4 : len(name)-1])
+	if err != nil || idx < 0 || idx >= len(mem) {
+		return nil, 0, fmt.Errorf("bad address: %s", name)
+	}
+	return mem, idx, nil
+}
+
+func (s *CPUSimulator) Eval() error {
+	return nil
+}
+
+// Tick has no visible effect on the CPU, the instruction commits on Tock.
+func (s *CPUSimulator) Tick() error {
+	return nil
+}
+
+func (s *CPUSimulator) Tock() error {
+	return s.CPU.Step()
+}
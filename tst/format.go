@@ -0,0 +1,146 @@
+package tst
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Column is one entry of output-list, e.g. RAM[0]%D2.6.2
+type Column struct {
+	Name   string
+	Format byte
+	Left   int
+	Width  int
+	Right  int
+}
+
+func ParseColumn(s string) (Column, error) {
+	col := Column{Name: s, Format: 'B', Left: 1, Width: 16, Right: 1}
+	idx := strings.Index(s, "%")
+	if idx == -1 {
+		return col, nil
+	}
+	col.Name = s[:idx]
+	spec := s[idx+1:]
+	if spec == "" {
+		return col, fmt.Errorf("missing format: %s", s)
+	}
+	col.Format = spec[0]
+	if !strings.Contains("BDXS", string(col.Format)) {
+		return col, fmt.Errorf("unknown format %c: %s", col.Format, s)
+	}
+	parts := strings.Split(spec[1:], ".")
+	if len(parts) != 3 {
+		return col, fmt.Errorf("expected %%<fmt><left>.<width>.<right>: %s", s)
+	}
+	nums := make([]int, 3)
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return col, fmt.Errorf("bad format width: %s", s)
+		}
+		nums[i] = n
+	}
+	col.Left, col.Width, col.Right = nums[0], nums[1], nums[2]
+	return col, nil
+}
+
+func (c Column) total() int {
+	return c.Left + c.Width + c.Right
+}
+
+// Header centers the (possibly truncated) column name like the Java tools do.
+func (c Column) Header() string {
+	name := c.Name
+	if len(name) > c.total() {
+		name = name[:c.total()]
+	}
+	left := (c.total() - len(name)) / 2
+	right := c.total() - len(name) - left
+	return strings.Repeat(" ", left) + name + strings.Repeat(" ", right)
+}
+
+func (c Column) FormatValue(value int) string {
+	var s string
+	switch c.Format {
+	case 'D':
+		s = strconv.Itoa(int(int16(value)))
+		s = padLeft(s, c.Width, ' ')
+	case 'B':
+		s = strconv.FormatUint(uint64(uint16(value)), 2)
+		s = padLeft(s, c.Width, '0')
+	case 'X':
+		s = strings.ToUpper(strconv.FormatUint(uint64(uint16(value)), 16))
+		s = padLeft(s, c.Width, '0')
+	case 'S':
+		s = strconv.Itoa(value)
+		s = padRight(s, c.Width)
+	}
+	return c.pad(s)
+}
+
+func (c Column) FormatString(value string) string {
+	return c.pad(padRight(value, c.Width))
+}
+
+func (c Column) pad(s string) string {
+	return strings.Repeat(" ", c.Left) + s + strings.Repeat(" ", c.Right)
+}
+
+func padLeft(s string, width int, ch byte) string {
+	if len(s) > width {
+		return s[len(s)-width:]
+	}
+	return strings.Repeat(string(ch), width-len(s)) + s
+}
+
+func padRight(s string, width int) string {
+	if len(s) > width {
+		return s[:width]
+	}
+	return s + strings.Repeat(" ", width-len(s))
+}
+
+// ParseValue accepts plain decimals and the %B, %D and %X prefixed forms.
+func ParseValue(s string) (int, error) {
+	base := 10
+	if strings.HasPrefix(s, "%") && len(s) > 1 {
+		switch s[1] {
+		case 'B':
+			base = 2
+		case 'D':
+			base = 10
+		case 'X':
+			base = 16
+		default:
+			return 0, fmt.Errorf("unknown value format: %s", s)
+		}
+		s = s[2:]
+	}
+	if base == 10 {
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, fmt.Errorf("bad value: %s", s)
+		}
+		return v, nil
+	}
+	v, err := strconv.ParseUint(s, base, 16)
+	if err != nil {
+		return 0, fmt.Errorf("bad value: %s", s)
+	}
+	return int(int16(v)), nil
+}
+
+// Match compares an output line against a .cmp line where '*' matches anything.
+func Match(expected, actual string) bool {
+	if len(expected) != len(actual) {
+		return false
+	}
+	for i := 0; i < len(expected); i++ {
+		if expected[i] != '*' && expected[i] != actual[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,171 @@
+package tst
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mingpepe/Nand2teris/hdl"
+)
+
+// HDLSimulator runs HardwareSimulator scripts against chips loaded from .hdl files.
+type HDLSimulator struct {
+	Chip *hdl.Chip
+	dir  string
+}
+
+func NewHDLSimulator() *HDLSimulator {
+	return &HDLSimulator{}
+}
+
+func (s *HDLSimulator) Load(filename string) error {
+	if strings.ToLower(filepath.Ext(filename)) != ".hdl" {
+		return fmt.Errorf("cannot load %s: expected .hdl", filename)
+	}
+	chip, err := hdl.LoadFile(filename)
+	if err != nil {
+		return err
+	}
+	s.Chip = chip
+	s.dir = filepath.Dir(filename)
+	return nil
+}
+
+func (s *HDLSimulator) chip() (*hdl.Chip, error) {
+	if s.Chip == nil {
+		return nil, fmt.Errorf("no chip loaded")
+	}
+	return s.Chip, nil
+}
+
+// Set assigns an input pin, or a word of a built-in part like RAM16K[5].
+func (s *HDLSimulator) Set(name string, value int) error {
+	chip, err := s.chip()
+	if err != nil {
+		return err
+	}
+	if mem, idx, ok, err := s.memory(name); ok {
+		if err != nil {
+			return err
+		}
+		mem[idx] = uint16(value)
+		return nil
+	}
+	return chip.Set(name, value)
+}
+
+// Get reads a pin, or the state of a built-in part: DRegister[], PC[] or RAM16K[5].
+func (s *HDLSimulator) Get(name string) (int, error) {
+	chip, err := s.chip()
+	if err != nil {
+		return 0, err
+	}
+	if mem, idx, ok, err := s.memory(name); ok {
+		if err != nil {
+			return 0, err
+		}
+		return int(int16(mem[idx])), nil
+	}
+	return chip.Get(name)
+}
+
+// memory resolves Part[index] when Part is not a pin of the chip. Registers
+// have a single word and ignore the index.
+func (s *HDLSimulator) memory(name string) ([]uint16, int, bool, error) {
+	i := strings.Index(name, "[")
+	if i == -1 || !strings.HasSuffix(name, "]") {
+		return nil, 0, false, nil
+	}
+	part := name[:i]
+	for _, pin := range append(append([]hdl.Pin{}, s.Chip.Inputs()...), s.Chip.Outputs()...) {
+		if pin.Name == part {
+			return nil, 0, false, nil
+		}
+	}
+	mem, exist := s.Chip.Memory(part)
+	if !exist {
+		return nil, 0, false, nil
+	}
+	if len(mem) == 1 {
+		return mem, 0, true, nil
+	}
+	idx, err := strconv.Atoi(name[i+1 : len(name)-1])
+	if err != nil || idx < 0 || idx >= len(mem) {
+		return nil, 0, true, fmt.Errorf("bad address: %s", name)
+	}
+	return mem, idx, true, nil
+}
+
+func (s *HDLSimulator) Eval() error {
+	chip, err := s.chip()
+	if err != nil {
+		return err
+	}
+	chip.Eval()
+	return nil
+}
+
+func (s *HDLSimulator) Tick() error {
+	chip, err := s.chip()
+	if err != nil {
+		return err
+	}
+	chip.Tick()
+	return nil
+}
+
+func (s *HDLSimulator) Tock() error {
+	chip, err := s.chip()
+	if err != nil {
+		return err
+	}
+	chip.Tock()
+	return nil
+}
+
+// PartCommand handles "ROM32K load Max.hack", filling the ROM of the computer.
+func (s *HDLSimulator) PartCommand(part string, args []string) error {
+	if _, err := s.chip(); err != nil {
+		return err
+	}
+	if len(args) != 2 || args[0] != "load" {
+		return fmt.Errorf("expected load and a file name")
+	}
+	mem, exist := s.Chip.Memory(part)
+	if !exist {
+		return fmt.Errorf("%s has no part %s", s.Chip.Name(), part)
+	}
+	filename := args[1]
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(s.dir, filename)
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for i := range mem {
+		mem[i] = 0
+	}
+	scanner := bufio.NewScanner(f)
+	for addr := 0; scanner.Scan(); {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if addr >= len(mem) {
+			return fmt.Errorf("%s: program too large for %s", filename, part)
+		}
+		word, err := strconv.ParseUint(line, 2, 16)
+		if err != nil || len(line) != 16 {
+			return fmt.Errorf("%s: bad instruction: %s", filename, line)
+		}
+		mem[addr] = uint16(word)
+		addr++
+	}
+	return scanner.Err()
+}
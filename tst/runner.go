@@ -0,0 +1,366 @@
+package tst
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Simulator is the chip or computer a test script drives.
+type Simulator interface {
+	// Load receives the path resolved against the script directory.
+	Load(filename string) error
+	Set(name string, value int) error
+	Get(name string) (int, error)
+	Eval() error
+	Tick() error
+	Tock() error
+}
+
+// PartCommander is implemented by simulators accepting commands addressed to
+// one of their parts, e.g. "ROM32K load Max.hack".
+type PartCommander interface {
+	PartCommand(part string, args []string) error
+}
+
+type CompareError struct {
+	Line     int
+	Expected string
+	Actual   string
+}
+
+func (e *CompareError) Error() string {
+	return fmt.Sprintf("comparison failure at line %d\nexpected: %s\nactual:   %s", e.Line, e.Expected, e.Actual)
+}
+
+// Diff shows both lines with a marker under the columns that differ.
+func (e *CompareError) Diff() string {
+	marker := make([]byte, len(e.Actual))
+	expected := strings.Split(e.Expected, "|")
+	actual := strings.Split(e.Actual, "|")
+	pos := 0
+	for i, field := range actual {
+		ch := byte(' ')
+		if i >= len(expected) || !Match(expected[i], field) {
+			ch = '^'
+		}
+		for j := 0; j < len(field); j++ {
+			marker[pos+j] = ch
+		}
+		if pos+len(field) < len(marker) {
+			marker[pos+len(field)] = ' '
+		}
+		pos += len(field) + 1
+	}
+	return fmt.Sprintf("line %d:\n- %s\n+ %s\n  %s", e.Line, e.Expected, e.Actual, strings.TrimRight(string(marker), " "))
+}
+
+type Runner struct {
+	sim     Simulator
+	dir     string
+	columns []Column
+	output  []string
+	outFile *os.File
+	cmp     []string
+	time    int
+	// Set after tick, cleared by tock
+	halfCycle bool
+	// Echo receives the text of echo commands
+	Echo io.Writer
+	// KeepGoing records every mismatch in Mismatches instead of stopping
+	// at the first one
+	KeepGoing  bool
+	Mismatches []*CompareError
+}
+
+func NewRunner(sim Simulator, dir string) *Runner {
+	r := &Runner{}
+	r.sim = sim
+	r.dir = dir
+	r.columns = make([]Column, 0)
+	r.output = make([]string, 0)
+	r.Echo = os.Stdout
+	return r
+}
+
+// RunFile parses and executes the script, resolving file names against its directory.
+func RunFile(sim Simulator, path string) (*Runner, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cmds, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	r := NewRunner(sim, filepath.Dir(path))
+	return r, r.Run(cmds)
+}
+
+// Output returns every line produced so far, header included.
+func (r *Runner) Output() []string {
+	return r.output
+}
+
+func (r *Runner) Run(cmds []Command) error {
+	defer r.closeOutput()
+	if err := r.runBlock(cmds); err != nil {
+		return err
+	}
+	if len(r.Mismatches) > 0 {
+		return r.Mismatches[0]
+	}
+	return nil
+}
+
+func (r *Runner) runBlock(cmds []Command) error {
+	for _, cmd := range cmds {
+		if err := r.exec(cmd); err != nil {
+			if _, ok := err.(*CompareError); ok {
+				return err
+			}
+			return fmt.Errorf("line %d: %s: %v", cmd.Line, cmd.Name, err)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) exec(cmd Command) error {
+	switch cmd.Name {
+	case "load":
+		if len(cmd.Args) != 1 {
+			return fmt.Errorf("expected a file name")
+		}
+		return r.sim.Load(r.path(cmd.Args[0]))
+	case "output-file":
+		if len(cmd.Args) != 1 {
+			return fmt.Errorf("expected a file name")
+		}
+		r.closeOutput()
+		f, err := os.Create(r.path(cmd.Args[0]))
+		if err != nil {
+			return err
+		}
+		r.outFile = f
+	case "compare-to":
+		if len(cmd.Args) != 1 {
+			return fmt.Errorf("expected a file name")
+		}
+		lines, err := readLines(r.path(cmd.Args[0]))
+		if err != nil {
+			return err
+		}
+		r.cmp = lines
+	case "output-list":
+		r.columns = make([]Column, 0)
+		for _, arg := range cmd.Args {
+			col, err := ParseColumn(arg)
+			if err != nil {
+				return err
+			}
+			r.columns = append(r.columns, col)
+		}
+		line := "|"
+		for _, col := range r.columns {
+			line += col.Header() + "|"
+		}
+		return r.writeLine(line)
+	case "output":
+		line := "|"
+		for _, col := range r.columns {
+			s, err := r.format(col)
+			if err != nil {
+				return err
+			}
+			line += s + "|"
+		}
+		return r.writeLine(line)
+	case "set":
+		if len(cmd.Args) != 2 {
+			return fmt.Errorf("expected a name and a value")
+		}
+		value, err := ParseValue(cmd.Args[1])
+		if err != nil {
+			return err
+		}
+		return r.sim.Set(cmd.Args[0], value)
+	case "eval":
+		return r.sim.Eval()
+	case "tick":
+		if err := r.sim.Tick(); err != nil {
+			return err
+		}
+		r.halfCycle = true
+	case "tock":
+		if err := r.sim.Tock(); err != nil {
+			return err
+		}
+		r.halfCycle = false
+		r.time++
+	case "ticktock":
+		if err := r.sim.Tick(); err != nil {
+			return err
+		}
+		if err := r.sim.Tock(); err != nil {
+			return err
+		}
+		r.time++
+	case "repeat":
+		count := -1
+		if len(cmd.Args) == 1 {
+			n, err := strconv.Atoi(cmd.Args[0])
+			if err != nil {
+				return fmt.Errorf("bad repeat count: %s", cmd.Args[0])
+			}
+			count = n
+		} else if len(cmd.Args) > 1 {
+			return fmt.Errorf("unexpected arguments: %v", cmd.Args)
+		}
+		for i := 0; count < 0 || i < count; i++ {
+			if err := r.runBlock(cmd.Body); err != nil {
+				return err
+			}
+		}
+	case "while":
+		for {
+			ok, err := r.condition(cmd.Args)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+			if err := r.runBlock(cmd.Body); err != nil {
+				return err
+			}
+		}
+	case "echo":
+		if r.Echo != nil {
+			fmt.Fprintln(r.Echo, strings.Join(cmd.Args, " "))
+		}
+	case "clear-echo", "breakpoint", "clear-breakpoints":
+		// Only meaningful for the GUI
+	default:
+		if pc, ok := r.sim.(PartCommander); ok && len(cmd.Args) > 0 {
+			return pc.PartCommand(cmd.Name, cmd.Args)
+		}
+		return fmt.Errorf("unknown command")
+	}
+	return nil
+}
+
+// condition evaluates "out <> 75", the operands being values or variables.
+func (r *Runner) condition(args []string) (bool, error) {
+	expr := strings.Join(args, "")
+	for _, op := range []string{"<>", "<=", ">=", "=", "<", ">"} {
+		idx := strings.Index(expr, op)
+		if idx == -1 {
+			continue
+		}
+		left, err := r.operand(expr[:idx])
+		if err != nil {
+			return false, err
+		}
+		right, err := r.operand(expr[idx+len(op):])
+		if err != nil {
+			return false, err
+		}
+		switch op {
+		case "<>":
+			return left != right, nil
+		case "<=":
+			return left <= right, nil
+		case ">=":
+			return left >= right, nil
+		case "=":
+			return left == right, nil
+		case "<":
+			return left < right, nil
+		}
+		return left > right, nil
+	}
+	return false, fmt.Errorf("bad condition: %s", strings.Join(args, " "))
+}
+
+func (r *Runner) operand(s string) (int, error) {
+	if value, err := ParseValue(s); err == nil {
+		return int(int16(value)), nil
+	}
+	value, err := r.sim.Get(s)
+	if err != nil {
+		return 0, err
+	}
+	return int(int16(value)), nil
+}
+
+func (r *Runner) format(col Column) (string, error) {
+	if col.Name == "time" {
+		t := strconv.Itoa(r.time)
+		if r.halfCycle {
+			t += "+"
+		}
+		return col.FormatString(t), nil
+	}
+	value, err := r.sim.Get(col.Name)
+	if err != nil {
+		return "", err
+	}
+	return col.FormatValue(value), nil
+}
+
+func (r *Runner) writeLine(line string) error {
+	r.output = append(r.output, line)
+	if r.outFile != nil {
+		if _, err := r.outFile.WriteString(line + "\n"); err != nil {
+			return err
+		}
+	}
+	if r.cmp != nil {
+		n := len(r.output)
+		expected := ""
+		if n <= len(r.cmp) {
+			expected = r.cmp[n-1]
+		}
+		if !Match(expected, line) {
+			err := &CompareError{Line: n, Expected: expected, Actual: line}
+			if !r.KeepGoing {
+				return err
+			}
+			r.Mismatches = append(r.Mismatches, err)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) closeOutput() {
+	if r.outFile != nil {
+		r.outFile.Close()
+		r.outFile = nil
+	}
+}
+
+func (r *Runner) path(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(r.dir, name)
+}
+
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimRight(scanner.Text(), " \t\r"))
+	}
+	return lines, scanner.Err()
+}
@@ -0,0 +1,111 @@
+package tst
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mingpepe/Nand2teris/vm"
+)
+
+// vmTests are the scripts of projects 07 and 08, each testing the program
+// of its directory. Programs with a Sys.init start from the bootstrap code.
+var vmTests = []struct {
+	dir       string
+	bootstrap bool
+}{
+	{"../projects/07/MemoryAccess/BasicTest", false},
+	{"../projects/07/MemoryAccess/PointerTest", false},
+	{"../projects/07/MemoryAccess/StaticTest", false},
+	{"../projects/07/StackArithmetic/SimpleAdd", false},
+	{"../projects/07/StackArithmetic/StackTest", false},
+	{"../projects/08/FunctionCalls/FibonacciElement", true},
+	{"../projects/08/FunctionCalls/NestedCall", false},
+	{"../projects/08/FunctionCalls/SimpleFunction", false},
+	{"../projects/08/FunctionCalls/StaticsTest", true},
+	{"../projects/08/ProgramFlow/BasicLoop", false},
+	{"../projects/08/ProgramFlow/FibonacciSeries", false},
+}
+
+// translate compiles the .vm files of dir like executable/vm does.
+func translate(t *testing.T, dir string, bootstrap bool, optimize bool) string {
+	t.Helper()
+	filenames, err := filepath.Glob(filepath.Join(dir, "*.vm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := vm.New()
+	v.SetOptimize(optimize)
+	code := ""
+	if bootstrap {
+		code += v.BootstrapCode()
+	}
+	for _, filename := range filenames {
+		src, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		class := strings.TrimSuffix(filepath.Base(filename), ".vm")
+		asm, err := v.Compile(class, strings.NewReader(string(src)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		code += asm
+	}
+	if optimize {
+		code += v.SharedCode()
+	}
+	return code
+}
+
+// copyFile copies a script or a compare file next to the translated program.
+func copyFile(t *testing.T, src, dst string) {
+	t.Helper()
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dst, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVMTranslatorScripts(t *testing.T) {
+	testVMTranslator(t, false)
+}
+
+func TestOptimizedVMTranslatorScripts(t *testing.T) {
+	testVMTranslator(t, true)
+}
+
+func testVMTranslator(t *testing.T, optimize bool) {
+	for _, test := range vmTests {
+		name := filepath.Base(test.dir)
+		t.Run(name, func(t *testing.T) {
+			tmp, err := ioutil.TempDir("", "tst")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmp)
+			code := translate(t, test.dir, test.bootstrap, optimize)
+			if err := ioutil.WriteFile(filepath.Join(tmp, name+".asm"), []byte(code), 0644); err != nil {
+				t.Fatal(err)
+			}
+			copyFile(t, filepath.Join(test.dir, name+".tst"), filepath.Join(tmp, name+".tst"))
+			copyFile(t, filepath.Join(test.dir, name+".cmp"), filepath.Join(tmp, name+".cmp"))
+
+			r, err := RunFile(NewCPUSimulator(), filepath.Join(tmp, name+".tst"))
+			if err != nil {
+				if cmp, ok := err.(*CompareError); ok {
+					t.Fatal(cmp.Diff())
+				}
+				t.Fatal(err)
+			}
+			if len(r.Output()) < 2 {
+				t.Fatalf("no output compared")
+			}
+		})
+	}
+}
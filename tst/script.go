@@ -0,0 +1,159 @@
+package tst
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+type Command struct {
+	Name string
+	Args []string
+	// Body of repeat/while blocks
+	Body []Command
+	Line int
+}
+
+type word struct {
+	text   string
+	quoted bool
+	line   int
+}
+
+func Parse(reader io.Reader) ([]Command, error) {
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	words, err := splitWords(string(content))
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{words: words}
+	cmds, err := p.parseBlock(false)
+	if err != nil {
+		return nil, err
+	}
+	return cmds, nil
+}
+
+func splitWords(src string) ([]word, error) {
+	words := make([]word, 0)
+	line := 1
+	for i := 0; i < len(src); {
+		ch := src[i]
+		switch {
+		case ch == '\n':
+			line++
+			i++
+		case strings.IndexByte(sep, ch) != -1:
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end == -1 {
+				return nil, fmt.Errorf("line %d: unterminated comment", line)
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+		case ch == '"':
+			end := strings.IndexByte(src[i+1:], '"')
+			if end == -1 {
+				return nil, fmt.Errorf("line %d: unterminated string", line)
+			}
+			words = append(words, word{text: src[i+1 : i+1+end], quoted: true, line: line})
+			i += end + 2
+		case strings.IndexByte(punctuations, ch) != -1:
+			words = append(words, word{text: string(ch), line: line})
+			i++
+		default:
+			start := i
+			for i < len(src) && strings.IndexByte(sep+punctuations+"\"", src[i]) == -1 &&
+				!strings.HasPrefix(src[i:], "//") && !strings.HasPrefix(src[i:], "/*") {
+				i++
+			}
+			words = append(words, word{text: src[start:i], line: line})
+		}
+	}
+	return words, nil
+}
+
+const sep = " \t\r\n"
+
+// ',' separates commands inside a step, ';' and '!' end a step
+const punctuations = "{},;!"
+
+type parser struct {
+	words []word
+	ptr   int
+}
+
+func (p *parser) peek() *word {
+	if p.ptr < len(p.words) {
+		return &p.words[p.ptr]
+	}
+	return nil
+}
+
+func (p *parser) parseBlock(nested bool) ([]Command, error) {
+	cmds := make([]Command, 0)
+	for {
+		w := p.peek()
+		if w == nil {
+			if nested {
+				return nil, fmt.Errorf("missing '}'")
+			}
+			return cmds, nil
+		}
+		if !w.quoted && w.text == "}" {
+			if !nested {
+				return nil, fmt.Errorf("line %d: unexpected '}'", w.line)
+			}
+			p.ptr++
+			return cmds, nil
+		}
+		if !w.quoted && strings.Contains(",;!", w.text) {
+			p.ptr++
+			continue
+		}
+
+		cmd, err := p.parseCommand()
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, cmd)
+	}
+}
+
+func (p *parser) parseCommand() (Command, error) {
+	first := p.peek()
+	cmd := Command{Name: first.text, Line: first.line, Args: make([]string, 0)}
+	p.ptr++
+	for {
+		w := p.peek()
+		if w == nil {
+			return cmd, nil
+		}
+		if !w.quoted && strings.Contains(",;!}", w.text) {
+			return cmd, nil
+		}
+		if !w.quoted && w.text == "{" {
+			if cmd.Name != "repeat" && cmd.Name != "while" {
+				return cmd, fmt.Errorf("line %d: unexpected '{' after %s", w.line, cmd.Name)
+			}
+			p.ptr++
+			body, err := p.parseBlock(true)
+			if err != nil {
+				return cmd, fmt.Errorf("line %d: %v", w.line, err)
+			}
+			cmd.Body = body
+			return cmd, nil
+		}
+		cmd.Args = append(cmd.Args, w.text)
+		p.ptr++
+	}
+}
@@ -0,0 +1,198 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Builtin implements an OS function in Go. Arguments are in call order and
+// void functions return 0.
+type Builtin func(e *Emulator, args []uint16) (uint16, error)
+
+// errBlocked is returned by built-ins waiting for input or time to pass; the
+// call stays pending and is retried on the next Step.
+var errBlocked = errors.New("blocked")
+
+// OSError is raised by Sys.error and by the built-ins on illegal arguments,
+// using the error codes of the Jack OS.
+type OSError struct {
+	Code int
+}
+
+func (e *OSError) Error() string {
+	return fmt.Sprintf("Sys.error(%d)", e.Code)
+}
+
+const (
+	SCREEN = 16384
+	KBD    = 24576
+
+	HeapBase = 2048
+	HeapEnd  = SCREEN
+
+	// Sys.wait busy-waits this many VM commands per millisecond
+	CyclesPerMillisecond = 100
+
+	// Upper bound for a built-in waiting on VM code it called
+	nestedCallLimit = 10000000
+)
+
+var osBuiltins = map[string]Builtin{}
+
+func registerBuiltins(class string, functions map[string]Builtin) {
+	for name, f := range functions {
+		osBuiltins[class+"."+name] = f
+	}
+}
+
+// Sys.init is VM code rather than a Go function, so that Main.main and the
+// init of classes loaded from .vm files run on the emulator as usual.
+const sysInitCode = "function Sys.init 0\n" +
+	"call Memory.init 0\n" +
+	"pop temp 0\n" +
+	"call Keyboard.init 0\n" +
+	"pop temp 0\n" +
+	"call Math.init 0\n" +
+	"pop temp 0\n" +
+	"call Output.init 0\n" +
+	"pop temp 0\n" +
+	"call Screen.init 0\n" +
+	"pop temp 0\n" +
+	"call Main.main 0\n" +
+	"pop temp 0\n" +
+	"call Sys.halt 0\n" +
+	"pop temp 0\n" +
+	"push constant 0\n" +
+	"return\n"
+
+type osState struct {
+	heap     []segment
+	cursorX  int
+	cursorY  int
+	color    bool
+	keyboard keyboardState
+	// Cycle at which a pending Sys.wait returns, -1 when idle
+	waitUntil int
+}
+
+func newOSState() *osState {
+	s := &osState{}
+	s.resetHeap()
+	s.color = true
+	s.waitUntil = -1
+	return s
+}
+
+// PreferVM makes calls to the given classes run their loaded .vm code instead
+// of the Go built-ins, e.g. to validate a project-12 Memory.vm.
+func (e *Emulator) PreferVM(classes ...string) {
+	for _, class := range classes {
+		e.preferVM[class] = true
+	}
+}
+
+// DisableBuiltins runs only loaded .vm code, like the project 7 and 8 tests expect.
+func (e *Emulator) DisableBuiltins() {
+	e.builtins = map[string]Builtin{}
+}
+
+func (e *Emulator) builtin(name string) (Builtin, bool) {
+	f, exist := e.builtins[name]
+	if !exist {
+		return nil, false
+	}
+	class := name
+	if idx := strings.Index(name, "."); idx != -1 {
+		class = name[:idx]
+	}
+	if e.preferVM[class] {
+		if _, hasVM := e.functions[name]; hasVM {
+			return nil, false
+		}
+	}
+	return f, true
+}
+
+func (e *Emulator) callBuiltin(f Builtin, nArgs int, next int) error {
+	sp := int(e.RAM[0])
+	if nArgs > sp {
+		return fmt.Errorf("stack underflow")
+	}
+	args := make([]uint16, nArgs)
+	copy(args, e.RAM[sp-nArgs:sp])
+	value, err := f(e, args)
+	if err == errBlocked {
+		// Keep the arguments on the stack and retry
+		return nil
+	}
+	if osErr, ok := err.(*OSError); ok {
+		// Print ERR<code> and stop like the Java OS
+		for _, c := range "ERR" + strconv.Itoa(osErr.Code) {
+			e.printChar(uint16(c))
+		}
+		e.Halted = true
+	}
+	if err != nil {
+		return err
+	}
+	e.RAM[0] = uint16(sp - nArgs)
+	e.push(value)
+	e.PC = next
+	return nil
+}
+
+// invoke lets a built-in call another OS function, running it to completion
+// on the emulator when it is implemented in VM code.
+func (e *Emulator) invoke(name string, args ...uint16) (uint16, error) {
+	if f, ok := e.builtin(name); ok {
+		for i := 0; ; i++ {
+			value, err := f(e, args)
+			if err != errBlocked {
+				return value, err
+			}
+			if i == nestedCallLimit {
+				return 0, fmt.Errorf("%s did not return", name)
+			}
+			e.tick()
+		}
+	}
+	if !e.HasFunction(name) {
+		return 0, fmt.Errorf("undefined function %s", name)
+	}
+
+	pc := e.PC
+	depth := len(e.CallStack)
+	for _, arg := range args {
+		e.push(arg)
+	}
+	if err := e.call(name, len(args), pc); err != nil {
+		return 0, err
+	}
+	for i := 0; len(e.CallStack) > depth; i++ {
+		if e.Halted {
+			return 0, fmt.Errorf("halted inside %s", name)
+		}
+		if i == nestedCallLimit {
+			return 0, fmt.Errorf("%s did not return", name)
+		}
+		if err := e.Step(); err != nil {
+			return 0, err
+		}
+	}
+	e.PC = pc
+	return e.pop(), nil
+}
+
+func osError(code int) error {
+	return &OSError{Code: code}
+}
+
+func toInt(v uint16) int {
+	return int(int16(v))
+}
+
+func boolArg(v uint16) bool {
+	return v != 0
+}
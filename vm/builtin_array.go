@@ -0,0 +1,19 @@
+package vm
+
+func init() {
+	registerBuiltins("Array", map[string]Builtin{
+		"new":     arrayNew,
+		"dispose": arrayDispose,
+	})
+}
+
+func arrayNew(e *Emulator, args []uint16) (uint16, error) {
+	if toInt(args[0]) <= 0 {
+		return 0, osError(2)
+	}
+	return e.invoke("Memory.alloc", args[0])
+}
+
+func arrayDispose(e *Emulator, args []uint16) (uint16, error) {
+	return e.invoke("Memory.deAlloc", args[0])
+}
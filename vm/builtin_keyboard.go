@@ -0,0 +1,119 @@
+package vm
+
+const (
+	newLineKey   = 128
+	backSpaceKey = 129
+)
+
+// keyboardState lets the blocking Keyboard functions resume where they left
+// off when their call is retried.
+type keyboardState struct {
+	pressed    uint16
+	reading    bool
+	line       []uint16
+	lineActive bool
+}
+
+func init() {
+	registerBuiltins("Keyboard", map[string]Builtin{
+		"init":       keyboardInit,
+		"keyPressed": keyboardKeyPressed,
+		"readChar":   keyboardReadChar,
+		"readLine":   keyboardReadLine,
+		"readInt":    keyboardReadInt,
+	})
+}
+
+func keyboardInit(e *Emulator, args []uint16) (uint16, error) {
+	e.os.keyboard = keyboardState{}
+	return 0, nil
+}
+
+func keyboardKeyPressed(e *Emulator, args []uint16) (uint16, error) {
+	return e.read(KBD), nil
+}
+
+// pollKey reports a key once it has been pressed and released.
+func (e *Emulator) pollKey() (uint16, bool) {
+	k := &e.os.keyboard
+	key := e.read(KBD)
+	if !k.reading {
+		if key == 0 {
+			return 0, false
+		}
+		k.pressed = key
+		k.reading = true
+		return 0, false
+	}
+	if key != 0 {
+		return 0, false
+	}
+	k.reading = false
+	return k.pressed, true
+}
+
+func keyboardReadChar(e *Emulator, args []uint16) (uint16, error) {
+	c, ok := e.pollKey()
+	if !ok {
+		return 0, errBlocked
+	}
+	e.printChar(c)
+	return c, nil
+}
+
+// readLine echoes typed characters until newline, handling backspace.
+func (e *Emulator) readLine(message uint16) ([]uint16, error) {
+	k := &e.os.keyboard
+	if !k.lineActive {
+		if _, err := e.invoke("Output.printString", message); err != nil {
+			return nil, err
+		}
+		k.line = make([]uint16, 0)
+		k.lineActive = true
+	}
+	for {
+		c, ok := e.pollKey()
+		if !ok {
+			return nil, errBlocked
+		}
+		switch c {
+		case newLineKey:
+			e.println()
+			k.lineActive = false
+			return k.line, nil
+		case backSpaceKey:
+			if len(k.line) > 0 {
+				k.line = k.line[:len(k.line)-1]
+				e.backSpace()
+			}
+		default:
+			k.line = append(k.line, c)
+			e.printChar(c)
+		}
+	}
+}
+
+func keyboardReadLine(e *Emulator, args []uint16) (uint16, error) {
+	line, err := e.readLine(args[0])
+	if err != nil {
+		return 0, err
+	}
+	s, err := e.invoke("String.new", uint16(len(line)))
+	if err != nil {
+		return 0, err
+	}
+	for _, c := range line {
+		if _, err := e.invoke("String.appendChar", s, c); err != nil {
+			return 0, err
+		}
+	}
+	return s, nil
+}
+
+func keyboardReadInt(e *Emulator, args []uint16) (uint16, error) {
+	line, err := e.readLine(args[0])
+	if err != nil {
+		return 0, err
+	}
+	return uint16(parseInt(line)), nil
+}
@@ -0,0 +1,61 @@
+package vm
+
+func init() {
+	registerBuiltins("Math", map[string]Builtin{
+		"init":     mathInit,
+		"abs":      mathAbs,
+		"multiply": mathMultiply,
+		"divide":   mathDivide,
+		"min":      mathMin,
+		"max":      mathMax,
+		"sqrt":     mathSqrt,
+	})
+}
+
+func mathInit(e *Emulator, args []uint16) (uint16, error) {
+	return 0, nil
+}
+
+func mathAbs(e *Emulator, args []uint16) (uint16, error) {
+	if toInt(args[0]) < 0 {
+		return -args[0], nil
+	}
+	return args[0], nil
+}
+
+func mathMultiply(e *Emulator, args []uint16) (uint16, error) {
+	return args[0] * args[1], nil
+}
+
+func mathDivide(e *Emulator, args []uint16) (uint16, error) {
+	if args[1] == 0 {
+		return 0, osError(3)
+	}
+	return uint16(int16(toInt(args[0]) / toInt(args[1]))), nil
+}
+
+func mathMin(e *Emulator, args []uint16) (uint16, error) {
+	if toInt(args[0]) < toInt(args[1]) {
+		return args[0], nil
+	}
+	return args[1], nil
+}
+
+func mathMax(e *Emulator, args []uint16) (uint16, error) {
+	if toInt(args[0]) > toInt(args[1]) {
+		return args[0], nil
+	}
+	return args[1], nil
+}
+
+func mathSqrt(e *Emulator, args []uint16) (uint16, error) {
+	x := toInt(args[0])
+	if x < 0 {
+		return 0, osError(4)
+	}
+	y := 0
+	for (y+1)*(y+1) <= x {
+		y++
+	}
+	return uint16(y), nil
+}
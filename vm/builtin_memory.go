@@ -0,0 +1,85 @@
+package vm
+
+// Free heap block, addr is the first usable word and the word before it holds the size
+type segment struct {
+	addr int
+	size int
+}
+
+func init() {
+	registerBuiltins("Memory", map[string]Builtin{
+		"init":    memoryInit,
+		"peek":    memoryPeek,
+		"poke":    memoryPoke,
+		"alloc":   memoryAlloc,
+		"deAlloc": memoryDeAlloc,
+	})
+}
+
+func (s *osState) resetHeap() {
+	s.heap = []segment{{addr: HeapBase + 1, size: HeapEnd - HeapBase - 1}}
+}
+
+func memoryInit(e *Emulator, args []uint16) (uint16, error) {
+	e.os.resetHeap()
+	return 0, nil
+}
+
+func memoryPeek(e *Emulator, args []uint16) (uint16, error) {
+	return e.read(args[0] & 0x7fff), nil
+}
+
+func memoryPoke(e *Emulator, args []uint16) (uint16, error) {
+	e.RAM[args[0]&0x7fff] = args[1]
+	return 0, nil
+}
+
+// First fit, the remainder of a split block stays free.
+func memoryAlloc(e *Emulator, args []uint16) (uint16, error) {
+	size := toInt(args[0])
+	if size <= 0 {
+		return 0, osError(5)
+	}
+	for i, free := range e.os.heap {
+		if free.size < size {
+			continue
+		}
+		if free.size > size+1 {
+			e.os.heap[i] = segment{addr: free.addr + size + 1, size: free.size - size - 1}
+		} else {
+			size = free.size
+			e.os.heap = append(e.os.heap[:i], e.os.heap[i+1:]...)
+		}
+		e.RAM[free.addr-1] = uint16(size)
+		return uint16(free.addr), nil
+	}
+	return 0, osError(6)
+}
+
+// Returns the block to the address ordered free list and merges neighbours.
+func memoryDeAlloc(e *Emulator, args []uint16) (uint16, error) {
+	addr := int(args[0])
+	if addr <= HeapBase || addr >= HeapEnd {
+		return 0, nil
+	}
+	block := segment{addr: addr, size: int(e.RAM[addr-1])}
+	heap := e.os.heap
+	i := 0
+	for i < len(heap) && heap[i].addr < addr {
+		i++
+	}
+	heap = append(heap, segment{})
+	copy(heap[i+1:], heap[i:])
+	heap[i] = block
+
+	merged := make([]segment, 0, len(heap))
+	for _, s := range heap {
+		if n := len(merged); n > 0 && merged[n-1].addr+merged[n-1].size+1 == s.addr {
+			merged[n-1].size += s.size + 1
+			continue
+		}
+		merged = append(merged, s)
+	}
+	e.os.heap = merged
+	return 0, nil
+}
@@ -0,0 +1,124 @@
+package vm
+
+import "strconv"
+
+// Output draws 23 rows of 64 characters, each character is 8 pixels wide and 11 high
+const (
+	outputRows = 23
+	outputCols = 64
+	charHeight = 11
+)
+
+func init() {
+	registerBuiltins("Output", map[string]Builtin{
+		"init":        outputInit,
+		"moveCursor":  outputMoveCursor,
+		"printChar":   outputPrintChar,
+		"printString": outputPrintString,
+		"printInt":    outputPrintInt,
+		"println":     outputPrintln,
+		"backSpace":   outputBackSpace,
+	})
+}
+
+func outputInit(e *Emulator, args []uint16) (uint16, error) {
+	e.os.cursorX = 0
+	e.os.cursorY = 0
+	return 0, nil
+}
+
+func outputMoveCursor(e *Emulator, args []uint16) (uint16, error) {
+	i, j := toInt(args[0]), toInt(args[1])
+	if i < 0 || i >= outputRows || j < 0 || j >= outputCols {
+		return 0, osError(20)
+	}
+	e.os.cursorY = i
+	e.os.cursorX = j
+	return 0, nil
+}
+
+func outputPrintChar(e *Emulator, args []uint16) (uint16, error) {
+	e.printChar(args[0])
+	return 0, nil
+}
+
+func outputPrintString(e *Emulator, args []uint16) (uint16, error) {
+	length, err := e.invoke("String.length", args[0])
+	if err != nil {
+		return 0, err
+	}
+	for j := 0; j < toInt(length); j++ {
+		c, err := e.invoke("String.charAt", args[0], uint16(j))
+		if err != nil {
+			return 0, err
+		}
+		e.printChar(c)
+	}
+	return 0, nil
+}
+
+func outputPrintInt(e *Emulator, args []uint16) (uint16, error) {
+	for _, c := range strconv.Itoa(toInt(args[0])) {
+		e.printChar(uint16(c))
+	}
+	return 0, nil
+}
+
+func outputPrintln(e *Emulator, args []uint16) (uint16, error) {
+	e.println()
+	return 0, nil
+}
+
+func outputBackSpace(e *Emulator, args []uint16) (uint16, error) {
+	e.backSpace()
+	return 0, nil
+}
+
+func (e *Emulator) printChar(c uint16) {
+	switch c {
+	case newLineKey:
+		e.println()
+		return
+	case backSpaceKey:
+		e.backSpace()
+		return
+	}
+	e.drawChar(c)
+	e.os.cursorX++
+	if e.os.cursorX == outputCols {
+		e.println()
+	}
+}
+
+func (e *Emulator) println() {
+	e.os.cursorX = 0
+	e.os.cursorY = (e.os.cursorY + 1) % outputRows
+}
+
+func (e *Emulator) backSpace() {
+	if e.os.cursorX > 0 {
+		e.os.cursorX--
+	} else if e.os.cursorY > 0 {
+		e.os.cursorX = outputCols - 1
+		e.os.cursorY--
+	}
+	e.drawChar(' ')
+}
+
+// drawChar draws at the cursor, two characters share each screen word.
+func (e *Emulator) drawChar(c uint16) {
+	glyph, exist := fontMap[c]
+	if !exist {
+		glyph = fontMap[0]
+	}
+	addr := SCREEN + e.os.cursorY*charHeight*32 + e.os.cursorX/2
+	for row := 0; row < charHeight; row++ {
+		word := e.RAM[addr+row*32]
+		if e.os.cursorX%2 == 0 {
+			word = word&0xff00 | glyph[row]
+		} else {
+			word = word&0x00ff | glyph[row]<<8
+		}
+		e.RAM[addr+row*32] = word
+	}
+}
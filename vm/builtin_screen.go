@@ -0,0 +1,132 @@
+package vm
+
+const (
+	screenWidth  = 512
+	screenHeight = 256
+)
+
+func init() {
+	registerBuiltins("Screen", map[string]Builtin{
+		"init":          screenInit,
+		"clearScreen":   screenClear,
+		"setColor":      screenSetColor,
+		"drawPixel":     screenDrawPixel,
+		"drawLine":      screenDrawLine,
+		"drawRectangle": screenDrawRectangle,
+		"drawCircle":    screenDrawCircle,
+	})
+}
+
+func screenInit(e *Emulator, args []uint16) (uint16, error) {
+	e.os.color = true
+	return 0, nil
+}
+
+func screenClear(e *Emulator, args []uint16) (uint16, error) {
+	for i := SCREEN; i < KBD; i++ {
+		e.RAM[i] = 0
+	}
+	return 0, nil
+}
+
+func screenSetColor(e *Emulator, args []uint16) (uint16, error) {
+	e.os.color = boolArg(args[0])
+	return 0, nil
+}
+
+func screenDrawPixel(e *Emulator, args []uint16) (uint16, error) {
+	x, y := toInt(args[0]), toInt(args[1])
+	if !onScreen(x, y) {
+		return 0, osError(7)
+	}
+	e.setPixel(x, y)
+	return 0, nil
+}
+
+func screenDrawLine(e *Emulator, args []uint16) (uint16, error) {
+	x1, y1, x2, y2 := toInt(args[0]), toInt(args[1]), toInt(args[2]), toInt(args[3])
+	if !onScreen(x1, y1) || !onScreen(x2, y2) {
+		return 0, osError(8)
+	}
+	// Bresenham
+	dx, dy := abs(x2-x1), -abs(y2-y1)
+	sx, sy := 1, 1
+	if x1 > x2 {
+		sx = -1
+	}
+	if y1 > y2 {
+		sy = -1
+	}
+	diff := dx + dy
+	for {
+		e.setPixel(x1, y1)
+		if x1 == x2 && y1 == y2 {
+			return 0, nil
+		}
+		d := 2 * diff
+		if d >= dy {
+			diff += dy
+			x1 += sx
+		}
+		if d <= dx {
+			diff += dx
+			y1 += sy
+		}
+	}
+}
+
+func screenDrawRectangle(e *Emulator, args []uint16) (uint16, error) {
+	x1, y1, x2, y2 := toInt(args[0]), toInt(args[1]), toInt(args[2]), toInt(args[3])
+	if x1 > x2 || y1 > y2 || !onScreen(x1, y1) || !onScreen(x2, y2) {
+		return 0, osError(9)
+	}
+	for y := y1; y <= y2; y++ {
+		e.drawHorizontal(x1, x2, y)
+	}
+	return 0, nil
+}
+
+func screenDrawCircle(e *Emulator, args []uint16) (uint16, error) {
+	x, y, r := toInt(args[0]), toInt(args[1]), toInt(args[2])
+	if !onScreen(x, y) {
+		return 0, osError(12)
+	}
+	if r < 0 || r > 181 || !onScreen(x-r, y-r) || !onScreen(x+r, y+r) {
+		return 0, osError(13)
+	}
+	for dy := -r; dy <= r; dy++ {
+		dx := 0
+		for (dx+1)*(dx+1) <= r*r-dy*dy {
+			dx++
+		}
+		e.drawHorizontal(x-dx, x+dx, y+dy)
+	}
+	return 0, nil
+}
+
+func (e *Emulator) drawHorizontal(x1, x2, y int) {
+	for x := x1; x <= x2; x++ {
+		e.setPixel(x, y)
+	}
+}
+
+func (e *Emulator) setPixel(x, y int) {
+	addr := SCREEN + y*32 + x/16
+	mask := uint16(1) << uint(x%16)
+	if e.os.color {
+		e.RAM[addr] |= mask
+	} else {
+		e.RAM[addr] &^= mask
+	}
+}
+
+func onScreen(x, y int) bool {
+	return x >= 0 && x < screenWidth && y >= 0 && y < screenHeight
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
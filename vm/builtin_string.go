@@ -0,0 +1,185 @@
+package vm
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// A built-in String object is [maxLength, length, chars...]
+const (
+	stringMaxLength = 0
+	stringLength    = 1
+	stringChars     = 2
+)
+
+// checkString checks that this points to a String that fits in RAM. code is
+// the error the routine raises in the Jack OS on a null or broken String, the
+// routines raising none take 0 and only fail on a String off the end of RAM.
+func (e *Emulator) checkString(this uint16, code int) error {
+	if int(this)+stringChars > RAMSize {
+		return fmt.Errorf("address out of range: %d", this)
+	}
+	maxLength, length := e.RAM[this+stringMaxLength], e.RAM[this+stringLength]
+	size := maxLength
+	if length > size {
+		size = length
+	}
+	if int(this)+stringChars+int(size) > RAMSize {
+		return fmt.Errorf("address out of range: %d", int(this)+stringChars+int(size))
+	}
+	if code != 0 && (this == 0 || length > maxLength) {
+		return osError(code)
+	}
+	return nil
+}
+
+func init() {
+	registerBuiltins("String", map[string]Builtin{
+		"new":           stringNew,
+		"dispose":       stringDispose,
+		"length":        stringLengthOf,
+		"charAt":        stringCharAt,
+		"setCharAt":     stringSetCharAt,
+		"appendChar":    stringAppendChar,
+		"eraseLastChar": stringEraseLastChar,
+		"intValue":      stringIntValue,
+		"setInt":        stringSetInt,
+		"newLine":       stringNewLine,
+		"backSpace":     stringBackSpace,
+		"doubleQuote":   stringDoubleQuote,
+	})
+}
+
+func stringNew(e *Emulator, args []uint16) (uint16, error) {
+	maxLength := toInt(args[0])
+	if maxLength < 0 {
+		return 0, osError(14)
+	}
+	this, err := e.invoke("Memory.alloc", uint16(maxLength+stringChars))
+	if err != nil {
+		return 0, err
+	}
+	e.RAM[this+stringMaxLength] = uint16(maxLength)
+	e.RAM[this+stringLength] = 0
+	return this, nil
+}
+
+func stringDispose(e *Emulator, args []uint16) (uint16, error) {
+	return e.invoke("Memory.deAlloc", args[0])
+}
+
+func stringLengthOf(e *Emulator, args []uint16) (uint16, error) {
+	this := args[0]
+	if err := e.checkString(this, 0); err != nil {
+		return 0, err
+	}
+	return e.RAM[this+stringLength], nil
+}
+
+func stringCharAt(e *Emulator, args []uint16) (uint16, error) {
+	this, j := args[0], toInt(args[1])
+	if err := e.checkString(this, 15); err != nil {
+		return 0, err
+	}
+	if j < 0 || j >= int(e.RAM[this+stringLength]) {
+		return 0, osError(15)
+	}
+	return e.RAM[int(this)+stringChars+j], nil
+}
+
+func stringSetCharAt(e *Emulator, args []uint16) (uint16, error) {
+	this, j := args[0], toInt(args[1])
+	if err := e.checkString(this, 16); err != nil {
+		return 0, err
+	}
+	if j < 0 || j >= int(e.RAM[this+stringLength]) {
+		return 0, osError(16)
+	}
+	e.RAM[int(this)+stringChars+j] = args[2]
+	return 0, nil
+}
+
+func stringAppendChar(e *Emulator, args []uint16) (uint16, error) {
+	this := args[0]
+	if err := e.checkString(this, 17); err != nil {
+		return 0, err
+	}
+	length := e.RAM[this+stringLength]
+	if length >= e.RAM[this+stringMaxLength] {
+		return 0, osError(17)
+	}
+	e.RAM[this+stringChars+length] = args[1]
+	e.RAM[this+stringLength] = length + 1
+	return this, nil
+}
+
+func stringEraseLastChar(e *Emulator, args []uint16) (uint16, error) {
+	this := args[0]
+	if err := e.checkString(this, 18); err != nil {
+		return 0, err
+	}
+	if e.RAM[this+stringLength] == 0 {
+		return 0, osError(18)
+	}
+	e.RAM[this+stringLength]--
+	return 0, nil
+}
+
+func stringIntValue(e *Emulator, args []uint16) (uint16, error) {
+	this := args[0]
+	if err := e.checkString(this, 0); err != nil {
+		return 0, err
+	}
+	length := int(e.RAM[this+stringLength])
+	chars := make([]uint16, length)
+	copy(chars, e.RAM[int(this)+stringChars:])
+	return uint16(parseInt(chars)), nil
+}
+
+func stringSetInt(e *Emulator, args []uint16) (uint16, error) {
+	this := args[0]
+	if err := e.checkString(this, 19); err != nil {
+		return 0, err
+	}
+	s := strconv.Itoa(toInt(args[1]))
+	if len(s) > int(e.RAM[this+stringMaxLength]) {
+		return 0, osError(19)
+	}
+	for i := 0; i < len(s); i++ {
+		e.RAM[int(this)+stringChars+i] = uint16(s[i])
+	}
+	e.RAM[this+stringLength] = uint16(len(s))
+	return 0, nil
+}
+
+func stringNewLine(e *Emulator, args []uint16) (uint16, error) {
+	return newLineKey, nil
+}
+
+func stringBackSpace(e *Emulator, args []uint16) (uint16, error) {
+	return backSpaceKey, nil
+}
+
+func stringDoubleQuote(e *Emulator, args []uint16) (uint16, error) {
+	return '"', nil
+}
+
+// parseInt reads an optional '-' and digits up to the first non-digit.
+func parseInt(chars []uint16) int {
+	value := 0
+	neg := false
+	for i, c := range chars {
+		if i == 0 && c == '-' {
+			neg = true
+			continue
+		}
+		if c < '0' || c > '9' {
+			break
+		}
+		value = value*10 + int(c-'0')
+	}
+	if neg {
+		value = -value
+	}
+	return int(int16(value))
+}
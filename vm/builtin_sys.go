@@ -0,0 +1,33 @@
+package vm
+
+func init() {
+	registerBuiltins("Sys", map[string]Builtin{
+		"halt":  sysHalt,
+		"error": sysError,
+		"wait":  sysWait,
+	})
+}
+
+func sysHalt(e *Emulator, args []uint16) (uint16, error) {
+	e.Halted = true
+	return 0, nil
+}
+
+func sysError(e *Emulator, args []uint16) (uint16, error) {
+	return 0, osError(toInt(args[0]))
+}
+
+func sysWait(e *Emulator, args []uint16) (uint16, error) {
+	duration := toInt(args[0])
+	if duration < 0 {
+		return 0, osError(1)
+	}
+	if e.os.waitUntil < 0 {
+		e.os.waitUntil = e.Cycles + duration*CyclesPerMillisecond
+	}
+	if e.Cycles < e.os.waitUntil {
+		return 0, errBlocked
+	}
+	e.os.waitUntil = -1
+	return 0, nil
+}
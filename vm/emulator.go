@@ -0,0 +1,528 @@
+package vm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	RAMSize    = 32768
+	StaticBase = 16
+	StackBase  = 256
+)
+
+// Command is one parsed line of a .vm file.
+type Command struct {
+	Type int
+	Arg1 string
+	Arg2 int
+	Text string
+	File string
+	Line int
+	// Function the command belongs to, empty before the first function
+	Function string
+}
+
+type Function struct {
+	Name      string
+	NumLocals int
+	File      string
+	Start     int
+}
+
+// Frame mirrors a call frame saved on the stack, kept for inspection.
+type Frame struct {
+	Function      string
+	ReturnAddress int
+	ARG           uint16
+	LCL           uint16
+}
+
+type Emulator struct {
+	RAM       [RAMSize]uint16
+	PC        int
+	CallStack []Frame
+	Cycles    int
+	Halted    bool
+	// KeyboardPolls counts the reads of the keyboard register
+	KeyboardPolls int
+
+	program   []Command
+	functions map[string]*Function
+	labels    map[string]int
+	statics   map[string]uint16
+	// Next free static address
+	staticTop uint16
+
+	builtins map[string]Builtin
+	preferVM map[string]bool
+	os       *osState
+}
+
+func NewEmulator() *Emulator {
+	e := &Emulator{}
+	e.program = make([]Command, 0)
+	e.functions = make(map[string]*Function)
+	e.labels = make(map[string]int)
+	e.statics = make(map[string]uint16)
+	e.staticTop = StaticBase
+	e.CallStack = make([]Frame, 0)
+	e.builtins = make(map[string]Builtin)
+	for name, f := range osBuiltins {
+		e.builtins[name] = f
+	}
+	e.preferVM = make(map[string]bool)
+	e.os = newOSState()
+	return e
+}
+
+// Load parses a .vm file, filename is the class name used for the static segment.
+func (e *Emulator) Load(filename string, reader io.Reader) error {
+	scanner := bufio.NewScanner(reader)
+	currentFunction := ""
+	maxStatic := -1
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		idx := strings.Index(line, "//")
+		if idx >= 0 {
+			line = line[:idx]
+		}
+		line = strings.Join(strings.Fields(line), " ")
+		if skip(line) {
+			continue
+		}
+
+		cmd, err := parseCommand(line)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", filename, lineNo, err)
+		}
+		cmd.File = filename
+		cmd.Line = lineNo
+
+		switch cmd.Type {
+		case C_FUNCTION:
+			if _, exist := e.functions[cmd.Arg1]; exist {
+				return fmt.Errorf("%s:%d: duplicated function %s", filename, lineNo, cmd.Arg1)
+			}
+			currentFunction = cmd.Arg1
+			e.functions[cmd.Arg1] = &Function{Name: cmd.Arg1, NumLocals: cmd.Arg2, File: filename, Start: len(e.program)}
+		case C_LABEL:
+			key := labelKey(currentFunction, cmd.Arg1)
+			if _, exist := e.labels[key]; exist {
+				return fmt.Errorf("%s:%d: duplicated label %s", filename, lineNo, cmd.Arg1)
+			}
+			e.labels[key] = len(e.program)
+		case C_PUSH, C_POP:
+			if cmd.Arg1 == "static" && cmd.Arg2 > maxStatic {
+				maxStatic = cmd.Arg2
+			}
+		}
+		cmd.Function = currentFunction
+		e.program = append(e.program, cmd)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if _, exist := e.statics[filename]; !exist {
+		e.statics[filename] = e.staticTop
+		e.staticTop += uint16(maxStatic + 1)
+		if e.staticTop > StackBase {
+			return fmt.Errorf("%s: static segment overflows into the stack", filename)
+		}
+	}
+	return nil
+}
+
+// LoadDir loads every .vm file of a directory in name order.
+func (e *Emulator) LoadDir(dir string) error {
+	filenames, err := filepath.Glob(filepath.Join(dir, "*.vm"))
+	if err != nil {
+		return err
+	}
+	if len(filenames) == 0 {
+		return fmt.Errorf("no vm file in %s", dir)
+	}
+	sort.Strings(filenames)
+	for _, filename := range filenames {
+		if err := e.LoadFile(filename); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadMissing loads the .vm files of dir whose class is not loaded yet, e.g.
+// tools/OS next to a program that ships some of its own OS classes.
+func (e *Emulator) LoadMissing(dir string) error {
+	filenames, err := filepath.Glob(filepath.Join(dir, "*.vm"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(filenames)
+	for _, filename := range filenames {
+		name := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+		if _, loaded := e.statics[name]; loaded {
+			continue
+		}
+		if err := e.LoadFile(filename); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Emulator) LoadFile(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	name := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	return e.Load(name, f)
+}
+
+// Start checks every jump target and sets up the entry point. Programs with a
+// Sys.init get the same bootstrap as BootstrapCode, others start at the first command.
+// With built-ins enabled, a program with Main.main but no Sys.init gets the OS one.
+func (e *Emulator) Start() error {
+	if len(e.builtins) > 0 && !e.HasFunction("Sys.init") && e.HasFunction("Main.main") {
+		if err := e.Load("Sys", strings.NewReader(sysInitCode)); err != nil {
+			return err
+		}
+	}
+	for _, cmd := range e.program {
+		switch cmd.Type {
+		case C_GOTO, C_IF:
+			if _, exist := e.labels[labelKey(cmd.Function, cmd.Arg1)]; !exist {
+				return fmt.Errorf("%s:%d: undefined label %s", cmd.File, cmd.Line, cmd.Arg1)
+			}
+		case C_CALL:
+			if _, ok := e.builtin(cmd.Arg1); !ok && !e.HasFunction(cmd.Arg1) {
+				return fmt.Errorf("%s:%d: undefined function %s", cmd.File, cmd.Line, cmd.Arg1)
+			}
+		}
+	}
+
+	e.PC = 0
+	e.Cycles = 0
+	e.Halted = false
+	e.CallStack = e.CallStack[:0]
+	if e.HasFunction("Sys.init") {
+		e.RAM[0] = StackBase
+		return e.call("Sys.init", 0, -1)
+	}
+	if e.RAM[0] == 0 {
+		e.RAM[0] = StackBase
+	}
+	return nil
+}
+
+func (e *Emulator) HasFunction(name string) bool {
+	_, exist := e.functions[name]
+	return exist
+}
+
+func (e *Emulator) Program() []Command {
+	return e.program
+}
+
+// CurrentCommand returns the command executed by the next Step, nil once halted.
+func (e *Emulator) CurrentCommand() *Command {
+	if e.Halted || e.PC < 0 || e.PC >= len(e.program) {
+		return nil
+	}
+	return &e.program[e.PC]
+}
+
+func (e *Emulator) CurrentFunction() string {
+	if len(e.CallStack) > 0 {
+		return e.CallStack[len(e.CallStack)-1].Function
+	}
+	if cmd := e.CurrentCommand(); cmd != nil {
+		return cmd.Function
+	}
+	return ""
+}
+
+func (e *Emulator) Run(maxCycles int) (int, error) {
+	for i := 0; i < maxCycles; i++ {
+		if e.Halted {
+			return i, nil
+		}
+		if err := e.Step(); err != nil {
+			return i, err
+		}
+	}
+	return maxCycles, nil
+}
+
+func (e *Emulator) Step() error {
+	cmd := e.CurrentCommand()
+	if cmd == nil {
+		e.Halted = true
+		return nil
+	}
+	if err := e.exec(cmd); err != nil {
+		return fmt.Errorf("%s:%d: %s: %w", cmd.File, cmd.Line, cmd.Text, err)
+	}
+	e.tick()
+	return nil
+}
+
+func (e *Emulator) tick() {
+	e.Cycles++
+}
+
+func (e *Emulator) exec(cmd *Command) error {
+	next := e.PC + 1
+	switch cmd.Type {
+	case C_ARITHMETIC:
+		if err := e.arithmetic(cmd.Arg1); err != nil {
+			return err
+		}
+	case C_PUSH:
+		value, err := e.Segment(cmd.Arg1, cmd.Arg2, cmd.File)
+		if err != nil {
+			return err
+		}
+		e.push(value)
+	case C_POP:
+		addr, err := e.address(cmd.Arg1, cmd.Arg2, cmd.File)
+		if err != nil {
+			return err
+		}
+		e.RAM[addr] = e.pop()
+	case C_LABEL:
+	case C_GOTO:
+		next = e.labels[labelKey(cmd.Function, cmd.Arg1)]
+		if next == e.PC-1 {
+			// "label X, goto X" is how Sys.halt ends a program
+			e.Halted = true
+		}
+	case C_IF:
+		if e.pop() != 0 {
+			next = e.labels[labelKey(cmd.Function, cmd.Arg1)]
+		}
+	case C_FUNCTION:
+		for i := 0; i < cmd.Arg2; i++ {
+			e.push(0)
+		}
+	case C_CALL:
+		if f, ok := e.builtin(cmd.Arg1); ok {
+			return e.callBuiltin(f, cmd.Arg2, next)
+		}
+		return e.call(cmd.Arg1, cmd.Arg2, next)
+	case C_RETURN:
+		return e.ret()
+	}
+	e.PC = next
+	return nil
+}
+
+func (e *Emulator) arithmetic(op string) error {
+	if op == "neg" || op == "not" {
+		x := e.pop()
+		if op == "neg" {
+			e.push(-x)
+		} else {
+			e.push(^x)
+		}
+		return nil
+	}
+
+	y := e.pop()
+	x := e.pop()
+	switch op {
+	case "add":
+		e.push(x + y)
+	case "sub":
+		e.push(x - y)
+	case "and":
+		e.push(x & y)
+	case "or":
+		e.push(x | y)
+	case "eq":
+		e.push(boolean(x == y))
+	case "gt":
+		e.push(boolean(int16(x) > int16(y)))
+	case "lt":
+		e.push(boolean(int16(x) < int16(y)))
+	default:
+		return fmt.Errorf("unknown arithmetic command %s", op)
+	}
+	return nil
+}
+
+// call pushes the frame exactly like the translated code does, the return
+// address is an index into the program.
+func (e *Emulator) call(name string, nArgs int, returnAddress int) error {
+	f, exist := e.functions[name]
+	if !exist {
+		return fmt.Errorf("undefined function %s", name)
+	}
+	sp := e.RAM[0]
+	e.push(uint16(returnAddress))
+	e.push(e.RAM[1])
+	e.push(e.RAM[2])
+	e.push(e.RAM[3])
+	e.push(e.RAM[4])
+	e.RAM[2] = sp - uint16(nArgs)
+	e.RAM[1] = e.RAM[0]
+	e.CallStack = append(e.CallStack, Frame{Function: name, ReturnAddress: returnAddress, ARG: e.RAM[2], LCL: e.RAM[1]})
+	e.PC = f.Start
+	return nil
+}
+
+func (e *Emulator) ret() error {
+	frame := e.RAM[1]
+	if frame < 5 || frame > RAMSize || e.RAM[2] >= RAMSize {
+		if len(e.CallStack) == 0 {
+			// A function run without a caller has no frame to restore
+			e.Halted = true
+			return nil
+		}
+		return fmt.Errorf("no frame to return from, LCL=%d ARG=%d", frame, e.RAM[2])
+	}
+	returnAddress := int(int16(e.RAM[frame-5]))
+	e.RAM[e.RAM[2]] = e.pop()
+	e.RAM[0] = e.RAM[2] + 1
+	e.RAM[4] = e.RAM[frame-1]
+	e.RAM[3] = e.RAM[frame-2]
+	e.RAM[2] = e.RAM[frame-3]
+	e.RAM[1] = e.RAM[frame-4]
+
+	if len(e.CallStack) == 0 {
+		// Nothing to return to
+		e.Halted = true
+		return nil
+	}
+	e.CallStack = e.CallStack[:len(e.CallStack)-1]
+	if returnAddress < 0 || returnAddress > len(e.program) {
+		e.Halted = true
+		return nil
+	}
+	e.PC = returnAddress
+	return nil
+}
+
+// Segment reads segment[index], file selects the static segment.
+func (e *Emulator) Segment(segment string, index int, file string) (uint16, error) {
+	if segment == "constant" {
+		if index < 0 || index > 32767 {
+			return 0, fmt.Errorf("constant out of range: %d", index)
+		}
+		return uint16(index), nil
+	}
+	addr, err := e.address(segment, index, file)
+	if err != nil {
+		return 0, err
+	}
+	return e.read(addr), nil
+}
+
+// read reads RAM, counting the polls of the keyboard.
+func (e *Emulator) read(addr uint16) uint16 {
+	if addr == KBD {
+		e.KeyboardPolls++
+	}
+	return e.RAM[addr]
+}
+
+func (e *Emulator) address(segment string, index int, file string) (uint16, error) {
+	if index < 0 {
+		return 0, fmt.Errorf("negative index: %d", index)
+	}
+	var addr int
+	switch segment {
+	case "local":
+		addr = int(e.RAM[1]) + index
+	case "argument":
+		addr = int(e.RAM[2]) + index
+	case "this":
+		addr = int(e.RAM[3]) + index
+	case "that":
+		addr = int(e.RAM[4]) + index
+	case "pointer":
+		if index > 1 {
+			return 0, fmt.Errorf("pointer index out of range: %d", index)
+		}
+		addr = 3 + index
+	case "temp":
+		if index > 7 {
+			return 0, fmt.Errorf("temp index out of range: %d", index)
+		}
+		addr = 5 + index
+	case "static":
+		base, exist := e.statics[file]
+		if !exist {
+			return 0, fmt.Errorf("no static segment for %s", file)
+		}
+		addr = int(base) + index
+	default:
+		return 0, fmt.Errorf("unknown segment %s", segment)
+	}
+	if addr >= RAMSize {
+		return 0, fmt.Errorf("address out of range: %d", addr)
+	}
+	return uint16(addr), nil
+}
+
+func (e *Emulator) push(value uint16) {
+	e.RAM[e.RAM[0]&0x7fff] = value
+	e.RAM[0]++
+}
+
+func (e *Emulator) pop() uint16 {
+	e.RAM[0]--
+	return e.RAM[e.RAM[0]&0x7fff]
+}
+
+func boolean(b bool) uint16 {
+	if b {
+		return 0xffff
+	}
+	return 0
+}
+
+func labelKey(function, label string) string {
+	return function + "$" + label
+}
+
+func parseCommand(line string) (Command, error) {
+	cmd := Command{Text: line}
+	cmdType, err := getCmdType(line)
+	if err != nil {
+		return cmd, err
+	}
+	cmd.Type = cmdType
+	switch cmdType {
+	case C_ARITHMETIC:
+		cmd.Arg1 = strings.Split(line, " ")[0]
+		if len(strings.Split(line, " ")) != 1 {
+			return cmd, fmt.Errorf("unexpected argument: %s", line)
+		}
+	case C_RETURN:
+		if line != "return" {
+			return cmd, fmt.Errorf("unexpected argument: %s", line)
+		}
+	case C_LABEL, C_GOTO, C_IF:
+		cmd.Arg1, err = getArg1(line)
+	case C_PUSH, C_POP, C_FUNCTION, C_CALL:
+		cmd.Arg1, err = getArg1(line)
+		if err == nil {
+			cmd.Arg2, err = getArg2(line)
+		}
+		if err == nil && cmdType == C_POP && cmd.Arg1 == "constant" {
+			err = fmt.Errorf("cannot pop to constant")
+		}
+	}
+	return cmd, err
+}
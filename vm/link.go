@@ -0,0 +1,85 @@
+package vm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Link removes the functions of a program that no chain of calls from
+// Sys.init reaches, Sys.init being what BootstrapCode calls. Sources are
+// the .vm files of the program, the removed lines are left blank so that
+// errors still point at the right line. Programs without Sys.init are kept
+// as they are. The names of the removed functions are returned sorted.
+func Link(sources []string) ([]string, []string, error) {
+	type block struct {
+		file  int
+		start int
+		end   int
+		calls []string
+	}
+	lines := make([][]string, len(sources))
+	blocks := make(map[string]*block)
+	for i, src := range sources {
+		lines[i] = strings.Split(src, "\n")
+		var current *block
+		for j, line := range lines[i] {
+			idx := strings.Index(line, "//")
+			if idx >= 0 {
+				line = line[:idx]
+			}
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+			switch fields[0] {
+			case "function":
+				if current != nil {
+					current.end = j
+				}
+				if _, exist := blocks[fields[1]]; exist {
+					return nil, nil, fmt.Errorf("function %s defined twice", fields[1])
+				}
+				current = &block{file: i, start: j, end: len(lines[i])}
+				blocks[fields[1]] = current
+			case "call":
+				if current != nil {
+					current.calls = append(current.calls, fields[1])
+				}
+			}
+		}
+	}
+	if blocks["Sys.init"] == nil {
+		return sources, nil, nil
+	}
+
+	reached := map[string]bool{"Sys.init": true}
+	queue := []string{"Sys.init"}
+	for len(queue) > 0 {
+		b := blocks[queue[0]]
+		queue = queue[1:]
+		for _, name := range b.calls {
+			if !reached[name] && blocks[name] != nil {
+				reached[name] = true
+				queue = append(queue, name)
+			}
+		}
+	}
+
+	removed := make([]string, 0)
+	for name, b := range blocks {
+		if reached[name] {
+			continue
+		}
+		removed = append(removed, name)
+		for j := b.start; j < b.end; j++ {
+			lines[b.file][j] = ""
+		}
+	}
+	sort.Strings(removed)
+	linked := make([]string, len(sources))
+	for i := range sources {
+		linked[i] = strings.Join(lines[i], "\n")
+	}
+	return linked, removed, nil
+}
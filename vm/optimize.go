@@ -0,0 +1,324 @@
+package vm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mingpepe/Nand2teris/srcmap"
+)
+
+// FunctionReport counts the commands of a function before and after Optimize.
+type FunctionReport struct {
+	Name   string
+	Before int
+	After  int
+}
+
+// Optimize rewrites VM code into shorter equivalent code, function by
+// function, until no rule applies:
+//   - runs of push constant and arithmetic are folded, as are if-goto on a
+//     constant condition,
+//   - not not and neg neg are removed,
+//   - a goto to a label right after it is removed, and "if-goto A; goto B;
+//     label A" becomes "not; if-goto B; label A" after a comparison,
+//   - code after goto or return is unreachable up to the next label,
+//   - labels no goto refers to are removed.
+//
+// Comments and blank lines are not kept.
+func Optimize(reader io.Reader, writer io.Writer) ([]FunctionReport, error) {
+	return OptimizeWithSourceMap(reader, writer, nil)
+}
+
+// OptimizeWithSourceMap is Optimize for code whose origin m records, m then
+// records the origin of the optimized code. A command made by a rule comes
+// from the command it replaces.
+func OptimizeWithSourceMap(reader io.Reader, writer io.Writer, m *srcmap.Map) ([]FunctionReport, error) {
+	functions := make([][]Command, 0)
+	current := make([]Command, 0)
+	scanner := bufio.NewScanner(reader)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		idx := strings.Index(line, "//")
+		if idx >= 0 {
+			line = line[:idx]
+		}
+		line = strings.Join(strings.Fields(line), " ")
+		if skip(line) {
+			continue
+		}
+		cmd, err := parseCommand(line)
+		if err != nil {
+			return nil, fmt.Errorf("%d: %v", lineNo, err)
+		}
+		cmd.Line = lineNo
+		if cmd.Type == C_FUNCTION && len(current) > 0 {
+			functions = append(functions, current)
+			current = make([]Command, 0)
+		}
+		current = append(current, cmd)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(current) > 0 {
+		functions = append(functions, current)
+	}
+
+	reports := make([]FunctionReport, 0, len(functions))
+	w := bufio.NewWriter(writer)
+	var mapped *srcmap.Map
+	if m != nil {
+		mapped = srcmap.New(m.File)
+	}
+	lineNo = 0
+	for _, cmds := range functions {
+		report := FunctionReport{Before: len(cmds)}
+		if cmds[0].Type == C_FUNCTION {
+			report.Name = cmds[0].Arg1
+		}
+		for {
+			before := cmds
+			cmds = foldConstants(cmds)
+			cmds = invertBranches(cmds)
+			cmds = removeJumpsToNext(cmds)
+			cmds = removeUnreachable(cmds)
+			cmds = removeUnusedLabels(cmds)
+			if same(before, cmds) {
+				break
+			}
+		}
+		report.After = len(cmds)
+		reports = append(reports, report)
+		for _, cmd := range cmds {
+			w.WriteString(cmd.Text + "\n")
+			lineNo++
+			if m == nil {
+				continue
+			}
+			if loc, ok := m.Lookup(cmd.Line); ok {
+				mapped.Set(lineNo, loc)
+			}
+		}
+	}
+	if m != nil {
+		*m = *mapped
+	}
+	return reports, w.Flush()
+}
+
+func same(a, b []Command) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Text != b[i].Text {
+			return false
+		}
+	}
+	return true
+}
+
+func newCommand(line string) Command {
+	cmd, _ := parseCommand(line)
+	return cmd
+}
+
+// from gives the commands a rule makes the line of the command they replace.
+func from(cmd Command, cmds ...Command) []Command {
+	for i := range cmds {
+		cmds[i].Line = cmd.Line
+	}
+	return cmds
+}
+
+// constant returns the value pushed by the last commands of cmds when they
+// are "push constant k", optionally followed by not or neg, and how many
+// commands that is.
+func constant(cmds []Command) (uint16, int, bool) {
+	n := len(cmds)
+	if n >= 1 && isPushConstant(cmds[n-1]) {
+		return uint16(cmds[n-1].Arg2), 1, true
+	}
+	if n >= 2 && isPushConstant(cmds[n-2]) && cmds[n-1].Type == C_ARITHMETIC {
+		k := uint16(cmds[n-2].Arg2)
+		switch cmds[n-1].Arg1 {
+		case "not":
+			return ^k, 2, true
+		case "neg":
+			return -k, 2, true
+		}
+	}
+	return 0, 0, false
+}
+
+func isPushConstant(cmd Command) bool {
+	return cmd.Type == C_PUSH && cmd.Arg1 == "constant"
+}
+
+// pushConstant is the shortest code pushing v, push constant only takes
+// values up to 32767.
+func pushConstant(v uint16) []Command {
+	if v <= 32767 {
+		return []Command{newCommand(fmt.Sprintf("push constant %d", v))}
+	}
+	return []Command{newCommand(fmt.Sprintf("push constant %d", ^v)), newCommand("not")}
+}
+
+func foldConstants(cmds []Command) []Command {
+	out := make([]Command, 0, len(cmds))
+	for _, cmd := range cmds {
+		switch {
+		case cmd.Type == C_ARITHMETIC && (cmd.Arg1 == "not" || cmd.Arg1 == "neg"):
+			last := len(out) - 1
+			if last >= 0 && out[last].Type == C_ARITHMETIC && out[last].Arg1 == cmd.Arg1 {
+				out = out[:last]
+				continue
+			}
+			if v, n, ok := constant(out); ok {
+				if cmd.Arg1 == "not" {
+					v = ^v
+				} else {
+					v = -v
+				}
+				if code := from(cmd, pushConstant(v)...); len(code) < n+1 {
+					out = append(out[:len(out)-n], code...)
+					continue
+				}
+			}
+		case cmd.Type == C_ARITHMETIC:
+			y, ny, oky := constant(out)
+			if !oky {
+				break
+			}
+			x, nx, okx := constant(out[:len(out)-ny])
+			if !okx {
+				break
+			}
+			out = append(out[:len(out)-ny-nx], from(cmd, pushConstant(binary(cmd.Arg1, x, y))...)...)
+			continue
+		case cmd.Type == C_IF:
+			if v, n, ok := constant(out); ok {
+				out = out[:len(out)-n]
+				if v != 0 {
+					out = append(out, from(cmd, newCommand("goto "+cmd.Arg1))...)
+				}
+				continue
+			}
+		}
+		out = append(out, cmd)
+	}
+	return out
+}
+
+// binary computes a binary command on 16 bit values, comparisons are signed
+// and true is -1.
+func binary(op string, x, y uint16) uint16 {
+	switch op {
+	case "add":
+		return x + y
+	case "sub":
+		return x - y
+	case "and":
+		return x & y
+	case "or":
+		return x | y
+	case "eq":
+		return boolean(x == y)
+	case "gt":
+		return boolean(int16(x) > int16(y))
+	case "lt":
+		return boolean(int16(x) < int16(y))
+	}
+	panic("unknown binary command " + op)
+}
+
+// invertBranches jumps over the else part CompileIf generates with the
+// negated condition. Not only negates true and false, so the condition must
+// be a comparison.
+func invertBranches(cmds []Command) []Command {
+	out := make([]Command, 0, len(cmds))
+	for i := 0; i < len(cmds); i++ {
+		if i+2 < len(cmds) && cmds[i].Type == C_IF && cmds[i+1].Type == C_GOTO &&
+			cmds[i+2].Type == C_LABEL && cmds[i+2].Arg1 == cmds[i].Arg1 && isBoolean(out) {
+			out = append(out, from(cmds[i], newCommand("not"), newCommand("if-goto "+cmds[i+1].Arg1))...)
+			i++
+			continue
+		}
+		out = append(out, cmds[i])
+	}
+	return out
+}
+
+// isBoolean tells whether the last commands of cmds push -1 or 0.
+func isBoolean(cmds []Command) bool {
+	n := len(cmds)
+	if n == 0 || cmds[n-1].Type != C_ARITHMETIC {
+		return false
+	}
+	switch cmds[n-1].Arg1 {
+	case "eq", "gt", "lt":
+		return true
+	case "not":
+		return isBoolean(cmds[:n-1])
+	}
+	return false
+}
+
+// removeJumpsToNext removes a goto followed by the label it jumps to, maybe
+// among other labels.
+func removeJumpsToNext(cmds []Command) []Command {
+	out := make([]Command, 0, len(cmds))
+	for i, cmd := range cmds {
+		if cmd.Type == C_GOTO {
+			next := false
+			for j := i + 1; j < len(cmds) && cmds[j].Type == C_LABEL; j++ {
+				if cmds[j].Arg1 == cmd.Arg1 {
+					next = true
+				}
+			}
+			if next {
+				continue
+			}
+		}
+		out = append(out, cmd)
+	}
+	return out
+}
+
+func removeUnreachable(cmds []Command) []Command {
+	out := make([]Command, 0, len(cmds))
+	reachable := true
+	for _, cmd := range cmds {
+		if cmd.Type == C_LABEL || cmd.Type == C_FUNCTION {
+			reachable = true
+		}
+		if reachable {
+			out = append(out, cmd)
+		}
+		if cmd.Type == C_GOTO || cmd.Type == C_RETURN {
+			reachable = false
+		}
+	}
+	return out
+}
+
+func removeUnusedLabels(cmds []Command) []Command {
+	used := make(map[string]bool)
+	for _, cmd := range cmds {
+		if cmd.Type == C_GOTO || cmd.Type == C_IF {
+			used[cmd.Arg1] = true
+		}
+	}
+	out := make([]Command, 0, len(cmds))
+	for _, cmd := range cmds {
+		if cmd.Type == C_LABEL && !used[cmd.Arg1] {
+			continue
+		}
+		out = append(out, cmd)
+	}
+	return out
+}
@@ -5,9 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"strconv"
 	"strings"
+
+	"github.com/mingpepe/Nand2teris/diag"
+	"github.com/mingpepe/Nand2teris/srcmap"
 )
 
 const (
@@ -31,6 +33,19 @@ type VM struct {
 	arthJumpFlag    int
 	retLabelCnt     int
 	currentFilename string
+	file            string
+
+	optimize bool
+	// Top of the stack kept in D
+	top      bool
+	function string
+	// Shared routines used by the optimized code
+	callArgs map[int]bool
+	compares map[string]bool
+
+	// Lines of assembly returned so far and where they come from
+	line      int
+	sourceMap *srcmap.Map
 }
 
 func New() *VM {
@@ -38,196 +53,277 @@ func New() *VM {
 	vm.arthJumpFlag = 0
 	vm.retLabelCnt = 0
 	vm.currentFilename = ""
+	vm.callArgs = make(map[int]bool)
+	vm.compares = make(map[string]bool)
 	return vm
 }
 
+// SetFile names the source in diagnostics, otherwise the class name with a
+// .vm extension is used.
+func (vm *VM) SetFile(path string) {
+	vm.file = path
+}
+
+// SetSourceMap records in m the .vm line and function of every line of the
+// generated assembly. Lines are counted over the code BootstrapCode, Compile
+// and SharedCode return, which must be written out in that order.
+func (vm *VM) SetSourceMap(m *srcmap.Map) {
+	vm.sourceMap = m
+}
+
+// mapLines attributes the lines of code, which are written next, to loc.
+func (vm *VM) mapLines(code string, loc srcmap.Location) {
+	n := strings.Count(code, "\n")
+	if vm.sourceMap != nil && loc.Line > 0 {
+		for i := 1; i <= n; i++ {
+			vm.sourceMap.Set(vm.line+i, loc)
+		}
+	}
+	vm.line += n
+}
+
 func (vm *VM) BootstrapCode() string {
 	tmp := "@256\n" +
 		"D=A\n" +
 		"@SP\n" +
 		"M=D\n"
-	return tmp + vm.compile_line("call Sys.init 0")
+	if vm.optimize {
+		vm.mapLines(tmp, srcmap.Location{})
+		cmd, _ := parseCommand("call Sys.init 0")
+		call, _, _ := vm.compileOptimized([]Command{cmd})
+		return tmp + call
+	}
+	call, _ := vm.compile_line("call Sys.init 0")
+	vm.mapLines(tmp+call, srcmap.Location{})
+	return tmp + call
 }
 
+// Compile translates a .vm file, filename is the class name used for static
+// variables. Errors are diagnostics pointing at the offending line.
 func (vm *VM) Compile(filename string, reader io.Reader) (string, error) {
 	vm.currentFilename = filename
+	source := vm.file
+	if source == "" {
+		source = filename + ".vm"
+	}
 	scanner := bufio.NewScanner(reader)
 	lines := make([]string, 0)
+	lineNums := make([]int, 0)
+	cols := make([]int, 0)
+	lineNum := 0
 	for scanner.Scan() {
+		lineNum++
 		line := scanner.Text()
 		// Remove comments
 		idx := strings.Index(line, "//")
 		if idx > 0 {
 			line = line[:idx]
 		}
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
 		line = strings.TrimSpace(line)
 		if !skip(line) {
 			lines = append(lines, line)
+			lineNums = append(lineNums, lineNum)
+			cols = append(cols, indent+1)
 		}
 	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	function := ""
+	if vm.optimize {
+		cmds := make([]Command, len(lines))
+		for i, line := range lines {
+			cmd, err := parseCommand(line)
+			if err != nil {
+				return "", diag.Errorf(source, lineNums[i], cols[i], "%v", err)
+			}
+			if cmd.Type == C_FUNCTION {
+				function = cmd.Arg1
+			}
+			cmd.File = source
+			cmd.Line = lineNums[i]
+			cmd.Function = function
+			cmds[i] = cmd
+		}
+		asm, i, err := vm.compileOptimized(cmds)
+		if err != nil {
+			return "", diag.Errorf(source, lineNums[i], cols[i], "%v", err)
+		}
+		return asm, nil
+	}
 
 	asm := ""
 	for i := 0; i < len(lines); i++ {
-		asm += "//" + lines[i] + "\n"
-		asm += vm.compile_line(lines[i])
+		code, err := vm.compile_line(lines[i])
+		if err != nil {
+			return "", diag.Errorf(source, lineNums[i], cols[i], "%v", err)
+		}
+		if fields := strings.Fields(lines[i]); len(fields) > 1 && fields[0] == "function" {
+			function = fields[1]
+		}
+		code = "//" + lines[i] + "\n" + code
+		vm.mapLines(code, srcmap.Location{File: source, Line: lineNums[i], Function: function})
+		asm += code
 	}
 	return asm, nil
 }
 
-func (vm *VM) compile_line(line string) string {
+func (vm *VM) compile_line(line string) (string, error) {
 	cmd_type, err := getCmdType(line)
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
 	switch cmd_type {
 	case C_ARITHMETIC:
 		cmd := strings.Split(line, " ")[0]
 		switch cmd {
 		case "add":
-			return arithmeticTemplate + "M=M+D\n"
+			return arithmeticTemplate + "M=M+D\n", nil
 		case "sub":
-			return arithmeticTemplate + "M=M-D\n"
+			return arithmeticTemplate + "M=M-D\n", nil
 		case "and":
-			return arithmeticTemplate + "M=M&D\n"
+			return arithmeticTemplate + "M=M&D\n", nil
 		case "or":
-			return arithmeticTemplate + "M=M|D\n"
+			return arithmeticTemplate + "M=M|D\n", nil
 		case "not":
-			return "@SP\nA=M-1\nM=!M\n"
+			return "@SP\nA=M-1\nM=!M\n", nil
 		case "neg":
-			return "D=0\n@SP\nA=M-1\nM=D-M\n"
+			return "D=0\n@SP\nA=M-1\nM=D-M\n", nil
 		case "gt":
 			{
 				asm := generateArithCompareCode("JLE", vm.arthJumpFlag)
 				vm.arthJumpFlag++
-				return asm
+				return asm, nil
 			}
 		case "lt":
 			{
 				asm := generateArithCompareCode("JGE", vm.arthJumpFlag)
 				vm.arthJumpFlag++
-				return asm
+				return asm, nil
 			}
 		case "eq":
 			{
 				asm := generateArithCompareCode("JNE", vm.arthJumpFlag)
 				vm.arthJumpFlag++
-				return asm
+				return asm, nil
 			}
 		}
 	case C_PUSH:
 		{
 			segment, err := getArg1(line)
 			if err != nil {
-				log.Fatal(err)
+				return "", err
 			}
 			idx, err := getArg2(line)
 			if err != nil {
-				log.Fatal(err)
+				return "", err
 			}
 			switch segment {
 			case "constant":
-				return fmt.Sprintf("@%d\nD=A\n@SP\nA=M\nM=D\n@SP\nM=M+1\n", idx)
+				return fmt.Sprintf("@%d\nD=A\n@SP\nA=M\nM=D\n@SP\nM=M+1\n", idx), nil
 			case "local":
-				return generatePointerPushCode("LCL", idx)
+				return generatePointerPushCode("LCL", idx), nil
 			case "argument":
-				return generatePointerPushCode("ARG", idx)
+				return generatePointerPushCode("ARG", idx), nil
 			case "this":
-				return generatePointerPushCode("THIS", idx)
+				return generatePointerPushCode("THIS", idx), nil
 			case "that":
-				return generatePointerPushCode("THAT", idx)
+				return generatePointerPushCode("THAT", idx), nil
 			case "temp":
-				return generateDirectPushCode(fmt.Sprintf("%d", idx+5))
+				return generateDirectPushCode(fmt.Sprintf("%d", idx+5)), nil
 			case "pointer":
 				if idx == 0 {
-					return generateDirectPushCode("THIS")
+					return generateDirectPushCode("THIS"), nil
 				} else if idx == 1 {
-					return generateDirectPushCode("THAT")
+					return generateDirectPushCode("THAT"), nil
 				}
 			case "static":
-				return generateDirectPushCode(fmt.Sprintf("%s.%d", vm.currentFilename, idx))
+				return generateDirectPushCode(fmt.Sprintf("%s.%d", vm.currentFilename, idx)), nil
 			}
 		}
 	case C_POP:
 		segment, err := getArg1(line)
 		if err != nil {
-			log.Fatal(err)
+			return "", err
 		}
 		idx, err := getArg2(line)
 		if err != nil {
-			log.Fatal(err)
+			return "", err
 		}
 		switch segment {
 		case "local":
-			return generatePointerPopCode("LCL", idx)
+			return generatePointerPopCode("LCL", idx), nil
 		case "argument":
-			return generatePointerPopCode("ARG", idx)
+			return generatePointerPopCode("ARG", idx), nil
 		case "this":
-			return generatePointerPopCode("THIS", idx)
+			return generatePointerPopCode("THIS", idx), nil
 		case "that":
-			return generatePointerPopCode("THAT", idx)
+			return generatePointerPopCode("THAT", idx), nil
 		case "temp":
-			return generateDirectPopCode(fmt.Sprintf("%d", idx+5))
+			return generateDirectPopCode(fmt.Sprintf("%d", idx+5)), nil
 		case "pointer":
 			if idx == 0 {
-				return generateDirectPopCode("THIS")
+				return generateDirectPopCode("THIS"), nil
 			} else if idx == 1 {
-				return generateDirectPopCode("THAT")
+				return generateDirectPopCode("THAT"), nil
 			}
 		case "static":
-			return generateDirectPopCode(fmt.Sprintf("%s.%d", vm.currentFilename, idx))
+			return generateDirectPopCode(fmt.Sprintf("%s.%d", vm.currentFilename, idx)), nil
 		}
 	case C_LABEL:
 		{
 			name, err := getArg1(line)
 			if err != nil {
-				log.Fatal(err)
+				return "", err
 			}
-			return fmt.Sprintf("(%s)\n", name)
+			return fmt.Sprintf("(%s)\n", name), nil
 		}
 	case C_GOTO:
 		{
 			name, err := getArg1(line)
 			if err != nil {
-				log.Fatal(err)
+				return "", err
 			}
-			return fmt.Sprintf("@%s\n0;JMP\n", name)
+			return fmt.Sprintf("@%s\n0;JMP\n", name), nil
 		}
 	case C_IF:
 		{
 			name, err := getArg1(line)
 			if err != nil {
-				log.Fatal(err)
+				return "", err
 			}
-			return fmt.Sprintf("%s@%s\nD;JNE\n", arithmeticTemplate, name)
+			return fmt.Sprintf("%s@%s\nD;JNE\n", arithmeticTemplate, name), nil
 		}
 	case C_FUNCTION:
 		{
 			name, err := getArg1(line)
 			if err != nil {
-				log.Fatal(err)
+				return "", err
 			}
 			numArgs, err := getArg2(line)
 			if err != nil {
-				log.Fatal(err)
+				return "", err
 			}
 			tmp := fmt.Sprintf("(%s)\n", name)
 			for i := 0; i < numArgs; i++ {
 				// The same with push constant 0
 				tmp += fmt.Sprintf("@%d\nD=A\n@SP\nA=M\nM=D\n@SP\nM=M+1\n", 0)
 			}
-			return tmp
+			return tmp, nil
 		}
 	case C_RETURN:
-		return generateReturnCode()
+		return generateReturnCode(), nil
 	case C_CALL:
 		{
 			name, err := getArg1(line)
 			if err != nil {
-				log.Fatal(err)
+				return "", err
 			}
 			numArgs, err := getArg2(line)
 			if err != nil {
-				log.Fatal(err)
+				return "", err
 			}
 			newLabel := fmt.Sprintf("RETURN_LABEL%d", vm.retLabelCnt)
 			vm.retLabelCnt++
@@ -250,10 +346,10 @@ func (vm *VM) compile_line(line string) string {
 				"M=D\n" +
 				"@" + name + "\n" +
 				"0;JMP\n" +
-				"(" + newLabel + ")\n"
+				"(" + newLabel + ")\n", nil
 		}
 	}
-	return "unexpected return : " + line + "\n"
+	return "", errors.New("unsupported command : " + line)
 }
 
 func generateArithCompareCode(_type string, arthJumpFlag int) string {
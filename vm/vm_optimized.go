@@ -0,0 +1,409 @@
+package vm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mingpepe/Nand2teris/srcmap"
+)
+
+// Optimized code differs from the plain translation in three ways:
+//   - call, return, eq, gt and lt jump to routines shared by the whole program,
+//     SharedCode returns them,
+//   - the top of the stack is kept in D between commands, and only written to
+//     the stack when another value is pushed, before a call and at labels,
+//   - runs like push/pop, push/arithmetic and comparison/if-goto are fused.
+// Functions return their value on the stack as in the plain translation.
+// The shared routines go after the program, behind a loop ending it. Labels
+// are scoped by function as in "function$label".
+
+// SetOptimize turns the optimized translation on or off.
+func (vm *VM) SetOptimize(on bool) {
+	vm.optimize = on
+}
+
+// CountInstructions counts the ROM words of assembly code, which are the
+// lines that are neither labels nor comments.
+func CountInstructions(asm string) int {
+	n := 0
+	for _, line := range strings.Split(asm, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "//") || strings.HasPrefix(line, "(") {
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+type asmWriter struct {
+	strings.Builder
+}
+
+func (w *asmWriter) emit(lines ...string) {
+	for _, line := range lines {
+		w.WriteString(line + "\n")
+	}
+}
+
+func (vm *VM) compileOptimized(cmds []Command) (string, int, error) {
+	w := &asmWriter{}
+	vm.top = false
+	vm.function = ""
+	var loc srcmap.Location
+	for i := 0; i < len(cmds); {
+		start := w.Len()
+		n, err := vm.compileCommand(w, cmds[i:])
+		if err != nil {
+			return "", i, err
+		}
+		// fused commands each start with their comment
+		k := i
+		for _, line := range strings.SplitAfter(w.String()[start:], "\n") {
+			if k+1 < i+n && line == "//"+cmds[k+1].Text+"\n" {
+				k++
+			}
+			loc = srcmap.Location{File: cmds[k].File, Line: cmds[k].Line, Function: cmds[k].Function}
+			vm.mapLines(line, loc)
+		}
+		i += n
+	}
+	start := w.Len()
+	vm.flush(w)
+	vm.mapLines(w.String()[start:], loc)
+	return w.String(), 0, nil
+}
+
+// compileCommand translates the first commands of cmds, maybe fused with
+// the ones after it, and returns how many were translated.
+func (vm *VM) compileCommand(w *asmWriter, cmds []Command) (int, error) {
+	cmd := cmds[0]
+	w.emit("//" + cmd.Text)
+	switch cmd.Type {
+	case C_ARITHMETIC:
+		switch cmd.Arg1 {
+		case "not", "neg":
+			vm.popD(w)
+			if cmd.Arg1 == "not" {
+				w.emit("D=!D")
+			} else {
+				w.emit("D=-D")
+			}
+			vm.top = true
+			return 1, nil
+		case "add", "sub", "and", "or":
+			vm.popD(w)
+			w.emit("@SP", "AM=M-1", stackComps[cmd.Arg1])
+			vm.top = true
+			return 1, nil
+		case "eq", "gt", "lt":
+			vm.popD(w)
+			if n := vm.branch(w, cmd.Arg1, cmds[1:], true); n > 0 {
+				return 1 + n, nil
+			}
+			vm.compare(w, cmd.Arg1)
+			return 1, nil
+		}
+	case C_PUSH:
+		// the pushed value is the second operand of the next command
+		if len(cmds) > 1 && vm.top && isBinary(cmds[1]) {
+			if code, src, ok := operand(cmd.Arg1, cmd.Arg2, vm.currentFilename); ok {
+				op := cmds[1].Arg1
+				w.emit("//" + cmds[1].Text)
+				w.emit(code...)
+				if comp, ok := operandComps[op]; ok {
+					w.emit(comp + src)
+					return 2, nil
+				}
+				w.emit("D=D-" + src)
+				if n := vm.branch(w, op, cmds[2:], false); n > 0 {
+					return 2 + n, nil
+				}
+				vm.materialize(w, op)
+				return 2, nil
+			}
+		}
+		vm.flush(w)
+		code, err := load(cmd.Arg1, cmd.Arg2, vm.currentFilename)
+		if err != nil {
+			return 0, err
+		}
+		w.emit(code...)
+		vm.top = true
+		return 1, nil
+	case C_POP:
+		if seg, ok := segmentPointers[cmd.Arg1]; ok && !vm.top && cmd.Arg2 > 2 {
+			w.WriteString(generatePointerPopCode(seg, cmd.Arg2))
+			return 1, nil
+		}
+		code, err := store(cmd.Arg1, cmd.Arg2, vm.currentFilename)
+		if err != nil {
+			return 0, err
+		}
+		vm.popD(w)
+		w.emit(code...)
+		return 1, nil
+	case C_LABEL:
+		vm.flush(w)
+		w.emit("(" + vm.label(cmd.Arg1) + ")")
+		return 1, nil
+	case C_GOTO:
+		vm.flush(w)
+		w.emit("@"+vm.label(cmd.Arg1), "0;JMP")
+		return 1, nil
+	case C_IF:
+		vm.popD(w)
+		w.emit("@"+vm.label(cmd.Arg1), "D;JNE")
+		return 1, nil
+	case C_FUNCTION:
+		vm.flush(w)
+		vm.function = cmd.Arg1
+		w.emit("(" + cmd.Arg1 + ")")
+		switch {
+		case cmd.Arg2 == 1:
+			w.emit("@SP", "AM=M+1", "A=A-1", "M=0")
+		case cmd.Arg2 > 1:
+			w.emit("@SP", "A=M", "M=0")
+			for i := 1; i < cmd.Arg2; i++ {
+				w.emit("A=A+1", "M=0")
+			}
+			w.emit("D=A+1", "@SP", "M=D")
+		}
+		return 1, nil
+	case C_RETURN:
+		vm.popD(w)
+		w.emit("@$RETURN", "0;JMP")
+		return 1, nil
+	case C_CALL:
+		vm.flush(w)
+		ret := fmt.Sprintf("RETURN_LABEL%d", vm.retLabelCnt)
+		vm.retLabelCnt++
+		w.emit("@"+cmd.Arg1, "D=A", "@R13", "M=D")
+		w.emit("@"+ret, "D=A", fmt.Sprintf("@$CALL%d", cmd.Arg2), "0;JMP")
+		w.emit("(" + ret + ")")
+		vm.callArgs[cmd.Arg2] = true
+		return 1, nil
+	}
+	return 0, fmt.Errorf("unsupported command : %s", cmd.Text)
+}
+
+func (vm *VM) label(name string) string {
+	if vm.function == "" {
+		return name
+	}
+	return labelKey(vm.function, name)
+}
+
+// flush writes the top of the stack kept in D to the stack.
+func (vm *VM) flush(w *asmWriter) {
+	if vm.top {
+		w.emit("@SP", "AM=M+1", "A=A-1", "M=D")
+		vm.top = false
+	}
+}
+
+// popD moves the top of the stack into D.
+func (vm *VM) popD(w *asmWriter) {
+	if !vm.top {
+		w.emit("@SP", "AM=M-1", "D=M")
+	}
+	vm.top = false
+}
+
+func isBinary(cmd Command) bool {
+	if cmd.Type != C_ARITHMETIC {
+		return false
+	}
+	return cmd.Arg1 != "not" && cmd.Arg1 != "neg"
+}
+
+// stackComps compute a binary command on the first operand in M and the
+// second in D, operandComps on the first in D and the second in A or M.
+var stackComps = map[string]string{
+	"add": "D=D+M",
+	"sub": "D=M-D",
+	"and": "D=D&M",
+	"or":  "D=D|M",
+}
+
+var operandComps = map[string]string{
+	"add": "D=D+",
+	"sub": "D=D-",
+	"and": "D=D&",
+	"or":  "D=D|",
+}
+
+var jumps = map[string]string{
+	"eq": "JEQ",
+	"gt": "JGT",
+	"lt": "JLT",
+}
+
+var negatedJumps = map[string]string{
+	"eq": "JNE",
+	"gt": "JLE",
+	"lt": "JGE",
+}
+
+// branch fuses a comparison with the if-goto after it, maybe negated by a
+// not, and returns how many commands after the comparison it took. The
+// second operand is in D and the first on the stack, or their difference is
+// in D.
+func (vm *VM) branch(w *asmWriter, op string, next []Command, operands bool) int {
+	n := 0
+	jump := jumps[op]
+	if len(next) > 1 && next[0].Type == C_ARITHMETIC && next[0].Arg1 == "not" && next[1].Type == C_IF {
+		n = 2
+		jump = negatedJumps[op]
+	} else if len(next) > 0 && next[0].Type == C_IF {
+		n = 1
+	} else {
+		return 0
+	}
+	for _, cmd := range next[:n] {
+		w.emit("//" + cmd.Text)
+	}
+	if operands {
+		w.emit("@SP", "AM=M-1", "D=M-D")
+	}
+	w.emit("@"+vm.label(next[n-1].Arg1), "D;"+jump)
+	vm.top = false
+	return n
+}
+
+// compare calls the shared routine of a comparison, the second operand is in
+// D and the first on the stack.
+func (vm *VM) compare(w *asmWriter, op string) {
+	ret := fmt.Sprintf("RETURN_LABEL%d", vm.retLabelCnt)
+	vm.retLabelCnt++
+	w.emit("@R13", "M=D", "@"+ret, "D=A", "@$"+strings.ToUpper(op), "0;JMP", "("+ret+")")
+	vm.compares[op] = true
+	vm.top = true
+}
+
+// materialize turns the difference of the operands in D into true or false.
+func (vm *VM) materialize(w *asmWriter, op string) {
+	t := fmt.Sprintf("TRUE%d", vm.arthJumpFlag)
+	end := fmt.Sprintf("CONTINUE%d", vm.arthJumpFlag)
+	vm.arthJumpFlag++
+	w.emit("@"+t, "D;"+jumps[op], "D=0", "@"+end, "0;JMP", "("+t+")", "D=-1", "("+end+")")
+	vm.top = true
+}
+
+var segmentPointers = map[string]string{
+	"local":    "LCL",
+	"argument": "ARG",
+	"this":     "THIS",
+	"that":     "THAT",
+}
+
+// operand returns the code pointing A at a value without using D, and
+// whether the value is then A or M. Values behind a segment pointer with a
+// large index need D.
+func operand(segment string, idx int, class string) ([]string, string, bool) {
+	switch segment {
+	case "constant":
+		return []string{fmt.Sprintf("@%d", idx)}, "A", true
+	case "temp":
+		return []string{fmt.Sprintf("@%d", idx+5)}, "M", true
+	case "pointer":
+		if idx == 0 {
+			return []string{"@THIS"}, "M", true
+		} else if idx == 1 {
+			return []string{"@THAT"}, "M", true
+		}
+	case "static":
+		return []string{fmt.Sprintf("@%s.%d", class, idx)}, "M", true
+	case "local", "argument", "this", "that":
+		code := []string{"@" + segmentPointers[segment]}
+		switch idx {
+		case 0:
+			return append(code, "A=M"), "M", true
+		case 1:
+			return append(code, "A=M+1"), "M", true
+		case 2:
+			return append(code, "A=M+1", "A=A+1"), "M", true
+		}
+	}
+	return nil, "", false
+}
+
+// load returns the code putting a value in D.
+func load(segment string, idx int, class string) ([]string, error) {
+	if segment == "constant" && (idx == 0 || idx == 1) {
+		return []string{fmt.Sprintf("D=%d", idx)}, nil
+	}
+	if code, src, ok := operand(segment, idx, class); ok {
+		return append(code, "D="+src), nil
+	}
+	if seg, ok := segmentPointers[segment]; ok {
+		return []string{"@" + seg, "D=M", fmt.Sprintf("@%d", idx), "A=D+A", "D=M"}, nil
+	}
+	return nil, fmt.Errorf("unsupported segment : push %s %d", segment, idx)
+}
+
+// store returns the code writing D to a segment.
+func store(segment string, idx int, class string) ([]string, error) {
+	if segment != "constant" {
+		if code, _, ok := operand(segment, idx, class); ok {
+			return append(code, "M=D"), nil
+		}
+	}
+	if seg, ok := segmentPointers[segment]; ok {
+		return []string{
+			"@R13", "M=D",
+			"@" + seg, "D=M", fmt.Sprintf("@%d", idx), "D=D+A", "@R14", "M=D",
+			"@R13", "D=M", "@R14", "A=M", "M=D",
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported segment : pop %s %d", segment, idx)
+}
+
+// SharedCode returns the routines the optimized code translated so far jumps
+// to. It goes once after all the files of a program, and starts with an
+// infinite loop so that a program running off its end stops there.
+func (vm *VM) SharedCode() string {
+	w := &asmWriter{}
+	w.emit("// end of the program", "($END)", "@$END", "0;JMP")
+	w.emit("// return: D is the return value")
+	w.emit("($RETURN)", "@R13", "M=D")
+	w.emit("@LCL", "D=M", "@R14", "M=D")
+	w.emit("@5", "A=D-A", "D=M", "@R15", "M=D")
+	w.emit("@R13", "D=M", "@ARG", "A=M", "M=D")
+	w.emit("@ARG", "D=M+1", "@SP", "M=D")
+	for _, seg := range []string{"THAT", "THIS", "ARG", "LCL"} {
+		w.emit("@R14", "AM=M-1", "D=M", "@"+seg, "M=D")
+	}
+	w.emit("@R15", "A=M", "0;JMP")
+
+	nArgs := make([]int, 0)
+	for n := range vm.callArgs {
+		nArgs = append(nArgs, n)
+	}
+	sort.Ints(nArgs)
+	for _, n := range nArgs {
+		w.emit(fmt.Sprintf("// call with %d arguments: D is the return address, R13 the function", n))
+		w.emit(fmt.Sprintf("($CALL%d)", n), "@SP", "AM=M+1", "A=A-1", "M=D")
+		for _, seg := range []string{"LCL", "ARG", "THIS", "THAT"} {
+			w.emit("@"+seg, "D=M", "@SP", "AM=M+1", "A=A-1", "M=D")
+		}
+		w.emit("@SP", "D=M", "@LCL", "M=D")
+		w.emit(fmt.Sprintf("@%d", n+5), "D=D-A", "@ARG", "M=D")
+		w.emit("@R13", "A=M", "0;JMP")
+	}
+
+	for _, op := range []string{"eq", "gt", "lt"} {
+		if !vm.compares[op] {
+			continue
+		}
+		name := "$" + strings.ToUpper(op)
+		w.emit("// " + op + ": D is the return address, R13 the second operand")
+		w.emit("("+name+")", "@R14", "M=D")
+		w.emit("@R13", "D=M", "@SP", "AM=M-1", "D=M-D")
+		w.emit("@"+name+"_TRUE", "D;"+jumps[op], "D=0", "@R14", "A=M", "0;JMP")
+		w.emit("("+name+"_TRUE)", "D=-1", "@R14", "A=M", "0;JMP")
+	}
+	vm.mapLines(w.String(), srcmap.Location{})
+	return w.String()
+}